@@ -0,0 +1,943 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"token-tui/dispenser/cassette"
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/reconcile"
+	"token-tui/dispenser/settlement"
+	"token-tui/dispenser/soak"
+	"token-tui/dispenser/timebase"
+)
+
+// Exit codes of the non-interactive subcommands, so scripts can branch on
+// the outcome without parsing output
+const (
+	exitOK           = 0
+	exitError        = 1 // anything not covered below
+	exitUsage        = 2
+	exitPartial      = 3 // some but not all tokens dispensed
+	exitFault        = 4 // jam or hardware error, dispenser in error state
+	exitBusy         = 5 // another transaction is active
+	exitUnauthorized = 6
+	exitUnreachable  = 7
+	exitUnknown      = 8  // tx not found / outcome unknown
+	exitMismatch     = 9  // reconcile found discrepancies
+	exitPending      = 10 // tx still queued or dispensing
+)
+
+const defaultEndpoint = "http://192.168.4.20"
+
+// connFlags are the connection settings shared by the TUI and every
+// subcommand
+type connFlags struct {
+	fs       *flag.FlagSet
+	endpoint string
+	apiKey   string
+	timeout  time.Duration
+	journal  string
+
+	// HTTPS, e.g. through dispenser-proxy
+	tlsCA, tlsCert, tlsKey string
+
+	// Cassette of all requests for dispenser-replay, shared by the clients
+	// of a fleet
+	record   string
+	recorder *cassette.Recorder
+
+	// Config file and the profile that fills in settings not given as
+	// flags or environment variables
+	configPath       string
+	profileName      string
+	healthInterval   time.Duration
+	pollInterval     time.Duration
+	expectedFirmware string
+
+	resolved    bool
+	resolveErr  error
+	configFound bool
+	sources     map[string]string // setting -> where its value came from
+}
+
+func addConnFlags(fs *flag.FlagSet) *connFlags {
+	cf := &connFlags{fs: fs}
+	fs.StringVar(&cf.endpoint, "endpoint", defaultEndpoint, "Dispenser base URL (or TOKEN_DISPENSER_ENDPOINT env)")
+	fs.StringVar(&cf.apiKey, "api-key", "", "API key for dispenser (or TOKEN_DISPENSER_API_KEY env)")
+	fs.DurationVar(&cf.timeout, "timeout", 3*time.Second, "HTTP request timeout")
+	fs.StringVar(&cf.journal, "journal", "", "Transaction journal file (or TOKEN_DISPENSER_JOURNAL env)")
+	fs.StringVar(&cf.tlsCA, "tls-ca", "", "CA certificate to verify an HTTPS endpoint (default: system roots)")
+	fs.StringVar(&cf.tlsCert, "tls-cert", "", "Client certificate for mutual TLS")
+	fs.StringVar(&cf.tlsKey, "tls-key", "", "Private key of --tls-cert")
+	fs.StringVar(&cf.record, "record", "", "Record every request and response to a cassette file for dispenser-replay")
+	fs.StringVar(&cf.configPath, "config", "", "Config file with named profiles (or TOKEN_DISPENSER_CONFIG env, default "+defaultConfigPath()+")")
+	fs.StringVar(&cf.profileName, "profile", "", "Config file profile (or TOKEN_DISPENSER_PROFILE env, default: the file's default_profile)")
+	fs.DurationVar(&cf.healthInterval, "health-interval", healthInterval, "Health refresh interval")
+	fs.DurationVar(&cf.pollInterval, "poll-interval", pollInterval, "Transaction status poll interval")
+	fs.StringVar(&cf.expectedFirmware, "expected-firmware", "", "Firmware version to warn about when the dispenser reports another")
+	return cf
+}
+
+// isSet reports whether a flag was given explicitly on the command line
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// resolve applies the precedence flag > environment > config profile >
+// default. It runs once; later calls return the first result.
+func (cf *connFlags) resolve() error {
+	if !cf.resolved {
+		cf.resolved = true
+		if err := cf.load(); err != nil {
+			cf.resolveErr = &configError{err}
+		}
+	}
+	return cf.resolveErr
+}
+
+func (cf *connFlags) load() error {
+	cf.sources = make(map[string]string)
+	explicit := true
+	switch env := os.Getenv("TOKEN_DISPENSER_CONFIG"); {
+	case isSet(cf.fs, "config"):
+		cf.sources["config"] = sourceFlag + " --config"
+	case env != "":
+		cf.configPath = env
+		cf.sources["config"] = sourceEnv + " TOKEN_DISPENSER_CONFIG"
+	default:
+		cf.configPath = defaultConfigPath()
+		cf.sources["config"] = sourceDefault
+		explicit = false
+	}
+	cfg, found, err := loadConfigFile(cf.configPath, explicit)
+	if err != nil {
+		return err
+	}
+	cf.configFound = found
+
+	name, prof, err := cfg.selectProfile(cf.profileName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case isSet(cf.fs, "profile"):
+		cf.sources["profile"] = sourceFlag + " --profile"
+	case os.Getenv("TOKEN_DISPENSER_PROFILE") != "":
+		cf.sources["profile"] = sourceEnv + " TOKEN_DISPENSER_PROFILE"
+	case name != "":
+		cf.sources["profile"] = "config file"
+	}
+	cf.profileName = name
+	fromProfile := sourceProfile + " " + name
+
+	str := func(key, flagName, env string, dst *string, prof string) {
+		switch {
+		case isSet(cf.fs, flagName):
+			cf.sources[key] = sourceFlag + " --" + flagName
+		case env != "" && os.Getenv(env) != "":
+			*dst = os.Getenv(env)
+			cf.sources[key] = sourceEnv + " " + env
+		case prof != "":
+			*dst = prof
+			cf.sources[key] = fromProfile
+		default:
+			cf.sources[key] = sourceDefault
+		}
+	}
+	dur := func(key, flagName string, dst *time.Duration, prof time.Duration) {
+		switch {
+		case isSet(cf.fs, flagName):
+			cf.sources[key] = sourceFlag + " --" + flagName
+		case prof > 0:
+			*dst = prof
+			cf.sources[key] = fromProfile
+		default:
+			cf.sources[key] = sourceDefault
+		}
+	}
+
+	str("endpoint", "endpoint", "TOKEN_DISPENSER_ENDPOINT", &cf.endpoint, prof.Endpoint)
+	str("api_key", "api-key", "TOKEN_DISPENSER_API_KEY", &cf.apiKey, prof.APIKey)
+	if cf.sources["api_key"] == sourceDefault && prof.APIKeyCommand != "" {
+		key, err := runKeyCommand(prof.APIKeyCommand)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		cf.apiKey = key
+		cf.sources["api_key"] = fromProfile + " (api_key_command)"
+	}
+	dur("timeout", "timeout", &cf.timeout, durationMS(prof.TimeoutMS))
+	dur("health_interval", "health-interval", &cf.healthInterval, durationS(prof.HealthIntervalS))
+	dur("poll_interval", "poll-interval", &cf.pollInterval, durationMS(prof.PollIntervalMS))
+	str("expected_firmware", "expected-firmware", "", &cf.expectedFirmware, prof.ExpectedFirmware)
+	str("journal", "journal", "TOKEN_DISPENSER_JOURNAL", &cf.journal, "")
+	return nil
+}
+
+func (cf *connFlags) client() (*client.DispenserClient, error) {
+	if err := cf.resolve(); err != nil {
+		return nil, err
+	}
+	return cf.clientFor(cf.endpoint, cf.apiKey)
+}
+
+// clientFor returns a client for another dispenser of a fleet, with the
+// shared timeout and TLS settings
+func (cf *connFlags) clientFor(endpoint, apiKey string) (*client.DispenserClient, error) {
+	c := client.NewDispenserClient(endpoint, apiKey, cf.timeout)
+	if cf.tlsCA != "" || cf.tlsCert != "" || cf.tlsKey != "" {
+		if err := c.UseTLS(cf.tlsCA, cf.tlsCert, cf.tlsKey); err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+	if cf.record != "" {
+		if cf.recorder == nil {
+			f, err := os.Create(cf.record)
+			if err != nil {
+				return nil, fmt.Errorf("record: %w", err)
+			}
+			// Interactions are written whole and unbuffered, so the file
+			// is left for the process exit to close
+			cf.recorder = cassette.NewRecorder(f)
+		}
+		c.Record(cf.recorder)
+	}
+	return c, nil
+}
+
+// openJournal attaches the configured journal to c. The returned function
+// closes it; it is a no-op when no journal is configured.
+func (cf *connFlags) openJournal(c *client.DispenserClient) (func(), error) {
+	if err := cf.resolve(); err != nil {
+		return nil, err
+	}
+	if cf.journal == "" {
+		return func() {}, nil
+	}
+	j, err := client.OpenJournal(cf.journal)
+	if err != nil {
+		return nil, err
+	}
+	c.Journal = j
+	return func() { j.Close() }, nil
+}
+
+// payFlags enable settlement of finished dispenses into a payment file
+type payFlags struct {
+	payments string
+	price    int64
+}
+
+func addPayFlags(fs *flag.FlagSet) *payFlags {
+	pf := &payFlags{}
+	fs.StringVar(&pf.payments, "payments", "", "Record payments for dispensed tokens in this file")
+	fs.Int64Var(&pf.price, "price", 0, "Price per token in cents, required with --payments")
+	return pf
+}
+
+// open returns a settler writing to the payment file, or nil when no file
+// is configured. The returned function closes the file.
+func (pf *payFlags) open() (*settlement.Settler, func(), error) {
+	if pf.payments == "" {
+		return nil, func() {}, nil
+	}
+	if pf.price <= 0 {
+		return nil, nil, errors.New("--price must be positive with --payments")
+	}
+	sink, err := settlement.OpenFileSink(pf.payments)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &settlement.Settler{Sink: sink, PricePerToken: pf.price}, func() { sink.Close() }, nil
+}
+
+// subcommands maps names to their implementations
+var subcommands = map[string]func(args []string) int{
+	"health":    runHealth,
+	"dispense":  runDispense,
+	"status":    runStatus,
+	"watch":     runWatch,
+	"soak":      runSoak,
+	"recover":   runRecover,
+	"reconcile": runReconcile,
+	"config":    runConfig,
+}
+
+// newCommandFlags returns a flag set with the connection and output flags
+func newCommandFlags(name, usage string) (*flag.FlagSet, *connFlags, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cf := addConnFlags(fs)
+	output := fs.String("output", "human", "Output format: human or json")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: token-tui %s\n\nFlags:\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs, cf, output
+}
+
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// --- health ---
+
+func runHealth(args []string) int {
+	fs, cf, output := newCommandFlags("health", "health [flags]")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	c, err := cf.client()
+	if err != nil {
+		return fail(err)
+	}
+	health, result := c.Health(ctx)
+	if result.Error != nil {
+		return fail(result.Error)
+	}
+
+	if *output == "json" {
+		printJSON(health)
+	} else {
+		printHealth(os.Stdout, health, result.Latency, cf.expectedFirmware)
+	}
+
+	if health.Dispenser == "error" {
+		return exitFault
+	}
+	return exitOK
+}
+
+func printHealth(w io.Writer, h *client.HealthResponse, latency time.Duration, expectedFirmware string) {
+	var tb timebase.TimeBase
+	tb.Observe(h, time.Now(), latency)
+
+	fmt.Fprintf(w, "status:     %s\n", h.Status)
+	fmt.Fprintf(w, "dispenser:  %s\n", h.Dispenser)
+	fmt.Fprintf(w, "uptime:     %s\n", formatDuration(h.Uptime))
+	if expectedFirmware != "" && h.Firmware != expectedFirmware {
+		fmt.Fprintf(w, "firmware:   %s (expected %s)\n", h.Firmware, expectedFirmware)
+	} else {
+		fmt.Fprintf(w, "firmware:   %s\n", h.Firmware)
+	}
+	if h.WiFi != nil {
+		fmt.Fprintf(w, "wifi:       %d dBm (%s)\n", h.WiFi.RSSI, h.WiFi.SSID)
+	}
+	met := h.Metrics
+	fmt.Fprintf(w, "dispenses:  %d total, %d ok, %d jams, %d partial, %d failures\n",
+		met.TotalDispenses, met.Successful, met.Jams, met.Partial, met.Failures)
+	if h.ActiveTx != nil {
+		fmt.Fprintf(w, "active tx:  %s (%d/%d)\n", h.ActiveTx.TxID, h.ActiveTx.Dispensed, h.ActiveTx.Quantity)
+	}
+	if h.Error != nil && h.Error.Active {
+		fmt.Fprintf(w, "error:      %s (code %d) %s, since %s\n", h.Error.Type, h.Error.Code, h.Error.Description,
+			tb.Format(h.Error.Timestamp, "2006-01-02 15:04:05"))
+	}
+	for _, e := range h.ErrorHistory {
+		cleared := ""
+		if e.Cleared {
+			cleared = ", cleared"
+		}
+		fmt.Fprintf(w, "past error: %s (code %d) at %s%s\n", e.Type, e.Code,
+			tb.Format(e.Timestamp, "2006-01-02 15:04:05"), cleared)
+	}
+	fmt.Fprintf(w, "latency:    %dms\n", latency.Milliseconds())
+}
+
+// --- dispense ---
+
+func runDispense(args []string) int {
+	fs, cf, output := newCommandFlags("dispense", "dispense --qty N [--tx-id ID] [--wait [--payments FILE --price CENTS]] [flags]")
+	qty := fs.Int("qty", 1, "Number of tokens (1-20)")
+	txIDFlag := fs.String("tx-id", "", "Transaction ID to retry (1-16 chars, sent exactly as given); generated if empty")
+	txPrefix := fs.String("tx-prefix", "", "Hex terminal prefix for generated transaction IDs")
+	wait := fs.Bool("wait", false, "Wait until the dispense is done or failed")
+	pf := addPayFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *qty < 1 || *qty > 20 {
+		fmt.Fprintln(os.Stderr, "Error: --qty must be 1-20")
+		return exitUsage
+	}
+	if pf.payments != "" && !*wait {
+		fmt.Fprintln(os.Stderr, "Error: --payments requires --wait")
+		return exitUsage
+	}
+	settler, closePayments, err := pf.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	defer closePayments()
+
+	txID, err := resolveTxID(*txIDFlag, *txPrefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	c, err := cf.client()
+	if err != nil {
+		return fail(err)
+	}
+	closeJournal, err := cf.openJournal(c)
+	if err != nil {
+		return fail(err)
+	}
+	defer closeJournal()
+
+	if !*wait {
+		resp, result := c.Dispense(ctx, txID, *qty)
+		if result.Error != nil {
+			return fail(result.Error)
+		}
+		if *output == "json" {
+			printJSON(resp)
+		} else {
+			fmt.Printf("tx %s: %s (%d/%d)\n", resp.TxID, resp.State, resp.Dispensed, resp.Quantity)
+		}
+		return exitCodeForState(resp.State, resp.Dispensed, resp.Quantity)
+	}
+
+	opts := client.WaitOptions{PollInterval: cf.pollInterval}
+	if *output != "json" {
+		fmt.Printf("tx %s: dispensing %d tokens\n", txID, *qty)
+		opts.OnProgress = func(r client.DispenseResponse) {
+			fmt.Printf("  %d/%d\n", r.Dispensed, r.Quantity)
+		}
+	}
+
+	res, err := c.DispenseAndWait(ctx, txID, *qty, opts)
+
+	// Only a final state is charged; an unknown outcome waits for review
+	var payment *settlement.Payment
+	var payErr error
+	if settler != nil && err == nil {
+		payment, payErr = settler.Settle(ctx, res)
+	}
+
+	if *output == "json" {
+		printJSON(struct {
+			*client.DispenseResult
+			Payment      *settlement.Payment `json:"payment,omitempty"`
+			PaymentError string              `json:"payment_error,omitempty"`
+		}{res, payment, errString(payErr)})
+	} else if err == nil || errors.Is(err, client.ErrOutcomeUnknown) {
+		fmt.Printf("tx %s: %s (%d/%d) in %s\n", res.TxID, res.Outcome, res.Dispensed, res.Quantity,
+			res.Duration.Truncate(time.Millisecond))
+		if settler != nil && payErr == nil {
+			printPayment(res.TxID, payment)
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if payErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", payErr)
+		return exitError
+	}
+	return exitCodeForState(res.State, res.Dispensed, res.Quantity)
+}
+
+// printPayment describes the charge for a settled transaction
+func printPayment(txID string, p *settlement.Payment) {
+	if p == nil {
+		fmt.Printf("tx %s: nothing dispensed, not charged\n", txID)
+		return
+	}
+	fmt.Printf("tx %s: charged %d tokens × %s = %s (%s)\n", txID, p.Tokens,
+		formatCents(p.PricePerToken), formatCents(p.Amount), p.PaymentID)
+}
+
+// resolveTxID validates a user-supplied ID or generates a new one
+func resolveTxID(given, prefix string) (string, error) {
+	if given != "" {
+		id, err := client.ParseTxID(given)
+		return id.String(), err
+	}
+	gen, err := client.NewTxIDGenerator(client.TxIDOptions{Prefix: prefix})
+	if err != nil {
+		return "", err
+	}
+	id, err := gen.Next()
+	return id.String(), err
+}
+
+// --- status ---
+
+func runStatus(args []string) int {
+	fs, cf, output := newCommandFlags("status", "status [flags] <tx_id>")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	c, err := cf.client()
+	if err != nil {
+		return fail(err)
+	}
+	resp, result := c.Status(ctx, fs.Arg(0))
+	if result.Error != nil {
+		return fail(result.Error)
+	}
+
+	if *output == "json" {
+		printJSON(resp)
+	} else {
+		fmt.Printf("tx %s: %s (%d/%d)\n", resp.TxID, resp.State, resp.Dispensed, resp.Quantity)
+	}
+	return exitCodeForState(resp.State, resp.Dispensed, resp.Quantity)
+}
+
+// --- recover ---
+
+func runRecover(args []string) int {
+	fs, cf, output := newCommandFlags("recover", "recover --journal FILE [--payments FILE --price CENTS] [--ack TX_ID --note TEXT] [flags]")
+	ack := fs.String("ack", "", "Mark this unknown-outcome transaction as reviewed")
+	note := fs.String("note", "", "Review note for --ack, e.g. what the customer received")
+	pf := addPayFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	settler, closePayments, err := pf.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	defer closePayments()
+
+	c, err := cf.client()
+	if err != nil {
+		return fail(err)
+	}
+	if cf.journal == "" {
+		fmt.Fprintln(os.Stderr, "Error: --journal or TOKEN_DISPENSER_JOURNAL is required")
+		return exitUsage
+	}
+	closeJournal, err := cf.openJournal(c)
+	if err != nil {
+		return fail(err)
+	}
+	defer closeJournal()
+
+	if *ack != "" {
+		if err := c.Journal.Acknowledge(*ack, *note); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		fmt.Printf("tx %s: marked as reviewed\n", *ack)
+		return exitOK
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	recovered, err := c.Recover(ctx, client.WaitOptions{PollInterval: cf.pollInterval})
+	review := c.Journal.NeedsReview()
+
+	// Charge transactions that finished without a payment, e.g. because
+	// the terminal crashed before settling them
+	var settled []settlement.Settlement
+	var payErr error
+	if settler != nil && err == nil {
+		settled, payErr = settler.SettleJournal(ctx, c.Journal)
+	}
+
+	if *output == "json" {
+		type recovery struct {
+			client.JournalRecord
+			RecoveryError string `json:"recovery_error,omitempty"`
+		}
+		out := struct {
+			Recovered   []recovery              `json:"recovered"`
+			NeedsReview []client.JournalRecord  `json:"needs_review"`
+			Settled     []settlement.Settlement `json:"settled,omitempty"`
+		}{[]recovery{}, review, nil}
+		for _, r := range recovered {
+			out.Recovered = append(out.Recovered, recovery{r.Record, errString(r.Err)})
+		}
+		for _, st := range settled {
+			if st.New {
+				out.Settled = append(out.Settled, st)
+			}
+		}
+		printJSON(out)
+	} else {
+		for _, r := range recovered {
+			rec := r.Record
+			if r.Err != nil {
+				fmt.Printf("tx %s: still %s, %v\n", rec.TxID, rec.State, r.Err)
+				continue
+			}
+			fmt.Printf("tx %s: %s (%d/%d)\n", rec.TxID, rec.State, rec.Dispensed, rec.Quantity)
+		}
+		if len(recovered) == 0 {
+			fmt.Println("no unfinished transactions")
+		}
+		if len(review) > 0 {
+			fmt.Printf("\n%d transaction(s) need manual review:\n", len(review))
+			for _, rec := range review {
+				fmt.Printf("  %s  %s  qty %d  %s\n", rec.Time.Format(time.RFC3339), rec.TxID, rec.Quantity, rec.Note)
+			}
+		}
+		for _, st := range settled {
+			if st.New {
+				printPayment(st.TxID, st.Payment)
+			}
+		}
+	}
+
+	if err != nil {
+		return fail(err)
+	}
+	if payErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", payErr)
+		return exitError
+	}
+	for _, r := range recovered {
+		if r.Err != nil {
+			return exitCodeFor(r.Err)
+		}
+	}
+	if len(review) > 0 {
+		return exitUnknown
+	}
+	return exitOK
+}
+
+// --- reconcile ---
+
+func runReconcile(args []string) int {
+	fs, cf, output := newCommandFlags("reconcile", "reconcile --journal FILE [--state FILE] [flags]")
+	state := fs.String("state", "", "Checkpoint file of the last run (default <journal>.reconcile.json)")
+	dryRun := fs.Bool("dry-run", false, "Report without saving the checkpoint")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	c, err := cf.client()
+	if err != nil {
+		return fail(err)
+	}
+	if cf.journal == "" {
+		fmt.Fprintln(os.Stderr, "Error: --journal or TOKEN_DISPENSER_JOURNAL is required")
+		return exitUsage
+	}
+	if *state == "" {
+		*state = cf.journal + ".reconcile.json"
+	}
+	// The journal is read, not attached: reconciling must not change it
+	j, err := client.ReadJournal(cf.journal)
+	if err != nil {
+		return fail(err)
+	}
+	prev, err := reconcile.LoadCheckpoint(*state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	rc := &reconcile.Reconciler{Client: c, Journal: j}
+	rep, err := rc.Run(ctx, prev)
+	if err != nil {
+		return fail(err)
+	}
+	if !*dryRun {
+		if err := reconcile.SaveCheckpoint(*state, rep.Current); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: saving checkpoint: %v\n", err)
+			return exitError
+		}
+	}
+
+	if *output == "json" {
+		printJSON(rep)
+	} else {
+		rep.WriteText(os.Stdout)
+	}
+	if !rep.OK() {
+		return exitMismatch
+	}
+	return exitOK
+}
+
+// --- watch ---
+
+func runWatch(args []string) int {
+	fs, cf, output := newCommandFlags("watch", "watch [--interval 5s] [flags]")
+	interval := fs.Duration("interval", healthInterval, "Health poll interval (default --health-interval)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	c, err := cf.client()
+	if err != nil {
+		return fail(err)
+	}
+	if !isSet(fs, "interval") {
+		*interval = cf.healthInterval
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		health, result := c.Health(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return exitOK
+		case *output == "json":
+			// One compact object per line (JSON Lines)
+			json.NewEncoder(os.Stdout).Encode(struct {
+				Time      time.Time              `json:"time"`
+				LatencyMS int64                  `json:"latency_ms"`
+				Error     string                 `json:"error,omitempty"`
+				Health    *client.HealthResponse `json:"health,omitempty"`
+			}{time.Now(), result.Latency.Milliseconds(), errString(result.Error), health})
+		case result.Error != nil:
+			fmt.Printf("%s  ERR %v\n", time.Now().Format("15:04:05"), result.Error)
+		default:
+			fmt.Printf("%s  %-8s %-10s up %-8s rssi %4s  %d/%d ok  %4dms\n",
+				time.Now().Format("15:04:05"), health.Status, health.Dispenser,
+				formatDuration(health.Uptime), rssiString(health.WiFi),
+				health.Metrics.Successful, health.Metrics.TotalDispenses, result.Latency.Milliseconds())
+		}
+
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case <-ticker.C:
+		}
+	}
+}
+
+// --- soak ---
+
+func runSoak(args []string) int {
+	fs, cf, output := newCommandFlags("soak", "soak [--duration 1h | --tokens N] [--pattern 1,3] [flags]")
+	scfg := addSoakFlags(fs, "")
+	txPrefix := fs.String("tx-prefix", "", "Hex terminal prefix for generated transaction IDs")
+	report := fs.String("report", "", "Report path without extension (default soak-<time>)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	cfg, err := scfg.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	cfg.TxPrefix = *txPrefix
+
+	c, err := cf.client()
+	if err != nil {
+		return fail(err)
+	}
+	runner, err := soak.New(c, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	enc := json.NewEncoder(os.Stdout)
+	rep, err := runner.Run(ctx, func(ev soak.Event) {
+		if *output == "json" {
+			enc.Encode(ev)
+			return
+		}
+		st := ev.Stats
+		fmt.Printf("%s  %-8s %s  [%d tokens, jam %.1f%%, partial %.1f%%]\n",
+			ev.Time.Format("15:04:05"), ev.Kind, ev.Message,
+			st.TokensDispensed, st.JamRate(), st.PartialRate())
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	base := *report
+	if base == "" {
+		base = "soak-" + rep.Started.Format("20060102-150405")
+	}
+	mdPath, jsonPath, err := rep.WriteFiles(base)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: writing report: %v\n", err)
+		return exitError
+	}
+	if *output != "json" {
+		fmt.Printf("\n%s after %s: %d dispenses, %d tokens, jam rate %.2f%%\n",
+			rep.StopReason, rep.Elapsed.Truncate(time.Second), rep.Stats.Dispenses,
+			rep.Stats.TokensDispensed, rep.JamRate)
+		fmt.Printf("report: %s, %s\n", mdPath, jsonPath)
+	}
+
+	if rep.Aborted {
+		return exitFault
+	}
+	return exitOK
+}
+
+// soakFlags are the soak settings shared by the CLI and the TUI
+type soakFlags struct {
+	pattern      string
+	duration     time.Duration
+	tokens       int
+	interval     time.Duration
+	onHopperLow  string
+	onFault      string
+	pauseTimeout time.Duration
+}
+
+// addSoakFlags registers the soak settings, with names prefixed by prefix
+// so the TUI can offer them as --soak-duration and so on
+func addSoakFlags(fs *flag.FlagSet, prefix string) *soakFlags {
+	def := soak.DefaultConfig()
+	sf := &soakFlags{}
+	fs.StringVar(&sf.pattern, prefix+"pattern", def.PatternString(), "Comma separated soak quantities, dispensed in turn")
+	fs.DurationVar(&sf.duration, prefix+"duration", def.Duration, "Soak duration (0 = token limit only)")
+	fs.IntVar(&sf.tokens, prefix+"tokens", 0, "Stop the soak after this many tokens (0 = duration only)")
+	fs.DurationVar(&sf.interval, prefix+"interval", def.Interval, "Pause between soak dispenses")
+	fs.StringVar(&sf.onHopperLow, prefix+"on-hopper-low", string(def.OnHopperLow), "pause or abort the soak while the hopper is low")
+	fs.StringVar(&sf.onFault, prefix+"on-fault", string(def.OnFault), "pause or abort the soak while the dispenser is in error state")
+	fs.DurationVar(&sf.pauseTimeout, prefix+"pause-timeout", def.PauseTimeout, "Abort the soak when paused longer (0 = wait forever)")
+	return sf
+}
+
+func (sf *soakFlags) config() (soak.Config, error) {
+	cfg := soak.DefaultConfig()
+	var err error
+	if cfg.Pattern, err = soak.ParsePattern(sf.pattern); err != nil {
+		return cfg, err
+	}
+	if cfg.OnHopperLow, err = soak.ParseAction(sf.onHopperLow); err != nil {
+		return cfg, err
+	}
+	if cfg.OnFault, err = soak.ParseAction(sf.onFault); err != nil {
+		return cfg, err
+	}
+	cfg.Duration = sf.duration
+	cfg.MaxTokens = sf.tokens
+	cfg.Interval = sf.interval
+	cfg.PauseTimeout = sf.pauseTimeout
+	return cfg, cfg.Validate()
+}
+
+func rssiString(w *client.WiFiInfo) string {
+	if w == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", w.RSSI)
+}
+
+// --- helpers ---
+
+// exitCodeForState maps a transaction state to an exit code. Only done is
+// a success: a queued or dispensing transaction has not finished yet
+func exitCodeForState(state string, dispensed, quantity int) int {
+	switch {
+	case state == "done":
+		return exitOK
+	case state == client.StateUnknown:
+		return exitUnknown
+	case state == client.StateQueued, state == "dispensing":
+		return exitPending
+	case state != "error":
+		return exitError
+	case dispensed > 0 && dispensed < quantity:
+		return exitPartial
+	default:
+		return exitFault
+	}
+}
+
+// exitCodeFor maps client errors to exit codes
+func exitCodeFor(err error) int {
+	var apiErr *client.APIError
+	var cfgErr *configError
+	var urlErr *url.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &cfgErr):
+		return exitUsage
+	case errors.Is(err, client.ErrUnauthorized):
+		return exitUnauthorized
+	case errors.Is(err, client.ErrBusy):
+		return exitBusy
+	case errors.Is(err, client.ErrDispenserFault):
+		return exitFault
+	case errors.Is(err, client.ErrNotFound), errors.Is(err, client.ErrOutcomeUnknown):
+		return exitUnknown
+	case errors.Is(err, client.ErrInvalidRequest), errors.Is(err, client.ErrUnsupportedMediaType):
+		return exitUsage
+	case errors.As(err, &apiErr), errors.Is(err, context.Canceled):
+		return exitError
+	case errors.As(err, &urlErr), errors.As(err, &opErr), errors.As(err, &dnsErr):
+		// Transport errors: refused, timed out, no route. Not the net.Error
+		// interface, which a syscall.Errno from a local file also satisfies.
+		return exitUnreachable
+	default:
+		return exitError
+	}
+}
+
+// fail prints err and returns its exit code
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	return exitCodeFor(err)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// formatCents prints an amount in minor units as 12.50
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
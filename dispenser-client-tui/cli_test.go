@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+func TestExitCodeForState(t *testing.T) {
+	for _, tc := range []struct {
+		state               string
+		dispensed, quantity int
+		want                int
+	}{
+		{"done", 3, 3, exitOK},
+		{"error", 1, 3, exitPartial},
+		{"error", 0, 3, exitFault},
+		{client.StateUnknown, 1, 3, exitUnknown},
+		{client.StateQueued, 0, 3, exitPending},
+		{"dispensing", 1, 3, exitPending},
+		{client.StateRejected, 0, 3, exitError},
+	} {
+		if got := exitCodeForState(tc.state, tc.dispensed, tc.quantity); got != tc.want {
+			t.Errorf("%s %d/%d: exit %d, want %d", tc.state, tc.dispensed, tc.quantity, got, tc.want)
+		}
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+	_, refused := client.NewDispenserClient(down.URL, "", time.Second).Health(context.Background())
+	_, badJSON := json.Marshal(func() {})
+	_, noJournal := client.OpenJournal(filepath.Join(t.TempDir(), "missing", "journal.jsonl"))
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"refused", refused.Error, exitUnreachable},
+		{"timeout", &url.Error{Op: "Get", URL: down.URL, Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}}, exitUnreachable},
+		{"wrapped net error", fmt.Errorf("health: %w", &net.DNSError{Err: "no such host", Name: "dispenser"}), exitUnreachable},
+		{"canceled", &url.Error{Op: "Get", URL: down.URL, Err: context.Canceled}, exitError},
+		{"unauthorized", fmt.Errorf("status: %w", client.ErrUnauthorized), exitUnauthorized},
+		{"busy", client.ErrBusy, exitBusy},
+		{"not found", client.ErrNotFound, exitUnknown},
+		{"api error", &client.APIError{StatusCode: 500, Message: "internal"}, exitError},
+		{"config", &configError{errors.New("unknown profile")}, exitUsage},
+		// Local failures are no reason to suspect the network
+		{"journal", noJournal, exitError},
+		{"json", badJSON, exitError},
+		{"plain", errors.New("checkpoint: bad magic"), exitError},
+	} {
+		if tc.err == nil {
+			t.Fatalf("%s: no error", tc.name)
+		}
+		if got := exitCodeFor(tc.err); got != tc.want {
+			t.Errorf("%s (%v): exit %d, want %d", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+// isolate clears the environment the connection settings read and writes
+// config, if any, to the default config path
+func isolate(t *testing.T, config string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	for _, env := range []string{"TOKEN_DISPENSER_CONFIG", "TOKEN_DISPENSER_PROFILE", "TOKEN_DISPENSER_ENDPOINT",
+		"TOKEN_DISPENSER_API_KEY", "TOKEN_DISPENSER_JOURNAL"} {
+		t.Setenv(env, "")
+	}
+	if config == "" {
+		return
+	}
+	path := defaultConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resolveFlags parses args into connection flags and resolves them
+func resolveFlags(t *testing.T, args ...string) (*connFlags, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cf := addConnFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cf, cf.resolve()
+}
+
+func TestConnFlagsPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		setting      string
+		flag, env    string // "" if the setting has none
+		flagValue    string
+		envValue     string
+		profile      string // TOML setting it in the profile, "" if none
+		profileValue string
+		defaultValue string
+		get          func(*connFlags) string
+	}{
+		{"endpoint", "endpoint", "TOKEN_DISPENSER_ENDPOINT", "http://flag", "http://env",
+			`endpoint = "http://profile"`, "http://profile", defaultEndpoint, func(cf *connFlags) string { return cf.endpoint }},
+		{"api_key", "api-key", "TOKEN_DISPENSER_API_KEY", "flag-key", "env-key",
+			`api_key = "profile-key"`, "profile-key", "", func(cf *connFlags) string { return cf.apiKey }},
+		{"timeout", "timeout", "", "7s", "",
+			"timeout_ms = 1500", "1.5s", "3s", func(cf *connFlags) string { return cf.timeout.String() }},
+		{"health_interval", "health-interval", "", "9s", "",
+			"health_interval_s = 12", "12s", healthInterval.String(), func(cf *connFlags) string { return cf.healthInterval.String() }},
+		{"poll_interval", "poll-interval", "", "100ms", "",
+			"poll_interval_ms = 400", "400ms", pollInterval.String(), func(cf *connFlags) string { return cf.pollInterval.String() }},
+		{"expected_firmware", "expected-firmware", "", "2.0.0", "",
+			`expected_firmware = "1.1.0"`, "1.1.0", "", func(cf *connFlags) string { return cf.expectedFirmware }},
+		{"journal", "journal", "TOKEN_DISPENSER_JOURNAL", "flag.jsonl", "env.jsonl",
+			"", "", "", func(cf *connFlags) string { return cf.journal }},
+	} {
+		// Each source is given along with all the ones below it
+		for i, from := range []string{sourceFlag, sourceEnv, sourceProfile, sourceDefault} {
+			if from == sourceEnv && tc.env == "" || from == sourceProfile && tc.profile == "" {
+				continue
+			}
+			config := "default_profile = \"sauna-1\"\n\n[profiles.sauna-1]\n"
+			if i <= 2 {
+				config += tc.profile + "\n"
+			}
+			isolate(t, config)
+			var args []string
+			if i == 0 {
+				args = []string{"--" + tc.flag + "=" + tc.flagValue}
+			}
+			if i <= 1 && tc.env != "" {
+				t.Setenv(tc.env, tc.envValue)
+			}
+
+			cf, err := resolveFlags(t, args...)
+			if err != nil {
+				t.Fatalf("%s from %s: %v", tc.setting, from, err)
+			}
+			want := []string{tc.flagValue, tc.envValue, tc.profileValue, tc.defaultValue}[i]
+			if got := tc.get(cf); got != want {
+				t.Errorf("%s from %s = %q, want %q", tc.setting, from, got, want)
+			}
+			if src := cf.sources[tc.setting]; !strings.HasPrefix(src, from) {
+				t.Errorf("%s from %s: source %q", tc.setting, from, src)
+			}
+		}
+	}
+}
+
+func TestAPIKeyCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		command string
+		args    []string
+		env     string
+		want    string // key, or "error"
+		runs    bool
+	}{
+		{name: "nothing else", command: "echo cmd-key; echo second-line", want: "cmd-key", runs: true},
+		{name: "flag", command: "echo cmd-key", args: []string{"--api-key=flag-key"}, want: "flag-key"},
+		{name: "env", command: "echo cmd-key", env: "env-key", want: "env-key"},
+		{name: "failing", command: "exit 1", want: "error", runs: true},
+		{name: "no output", command: "true", want: "error", runs: true},
+	} {
+		ran := filepath.Join(t.TempDir(), "ran")
+		isolate(t, fmt.Sprintf("[profiles.default]\napi_key_command = %q\n", "touch "+ran+"; "+tc.command))
+		if tc.env != "" {
+			t.Setenv("TOKEN_DISPENSER_API_KEY", tc.env)
+		}
+
+		cf, err := resolveFlags(t, tc.args...)
+		if _, statErr := os.Stat(ran); (statErr == nil) != tc.runs {
+			t.Errorf("%s: api_key_command ran %v, want %v", tc.name, statErr == nil, tc.runs)
+		}
+		var cfgErr *configError
+		if tc.want == "error" {
+			if !errors.As(err, &cfgErr) {
+				t.Errorf("%s: err = %v, want a config error", tc.name, err)
+			}
+			continue
+		}
+		if err != nil || cf.apiKey != tc.want {
+			t.Errorf("%s: key %q, %v; want %q", tc.name, cf.apiKey, err, tc.want)
+		}
+		if tc.runs && cf.sources["api_key"] != "profile default (api_key_command)" {
+			t.Errorf("%s: source %q", tc.name, cf.sources["api_key"])
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Command dispenser-exporter polls a dispenser's /health endpoint and serves
+// the result as Prometheus metrics on /metrics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/exporter"
+)
+
+func main() {
+	endpoint := flag.String("endpoint", "http://192.168.4.20", "Dispenser base URL (or TOKEN_DISPENSER_ENDPOINT env)")
+	apiKey := flag.String("api-key", "", "API key for dispenser (or TOKEN_DISPENSER_API_KEY env)")
+	listen := flag.String("listen", ":9117", "Listen address for /metrics")
+	interval := flag.Duration("interval", 15*time.Second, "Health poll interval")
+	timeout := flag.Duration("timeout", 5*time.Second, "Request timeout")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+🪙 dispenser-exporter — Prometheus exporter for the token dispenser
+
+Usage: dispenser-exporter [flags]
+
+Flags:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+Scrape config:
+  - job_name: dispenser
+    static_configs:
+      - targets: ["localhost:9117"]
+
+A Grafana dashboard for these metrics is in cmd/dispenser-exporter/grafana-dashboard.json.
+`)
+	}
+	flag.Parse()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if env := os.Getenv("TOKEN_DISPENSER_ENDPOINT"); env != "" && !set["endpoint"] {
+		*endpoint = env
+	}
+	if *apiKey == "" {
+		*apiKey = os.Getenv("TOKEN_DISPENSER_API_KEY")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	c := client.NewDispenserClient(*endpoint, *apiKey, *timeout)
+	exp := exporter.New(c, *interval)
+	go exp.Run(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exp)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><h1>dispenser-exporter</h1><a href="/metrics">/metrics</a></body></html>`)
+	})
+	srv := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("dispenser-exporter listening on %s, polling %s every %s", *listen, c.BaseURL, *interval)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
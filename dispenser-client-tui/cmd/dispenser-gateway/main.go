@@ -0,0 +1,122 @@
+// Command dispenser-gateway sits in front of the dispenser and queues
+// concurrent dispense requests instead of answering 409 busy.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/gateway"
+)
+
+func main() {
+	endpoint := flag.String("endpoint", "http://192.168.4.20", "Dispenser base URL (or TOKEN_DISPENSER_ENDPOINT env)")
+	apiKey := flag.String("api-key", "", "API key for dispenser (or TOKEN_DISPENSER_API_KEY env)")
+	clientKey := flag.String("client-key", "", "API key clients must send to the gateway (default: the dispenser's)")
+	journalPath := flag.String("journal", "", "Transaction journal, keeps the history across restarts (or TOKEN_DISPENSER_JOURNAL env)")
+	listen := flag.String("listen", ":8081", "Listen address")
+	timeout := flag.Duration("timeout", 3*time.Second, "Request timeout towards the dispenser")
+	maxQueue := flag.Int("max-queue", gateway.DefaultMaxQueue, "Transactions waiting at most, more get 503")
+	history := flag.Int("history", gateway.DefaultHistory, "Finished transactions kept for status queries")
+	logFormat := flag.String("log-format", "text", "Log format: json or text")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+🪙 dispenser-gateway — FIFO queue in front of the token dispenser
+
+Usage: dispenser-gateway [flags]
+
+Flags:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+API (same as the dispenser, plus the queue):
+  POST /dispense          queued FIFO, state "queued" with queue_position
+  GET  /dispense/{tx_id}  state and queue position
+  GET  /queue             active and waiting transactions
+  GET  /health            dispenser health plus a "gateway" section
+
+Only while the dispenser is in error does POST /dispense answer 409.
+
+Example:
+  dispenser-gateway --endpoint http://192.168.4.20 --api-key mysecret --journal gateway.jsonl
+  token-tui --endpoint http://localhost:8081 --api-key mysecret
+`)
+	}
+	flag.Parse()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if env := os.Getenv("TOKEN_DISPENSER_ENDPOINT"); env != "" && !set["endpoint"] {
+		*endpoint = env
+	}
+	if *apiKey == "" {
+		*apiKey = os.Getenv("TOKEN_DISPENSER_API_KEY")
+	}
+	if *journalPath == "" {
+		*journalPath = os.Getenv("TOKEN_DISPENSER_JOURNAL")
+	}
+	if *clientKey == "" {
+		*clientKey = *apiKey
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+	if *logFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	}
+	logger := slog.New(handler)
+
+	// An empty key would let anyone on the network dispense
+	if *clientKey == "" {
+		logger.Error("no client key: set --client-key, --api-key or TOKEN_DISPENSER_API_KEY")
+		os.Exit(2)
+	}
+
+	c := client.NewDispenserClient(*endpoint, *apiKey, *timeout)
+	if *journalPath != "" {
+		j, err := client.OpenJournal(*journalPath)
+		if err != nil {
+			logger.Error("opening journal", "err", err)
+			os.Exit(1)
+		}
+		defer j.Close()
+		c.Journal = j
+	}
+
+	g := gateway.New(c)
+	g.MaxQueue = *maxQueue
+	g.History = *history
+	g.Logger = logger
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	srv := &http.Server{Addr: *listen, Handler: g.Handler(*clientKey)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(ctx)
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	logger.Info("dispenser-gateway listening", "addr", *listen, "dispenser", c.BaseURL, "max_queue", g.MaxQueue)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("serving", "err", err)
+		cancel()
+		<-done
+		os.Exit(1)
+	}
+	<-done
+	logger.Info("stopped")
+}
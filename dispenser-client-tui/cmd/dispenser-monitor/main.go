@@ -0,0 +1,136 @@
+// Command dispenser-monitor is the Wemos health poller of the POS daemon:
+// it polls GET /health, tracks the conditions of pos-daemon-design.md and
+// logs every transition as a structured event.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"token-tui/dispenser/healthcheck"
+	"token-tui/dispenser/monitor"
+)
+
+const defaultConfigPath = "/etc/pos-daemon/config.toml"
+
+func main() {
+	configPath := flag.String("config", defaultConfigPath, "POS daemon config file with a [wemos] section")
+	logFormat := flag.String("log-format", "json", "Log format: json or text")
+	debug := flag.Bool("debug", false, "Log every poll, not just transitions")
+	once := flag.Bool("once", false, "Poll once, print the status as JSON and exit (1 if a warning or critical condition is active)")
+	hcURL := flag.String("healthcheck-url", "", "Ping URL of the healthchecks.io check, overrides [healthcheck] url")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+🪙 dispenser-monitor — dispenser health poller
+
+Usage: dispenser-monitor [flags]
+
+Flags:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+Config ([wemos] section of the POS daemon config):
+  host = "192.168.4.1"
+  port = 80
+  poll_interval_s = 60
+  timeout_ms = 3000
+  retries = 3
+  retry_delay_ms = 1000
+  expected_firmware = "1.1.0"   # optional
+
+  [healthcheck]                 # optional heartbeat reporting
+  url = "https://hc-ping.com/<uuid>"
+  interval_s = 60
+
+  [system]                      # thresholds of the local checks
+  disk_warn_mb = 500
+  temp_warn_c = 80
+
+Conditions: wemos_unreachable, hopper_empty, dispenser_jammed (critical),
+hopper_low (warning), wemos_rebooted, firmware_mismatch (info).
+`)
+	}
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if *logFormat == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	logger := slog.New(handler)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		logger.Error("loading config", "err", err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *hcURL != "" {
+		cfg.Healthcheck.URL = *hcURL
+	}
+
+	m := monitor.New(cfg.Wemos, logger)
+	var reporter *healthcheck.Reporter
+	if cfg.Healthcheck.URL != "" {
+		reporter = healthcheck.New(cfg.Healthcheck.URL, cfg.System, logger)
+		// Warnings and critical conditions show in the fail ping already;
+		// reboots and firmware changes go to the check's log
+		m.OnEvent = func(e monitor.Event) {
+			if e.Severity == monitor.SeverityInfo && !e.Cleared {
+				reporter.Log(fmt.Sprintf("%s: %s", e.Condition, e.Message))
+			}
+		}
+	}
+
+	if *once {
+		m.Check(ctx)
+		st := m.Status()
+		if reporter != nil {
+			if _, err := reporter.Report(ctx, st); err != nil {
+				logger.Warn("healthcheck ping failed", "err", err)
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(st)
+		if worst, ok := st.Worst(); ok && worst > monitor.SeverityInfo {
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger.Info("monitoring dispenser", "url", m.Client.BaseURL,
+		"interval", cfg.Wemos.PollInterval(), "timeout", cfg.Wemos.Timeout(), "retries", cfg.Wemos.Retries,
+		"retry_delay", cfg.Wemos.RetryDelay())
+	if reporter != nil {
+		logger.Info("reporting to healthcheck", "url", reporter.BaseURL, "interval", cfg.Healthcheck.Interval())
+		go reporter.Run(ctx, cfg.Healthcheck.Interval(), m.Status)
+	}
+	m.Run(ctx)
+	logger.Info("stopped")
+}
+
+// loadConfig reads path; the default path may be missing, in which case
+// the design's defaults apply
+func loadConfig(path string) (monitor.Config, error) {
+	_, err := os.Stat(path)
+	if path == defaultConfigPath && errors.Is(err, os.ErrNotExist) {
+		return monitor.DefaultConfig(), nil
+	}
+	return monitor.LoadConfig(path)
+}
@@ -0,0 +1,110 @@
+// Command dispenser-proxy terminates HTTPS in front of the dispenser and
+// authenticates each caller with its own API key or client certificate.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"token-tui/dispenser/authproxy"
+)
+
+func main() {
+	configPath := flag.String("config", "dispenser-proxy.toml", "Proxy config file")
+	logFormat := flag.String("log-format", "json", "Log format: json or text")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+🪙 dispenser-proxy — HTTPS proxy with per-client credentials
+
+Usage: dispenser-proxy [flags]
+
+Flags:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+Config:
+  listen = ":8443"
+
+  [upstream]
+  url = "http://192.168.4.20"
+  api_key = "..."              # or TOKEN_DISPENSER_API_KEY env
+  timeout_ms = 10000
+
+  [tls]
+  cert = "proxy.crt"
+  key = "proxy.key"
+  client_ca = "clients-ca.crt"  # optional, enables client certificates
+
+  [[client]]
+  name = "pos-1"
+  api_key_sha256 = "..."        # echo -n KEY | sha256sum, or api_key = "..."
+
+  [[client]]
+  name = "kiosk"
+  cert_cn = "kiosk.local"
+
+Clients:
+  token-tui --endpoint https://proxy:8443 --api-key KEY --tls-ca proxy-ca.crt
+  token-tui --endpoint https://proxy:8443 --tls-ca proxy-ca.crt --tls-cert kiosk.crt --tls-key kiosk.key
+`)
+	}
+	flag.Parse()
+
+	opts := &slog.HandlerOptions{}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if *logFormat == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	logger := slog.New(handler)
+
+	cfg, err := authproxy.LoadConfig(*configPath)
+	if err != nil {
+		logger.Error("loading config", "err", err)
+		os.Exit(2)
+	}
+	tlsCfg, err := cfg.TLS.ServerTLS()
+	if err != nil {
+		logger.Error("loading certificates", "err", err)
+		os.Exit(2)
+	}
+	proxy, err := authproxy.New(cfg, logger)
+	if err != nil {
+		logger.Error("config", "err", err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           proxy,
+		TLSConfig:         tlsCfg,
+		ReadHeaderTimeout: 10 * time.Second,
+		// TLS handshake failures, e.g. untrusted client certificates
+		ErrorLog: slog.NewLogLogger(handler, slog.LevelWarn),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	logger.Info("dispenser-proxy listening", "addr", cfg.Listen, "upstream", cfg.Upstream.URL,
+		"clients", len(cfg.Clients), "client_certs", cfg.TLS.ClientCA != "")
+	if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("serving", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("stopped")
+}
@@ -0,0 +1,114 @@
+// Command dispenser-relay polls the dispenser once and pushes progress,
+// health and errors to any number of clients as Server-Sent Events.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/relay"
+)
+
+func main() {
+	endpoint := flag.String("endpoint", "http://192.168.4.20", "Dispenser base URL (or TOKEN_DISPENSER_ENDPOINT env)")
+	apiKey := flag.String("api-key", "", "API key for dispenser (or TOKEN_DISPENSER_API_KEY env)")
+	clientKey := flag.String("client-key", "", "API key clients must send to the relay (default: the dispenser's)")
+	listen := flag.String("listen", ":8082", "Listen address")
+	interval := flag.Duration("interval", relay.DefaultInterval, "Health poll interval while idle")
+	activeInterval := flag.Duration("active-interval", relay.DefaultActiveInterval, "Poll interval while a transaction runs")
+	timeout := flag.Duration("timeout", 3*time.Second, "Request timeout towards the dispenser")
+	logFormat := flag.String("log-format", "text", "Log format: json or text")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+🪙 dispenser-relay — push dispenser events to many clients
+
+Usage: dispenser-relay [flags]
+
+Flags:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+API:
+  GET /events            Server-Sent Events: health, dispense, error
+  GET /events?tx_id=ID   one transaction, closed after its final state
+  GET /health            relay status and the last dispenser health
+
+Clients send X-API-Key, or ?key= for browser EventSource, and resume
+with Last-Event-ID after a disconnect.
+
+Example:
+  dispenser-relay --endpoint http://192.168.4.20 --api-key mysecret
+  curl -N -H 'X-API-Key: mysecret' http://localhost:8082/events
+  token-tui --endpoint http://192.168.4.20 --api-key mysecret --relay http://localhost:8082
+`)
+	}
+	flag.Parse()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if env := os.Getenv("TOKEN_DISPENSER_ENDPOINT"); env != "" && !set["endpoint"] {
+		*endpoint = env
+	}
+	if *apiKey == "" {
+		*apiKey = os.Getenv("TOKEN_DISPENSER_API_KEY")
+	}
+	if *clientKey == "" {
+		*clientKey = *apiKey
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+	if *logFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	}
+	logger := slog.New(handler)
+
+	// An empty key would let anyone on the network follow the dispenser
+	if *clientKey == "" {
+		logger.Error("no client key: set --client-key, --api-key or TOKEN_DISPENSER_API_KEY")
+		os.Exit(2)
+	}
+
+	r := relay.New(client.NewDispenserClient(*endpoint, *apiKey, *timeout))
+	r.Interval = *interval
+	r.ActiveInterval = *activeInterval
+	r.Logger = logger
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	srv := &http.Server{
+		Addr:    *listen,
+		Handler: r.Handler(*clientKey),
+		// Streams end with the request context on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	logger.Info("dispenser-relay listening", "addr", *listen, "dispenser", *endpoint)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("serving", "err", err)
+		cancel()
+		<-done
+		os.Exit(1)
+	}
+	<-done
+	logger.Info("stopped")
+}
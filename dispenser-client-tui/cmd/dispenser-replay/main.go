@@ -0,0 +1,115 @@
+// Command dispenser-replay serves a cassette recorded with token-tui
+// --record as if it were the dispenser, so a captured incident can be
+// reproduced without hardware.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"token-tui/dispenser/cassette"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8080", "Listen address")
+	speed := flag.Float64("speed", 1, "Replay speed: 1 is the original timing, 10 ten times faster")
+	sequential := flag.Bool("sequential", false, "Serve each endpoint's responses in recorded order instead of by time")
+	loop := flag.Bool("loop", false, "Start over after the end of the recording")
+	host := flag.String("host", "", "Dispenser to replay when the cassette has several (host:port)")
+	logFormat := flag.String("log-format", "text", "Log format: json or text")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+🪙 dispenser-replay — serve a recorded dispenser session
+
+Usage: dispenser-replay [flags] CASSETTE
+
+Flags:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+A request gets the response that was current at the same point of the
+recording, with the recorded latency; both scale with --speed. The clock
+starts with the first request.
+
+Replay control (no auth):
+  GET  /_replay/state   position in the recording and requests served
+  POST /_replay/rewind  start over with the next request
+
+Example:
+  token-tui --endpoint http://192.168.4.20 --api-key mysecret --record incident.jsonl
+  dispenser-replay --speed 10 incident.jsonl &
+  token-tui --endpoint http://127.0.0.1:8080 --api-key any
+`)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *speed <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+	if *logFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	}
+	logger := slog.New(handler)
+
+	interactions, err := cassette.Load(flag.Arg(0))
+	if err != nil {
+		logger.Error("loading cassette", "err", err)
+		os.Exit(1)
+	}
+	hosts := cassette.Hosts(interactions)
+	switch {
+	case *host != "":
+		var selected []cassette.Interaction
+		for _, in := range interactions {
+			if in.Request.Host == *host {
+				selected = append(selected, in)
+			}
+		}
+		if len(selected) == 0 {
+			logger.Error("host not in cassette", "host", *host, "hosts", strings.Join(hosts, ","))
+			os.Exit(2)
+		}
+		interactions = selected
+	case len(hosts) > 1:
+		logger.Error("cassette has several dispensers, pick one with --host", "hosts", strings.Join(hosts, ","))
+		os.Exit(2)
+	}
+
+	p := cassette.NewPlayer(interactions)
+	p.Speed = *speed
+	p.Sequential = *sequential
+	p.Loop = *loop
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	srv := &http.Server{Addr: *listen, Handler: p.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	first, last := interactions[0], interactions[len(interactions)-1]
+	logger.Info("dispenser-replay listening", "addr", *listen, "cassette", flag.Arg(0),
+		"interactions", len(interactions), "recorded", first.Time.Format(time.RFC3339),
+		"duration", (last.Elapsed() + last.Duration()).Round(time.Second), "speed", *speed)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("serving", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("stopped")
+}
@@ -0,0 +1,86 @@
+// Command dispenser-sim serves a simulated token dispenser over HTTP so the
+// TUI and POS integrations can be exercised without the Wemos D1.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"token-tui/dispenser/sim"
+)
+
+func main() {
+	def := sim.DefaultConfig()
+
+	addr := flag.String("addr", "127.0.0.1:8080", "Listen address")
+	apiKey := flag.String("api-key", def.APIKey, "API key required by /dispense endpoints")
+	firmware := flag.String("firmware", def.Firmware, "Firmware version reported by /health")
+	tokenInterval := flag.Duration("token-interval", def.TokenInterval, "Time per dispensed token")
+	jamTimeout := flag.Duration("jam-timeout", def.JamTimeout, "Time without a coin pulse before jamming")
+	hopperTokens := flag.Int("hopper-tokens", 0, "Tokens loaded in the hopper (0 = unlimited)")
+	rssi := flag.Int("rssi", def.RSSI, "Reported WiFi RSSI in dBm")
+	scenario := flag.String("scenario", "", "JSON fault scenario to apply at startup")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+🪙 dispenser-sim — simulated token dispenser
+
+Usage: dispenser-sim [flags]
+
+Flags:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+Fault injection (admin API, no auth):
+  GET  /_sim/state      health plus pending fault count
+  POST /_sim/faults     {"action":"jam","after_tokens":2}
+  POST /_sim/scenario   {"faults":[...]}
+  POST /_sim/reboot     power cycle
+
+Actions: jam, error (code 1-7), clear_error, hopper_low, hopper_ok, reboot,
+drop, slow (delay), rssi (rssi, drift). Triggers: at (duration), after_tokens.
+
+Example:
+  dispenser-sim --addr :8080 --api-key mysecret --scenario scenarios/jam-after-2.json &
+  token-tui --endpoint http://127.0.0.1:8080 --api-key mysecret
+`)
+	}
+	flag.Parse()
+
+	cfg := def
+	cfg.APIKey = *apiKey
+	cfg.Firmware = *firmware
+	cfg.TokenInterval = *tokenInterval
+	cfg.JamTimeout = *jamTimeout
+	cfg.HopperTokens = *hopperTokens
+	cfg.RSSI = *rssi
+
+	s := sim.New(cfg)
+	defer s.Close()
+
+	if *scenario != "" {
+		sc, err := sim.LoadScenario(*scenario)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := s.Apply(sc); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("applied scenario %q (%d faults)", sc.Name, len(sc.Faults))
+	}
+
+	log.Printf("dispenser-sim listening on http://%s (firmware %s)", *addr, cfg.Firmware)
+	if err := http.ListenAndServe(*addr, logRequests(s.Handler())); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
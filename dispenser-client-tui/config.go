@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configFile is the TUI's config file, by default
+// ~/.config/token-tui/config.toml:
+//
+//	default_profile = "sauna-1"
+//
+//	[profiles.sauna-1]
+//	endpoint = "http://192.168.4.20"
+//	api_key_command = "pass show dispenser/sauna-1"
+//	timeout_ms = 3000
+//	health_interval_s = 5
+//	poll_interval_ms = 250
+//	expected_firmware = "1.1.0"
+type configFile struct {
+	DefaultProfile string             `toml:"default_profile"`
+	Profiles       map[string]profile `toml:"profiles"`
+}
+
+// profile is one named dispenser setup. Zero values leave the setting to
+// the environment or the built-in default.
+type profile struct {
+	Endpoint string `toml:"endpoint"`
+	// APIKey, or APIKeyCommand whose output is the key, so that the file
+	// need not hold it
+	APIKey           string `toml:"api_key"`
+	APIKeyCommand    string `toml:"api_key_command"`
+	TimeoutMS        int    `toml:"timeout_ms"`
+	HealthIntervalS  int    `toml:"health_interval_s"`
+	PollIntervalMS   int    `toml:"poll_interval_ms"`
+	ExpectedFirmware string `toml:"expected_firmware"`
+}
+
+// Sources of an effective setting, as shown by config show
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceProfile = "profile"
+)
+
+// configError is a bad config file, profile or api_key_command; commands
+// exit with a usage error on it
+type configError struct{ err error }
+
+func (e *configError) Error() string { return e.err.Error() }
+func (e *configError) Unwrap() error { return e.err }
+
+// defaultConfigPath is config.toml in the user's config directory
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "token-tui", "config.toml")
+}
+
+// loadConfigFile reads the config file. A missing file is only an error
+// if it was asked for explicitly; otherwise it means no profiles.
+func loadConfigFile(path string, explicit bool) (configFile, bool, error) {
+	var cfg configFile
+	if path == "" {
+		return cfg, false, nil
+	}
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !explicit {
+			return cfg, false, nil
+		}
+		return cfg, false, fmt.Errorf("config %s: %w", path, err)
+	}
+	for name, p := range cfg.Profiles {
+		if p.APIKey != "" && p.APIKeyCommand != "" {
+			return cfg, true, fmt.Errorf("config %s: profile %s: set api_key or api_key_command, not both", path, name)
+		}
+	}
+	return cfg, true, nil
+}
+
+// selectProfile picks the profile named by --profile, the
+// TOKEN_DISPENSER_PROFILE environment variable or default_profile, in that
+// order, else "default" if the file has one
+func (cfg configFile) selectProfile(name string) (string, profile, error) {
+	if name == "" {
+		name = os.Getenv("TOKEN_DISPENSER_PROFILE")
+	}
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	if name == "" {
+		if p, ok := cfg.Profiles["default"]; ok {
+			return "default", p, nil
+		}
+		return "", profile{}, nil
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return "", profile{}, fmt.Errorf("profile %q not found in the config file", name)
+	}
+	return name, p, nil
+}
+
+// runKeyCommand runs an api_key_command through the shell and returns its
+// first output line
+func runKeyCommand(command string) (string, error) {
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		return "", fmt.Errorf("api_key_command: %w", err)
+	}
+	key, _, _ := strings.Cut(string(out), "\n")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("api_key_command printed no key")
+	}
+	return key, nil
+}
+
+// maskSecret hides all but the last 4 characters of a key, and all of a
+// short one
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) < 12 {
+		return "********"
+	}
+	return "********" + s[len(s)-4:]
+}
+
+// --- config show ---
+
+// setting is one effective value of config show
+type setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintf(os.Stderr, "Usage: token-tui config show [flags]\n")
+		return exitUsage
+	}
+	fs, cf, output := newCommandFlags("config show", "config show [flags]")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if err := cf.resolve(); err != nil {
+		return fail(err)
+	}
+
+	settings := cf.settings()
+	if *output == "json" {
+		printJSON(settings)
+		return exitOK
+	}
+	for _, s := range settings {
+		value := s.Value
+		if value == "" {
+			value = "-"
+		}
+		fmt.Printf("%-18s %-40s %s\n", s.Name+":", value, s.Source)
+	}
+	return exitOK
+}
+
+// settings lists the resolved settings with the API key masked
+func (cf *connFlags) settings() []setting {
+	configPath := cf.configPath
+	if !cf.configFound {
+		configPath += " (not found)"
+	}
+	return []setting{
+		{"config", configPath, cf.sources["config"]},
+		{"profile", cf.profileName, cf.sources["profile"]},
+		{"endpoint", cf.endpoint, cf.sources["endpoint"]},
+		{"api_key", maskSecret(cf.apiKey), cf.sources["api_key"]},
+		{"timeout", cf.timeout.String(), cf.sources["timeout"]},
+		{"health_interval", cf.healthInterval.String(), cf.sources["health_interval"]},
+		{"poll_interval", cf.pollInterval.String(), cf.sources["poll_interval"]},
+		{"expected_firmware", cf.expectedFirmware, cf.sources["expected_firmware"]},
+		{"journal", cf.journal, cf.sources["journal"]},
+	}
+}
+
+// durationMS and durationS convert the integer units of the config file
+func durationMS(ms int) time.Duration { return time.Duration(ms) * time.Millisecond }
+func durationS(s int) time.Duration   { return time.Duration(s) * time.Second }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyConflict(t *testing.T) {
+	isolate(t, "[profiles.sauna-1]\napi_key = \"profile-key\"\napi_key_command = \"echo cmd-key\"\n")
+	_, err := resolveFlags(t, "--api-key=flag-key")
+	var cfgErr *configError
+	if !errors.As(err, &cfgErr) || !strings.Contains(err.Error(), "profile sauna-1: set api_key or api_key_command, not both") {
+		t.Errorf("err = %v, want the conflict as a config error", err)
+	}
+}
+
+func TestConfigShowMasksKey(t *testing.T) {
+	for _, tc := range []struct{ key, want string }{
+		{"", ""},
+		{"short-key", "********"},
+		{"change-this-secret-key-here", "********here"},
+	} {
+		isolate(t, "")
+		cf, err := resolveFlags(t, "--api-key="+tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range cf.settings() {
+			if s.Name == "api_key" && s.Value != tc.want {
+				t.Errorf("api_key %q shown as %q, want %q", tc.key, s.Value, tc.want)
+			}
+			if tc.key != "" && strings.Contains(s.Value, tc.key) {
+				t.Errorf("%s shows the key: %q", s.Name, s.Value)
+			}
+		}
+	}
+}
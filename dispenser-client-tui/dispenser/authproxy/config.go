@@ -0,0 +1,179 @@
+package authproxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the proxy's TOML config file
+type Config struct {
+	// Listen is the HTTPS listen address
+	Listen   string         `toml:"listen"`
+	Upstream UpstreamConfig `toml:"upstream"`
+	TLS      TLSConfig      `toml:"tls"`
+	Clients  []ClientConfig `toml:"client"`
+}
+
+// UpstreamConfig is the [upstream] section: the dispenser and its real key
+type UpstreamConfig struct {
+	URL string `toml:"url"`
+	// APIKey is the dispenser's shared key; empty means the
+	// TOKEN_DISPENSER_API_KEY environment variable
+	APIKey    string `toml:"api_key"`
+	TimeoutMS int    `toml:"timeout_ms"`
+}
+
+// Timeout bounds one forwarded request
+func (u UpstreamConfig) Timeout() time.Duration {
+	return time.Duration(u.TimeoutMS) * time.Millisecond
+}
+
+// TLSConfig is the [tls] section
+type TLSConfig struct {
+	Cert string `toml:"cert"`
+	Key  string `toml:"key"`
+	// ClientCA enables client certificates: certificates it signed
+	// authenticate callers by their subject CN
+	ClientCA string `toml:"client_ca"`
+}
+
+// ClientConfig is one [[client]]: a named caller and its credentials. Any
+// of them identifies the client.
+type ClientConfig struct {
+	Name string `toml:"name"`
+	// APIKey in plain text, or APIKeySHA256 as hex so the config does not
+	// hold the key itself (echo -n KEY | sha256sum)
+	APIKey       string `toml:"api_key"`
+	APIKeySHA256 string `toml:"api_key_sha256"`
+	// CertCN is the subject common name of the client certificate
+	CertCN string `toml:"cert_cn"`
+}
+
+// DefaultConfig has the listen address and timeout; clients, TLS files and
+// the upstream have no defaults
+func DefaultConfig() Config {
+	return Config{
+		Listen:   ":8443",
+		Upstream: UpstreamConfig{URL: "http://192.168.4.20", TimeoutMS: 10000},
+	}
+}
+
+// LoadConfig reads a TOML config file on top of DefaultConfig
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
+	if cfg.Upstream.APIKey == "" {
+		cfg.Upstream.APIKey = os.Getenv("TOKEN_DISPENSER_API_KEY")
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks for settings the proxy cannot run with
+func (c Config) Validate() error {
+	u, err := url.Parse(c.Upstream.URL)
+	switch {
+	case err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https"):
+		return fmt.Errorf("upstream.url %q is not an http(s) URL", c.Upstream.URL)
+	case c.Upstream.APIKey == "":
+		return fmt.Errorf("upstream.api_key is empty and TOKEN_DISPENSER_API_KEY is not set")
+	case c.Upstream.TimeoutMS < 1:
+		return fmt.Errorf("upstream.timeout_ms must be positive")
+	case c.TLS.Cert == "" || c.TLS.Key == "":
+		return fmt.Errorf("tls.cert and tls.key are required")
+	case len(c.Clients) == 0:
+		return fmt.Errorf("no [[client]] configured")
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	cns := make(map[string]string)
+	for i, cl := range c.Clients {
+		switch {
+		case cl.Name == "":
+			return fmt.Errorf("client %d: name is empty", i+1)
+		case names[cl.Name]:
+			return fmt.Errorf("client %s: duplicate name", cl.Name)
+		case cl.APIKey == "" && cl.APIKeySHA256 == "" && cl.CertCN == "":
+			return fmt.Errorf("client %s: needs api_key, api_key_sha256 or cert_cn", cl.Name)
+		case cl.APIKey != "" && cl.APIKeySHA256 != "":
+			return fmt.Errorf("client %s: set api_key or api_key_sha256, not both", cl.Name)
+		case cl.CertCN != "" && c.TLS.ClientCA == "":
+			return fmt.Errorf("client %s: cert_cn needs tls.client_ca", cl.Name)
+		}
+		names[cl.Name] = true
+
+		if sum, err := cl.keyHash(); err != nil {
+			return fmt.Errorf("client %s: %w", cl.Name, err)
+		} else if sum != "" {
+			if other, ok := keys[sum]; ok {
+				return fmt.Errorf("client %s: same API key as %s", cl.Name, other)
+			}
+			keys[sum] = cl.Name
+		}
+		if cl.CertCN != "" {
+			if other, ok := cns[cl.CertCN]; ok {
+				return fmt.Errorf("client %s: same cert_cn as %s", cl.Name, other)
+			}
+			cns[cl.CertCN] = cl.Name
+		}
+	}
+	return nil
+}
+
+// keyHash returns the hex SHA-256 of the client's key, empty without one
+func (cl ClientConfig) keyHash() (string, error) {
+	if cl.APIKey != "" {
+		return hashKey(cl.APIKey), nil
+	}
+	if cl.APIKeySHA256 == "" {
+		return "", nil
+	}
+	sum := strings.ToLower(cl.APIKeySHA256)
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("api_key_sha256 is not a hex SHA-256")
+	}
+	return sum, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ServerTLS loads the certificate and, if configured, the client CA. Client
+// certificates are optional so that API key clients can connect, but any
+// certificate presented must verify.
+func (t TLSConfig) ServerTLS() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.ClientCA != "" {
+		pem, err := os.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", t.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
@@ -0,0 +1,155 @@
+// Package authproxy is a TLS-terminating reverse proxy for the dispenser.
+// The protocol authenticates with one shared X-API-Key sent over plain
+// HTTP, acceptable only on the local network. The proxy serves HTTPS,
+// identifies each caller by its own API key or client certificate, and
+// forwards to the dispenser with the real key, which only the proxy knows.
+// Every request is logged with the name of the client it belongs to.
+package authproxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/logging"
+)
+
+// Authentication methods in the request log
+const (
+	AuthCert = "cert"
+	AuthKey  = "api_key"
+	AuthNone = "none" // /health, which the firmware serves without a key
+)
+
+// Proxy forwards authenticated requests to the dispenser. It is an
+// http.Handler; serve it with the tls.Config of TLSConfig.ServerTLS.
+type Proxy struct {
+	// Logger receives one record per request; nil discards
+	Logger *slog.Logger
+	// Clock measures latency; nil means clock.Real
+	Clock clock.Clock
+
+	upstream *url.URL
+	apiKey   string
+	byKey    map[string]string // hex SHA-256 of the key -> client name
+	byCN     map[string]string // certificate CN -> client name
+	rp       *httputil.ReverseProxy
+}
+
+// New returns a proxy for a validated config
+func New(cfg Config, logger *slog.Logger) (*Proxy, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	upstream, _ := url.Parse(cfg.Upstream.URL)
+	p := &Proxy{
+		Logger:   logger,
+		upstream: upstream,
+		apiKey:   cfg.Upstream.APIKey,
+		byKey:    make(map[string]string),
+		byCN:     make(map[string]string),
+	}
+	for _, cl := range cfg.Clients {
+		if sum, _ := cl.keyHash(); sum != "" {
+			p.byKey[sum] = cl.Name
+		}
+		if cl.CertCN != "" {
+			p.byCN[cl.CertCN] = cl.Name
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = cfg.Upstream.Timeout()
+	p.rp = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(p.upstream)
+			pr.SetXForwarded()
+			pr.Out.Host = p.upstream.Host
+			// Replaces the caller's own key
+			pr.Out.Header.Set("X-API-Key", p.apiKey)
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeError(w, http.StatusBadGateway, "dispenser unreachable")
+		},
+	}
+	return p, nil
+}
+
+// identify returns the client name and how it authenticated. A verified
+// client certificate takes precedence over an API key.
+func (p *Proxy) identify(r *http.Request) (name, method string, ok bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if name, ok := p.byCN[cn]; ok {
+			return name, AuthCert, true
+		}
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		if name, ok := p.byKey[hashKey(key)]; ok {
+			return name, AuthKey, true
+		}
+	}
+	return "", "", false
+}
+
+// ServeHTTP authenticates and forwards one request
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	clk := p.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	start := clk.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	name, method, ok := p.identify(r)
+	if !ok {
+		name, method = "-", AuthNone
+	}
+	if ok || (r.Method == http.MethodGet && r.URL.Path == "/health") {
+		p.rp.ServeHTTP(rec, r)
+	} else {
+		writeError(rec, http.StatusUnauthorized, "unauthorized")
+	}
+
+	level := slog.LevelInfo
+	if rec.status == http.StatusUnauthorized {
+		level = slog.LevelWarn
+	}
+	p.logger().Log(r.Context(), level, "request",
+		"client", name, "auth", method, "remote", r.RemoteAddr,
+		"method", r.Method, "path", r.URL.Path, "status", rec.status,
+		"latency", clk.Since(start).Round(time.Millisecond))
+}
+
+func (p *Proxy) logger() *slog.Logger {
+	return logging.OrDiscard(p.Logger)
+}
+
+// statusRecorder remembers the status code for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(client.ErrorResponse{Error: msg})
+}
@@ -0,0 +1,299 @@
+package authproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+const dispenserKey = "change-this-secret-key-here"
+
+// pki is a CA that signs the proxy's certificate and the clients'
+type pki struct {
+	dir    string
+	caFile string
+	ca     *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newPKI(t *testing.T) *pki {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	p := &pki{dir: t.TempDir(), ca: ca, key: key, serial: 1}
+	p.caFile = p.write(t, "ca.crt", "CERTIFICATE", der)
+	return p
+}
+
+// issue signs a certificate for cn, a server certificate for 127.0.0.1 if
+// server is set, and returns its certificate and key files
+func (p *pki) issue(t *testing.T, cn string, server bool) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.write(t, cn+".crt", "CERTIFICATE", der), p.write(t, cn+".key", "EC PRIVATE KEY", keyDER)
+}
+
+func (p *pki) write(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// upstream stands in for the dispenser and records the key it was sent
+type upstream struct {
+	*httptest.Server
+	mu   sync.Mutex
+	keys []string // X-API-Key of every request, "" if none
+}
+
+func newUpstream(t *testing.T) *upstream {
+	u := &upstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.keys = append(u.keys, r.Header.Get("X-API-Key"))
+		u.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/health" {
+			json.NewEncoder(w).Encode(client.HealthResponse{Status: "ok", Dispenser: "idle"})
+			return
+		}
+		json.NewEncoder(w).Encode(client.DispenseResponse{TxID: "a1b2c3d4", State: "done", Quantity: 1, Dispensed: 1})
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// seen returns the keys upstream received and forgets them
+func (u *upstream) seen() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	keys := u.keys
+	u.keys = nil
+	return keys
+}
+
+// requestLog collects the proxy's JSON request log
+type requestLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *requestLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+// last returns the client and auth method of the newest record
+func (l *requestLog) last(t *testing.T) (name, method string) {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(l.buf.String()), "\n")
+	var rec struct {
+		Client string `json:"client"`
+		Auth   string `json:"auth"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &rec); err != nil {
+		t.Fatalf("request log %q: %v", lines[len(lines)-1], err)
+	}
+	return rec.Client, rec.Auth
+}
+
+type testProxy struct {
+	url  string
+	pki  *pki
+	up   *upstream
+	log  *requestLog
+	keys map[string]string // client name -> API key
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	t.Helper()
+	p := newPKI(t)
+	certFile, keyFile := p.issue(t, "proxy", true)
+	up := newUpstream(t)
+	cfg := DefaultConfig()
+	cfg.Upstream = UpstreamConfig{URL: up.URL, APIKey: dispenserKey, TimeoutMS: 1000}
+	cfg.TLS = TLSConfig{Cert: certFile, Key: keyFile, ClientCA: p.caFile}
+	cfg.Clients = []ClientConfig{
+		{Name: "pos-1", APIKey: "pos-1-key"},
+		{Name: "pos-2", APIKeySHA256: hashKey("pos-2-key")},
+		{Name: "kiosk", CertCN: "kiosk.local"},
+	}
+
+	log := &requestLog{}
+	proxy, err := New(cfg, slog.New(slog.NewJSONHandler(log, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCfg, err := cfg.TLS.ServerTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(proxy)
+	srv.TLS = tlsCfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return &testProxy{url: srv.URL, pki: p, up: up, log: log,
+		keys: map[string]string{"pos-1": "pos-1-key", "pos-2": "pos-2-key"}}
+}
+
+// client returns a dispenser client for the proxy with key and, if cn is
+// set, a client certificate for cn
+func (p *testProxy) client(t *testing.T, key, cn string) *client.DispenserClient {
+	t.Helper()
+	c := client.NewDispenserClient(p.url, key, 5*time.Second)
+	var certFile, keyFile string
+	if cn != "" {
+		certFile, keyFile = p.pki.issue(t, cn, false)
+	}
+	if err := c.UseTLS(p.pki.caFile, certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestUnauthenticatedRejected(t *testing.T) {
+	p := newTestProxy(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct{ key, cn string }{
+		{"", ""},
+		{"wrong-key", ""},
+		{dispenserKey, ""}, // the dispenser's own key is not a client key
+		{"", "stranger.local"},
+	} {
+		c := p.client(t, tc.key, tc.cn)
+		if _, result := c.Status(ctx, "a1b2c3d4"); !errors.Is(result.Error, client.ErrUnauthorized) {
+			t.Errorf("key %q cert %q: GET %d %v, want 401", tc.key, tc.cn, result.StatusCode, result.Error)
+		}
+		if _, result := c.Dispense(ctx, "a1b2c3d4", 1); !errors.Is(result.Error, client.ErrUnauthorized) {
+			t.Errorf("key %q cert %q: POST %d %v, want 401", tc.key, tc.cn, result.StatusCode, result.Error)
+		}
+		if name, method := p.log.last(t); name != "-" || method != AuthNone {
+			t.Errorf("key %q cert %q: logged as %s/%s", tc.key, tc.cn, name, method)
+		}
+	}
+	if keys := p.up.seen(); len(keys) != 0 {
+		t.Errorf("%d unauthenticated requests reached the dispenser", len(keys))
+	}
+}
+
+func TestClientKeyReplaced(t *testing.T) {
+	p := newTestProxy(t)
+	for name, key := range p.keys {
+		resp, result := p.client(t, key, "").Status(context.Background(), "a1b2c3d4")
+		if result.Error != nil || resp.State != "done" {
+			t.Fatalf("%s: %+v %v", name, resp, result.Error)
+		}
+		if keys := p.up.seen(); len(keys) != 1 || keys[0] != dispenserKey {
+			t.Errorf("%s: dispenser received keys %q, want only the dispenser's", name, keys)
+		}
+		if got, method := p.log.last(t); got != name || method != AuthKey {
+			t.Errorf("logged as %s/%s, want %s/%s", got, method, name, AuthKey)
+		}
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	p := newTestProxy(t)
+
+	kiosk := p.client(t, "", "kiosk.local")
+	if _, result := kiosk.Status(context.Background(), "a1b2c3d4"); result.Error != nil {
+		t.Fatalf("kiosk certificate: %v", result.Error)
+	}
+	if name, method := p.log.last(t); name != "kiosk" || method != AuthCert {
+		t.Errorf("logged as %s/%s, want kiosk/%s", name, method, AuthCert)
+	}
+	if keys := p.up.seen(); len(keys) != 1 || keys[0] != dispenserKey {
+		t.Errorf("dispenser received keys %q, want only the dispenser's", keys)
+	}
+
+	// A verified certificate takes precedence over an API key
+	both := p.client(t, p.keys["pos-1"], "kiosk.local")
+	if _, result := both.Status(context.Background(), "a1b2c3d4"); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if name, method := p.log.last(t); name != "kiosk" || method != AuthCert {
+		t.Errorf("certificate and key logged as %s/%s, want kiosk/%s", name, method, AuthCert)
+	}
+}
+
+func TestHealthPassesThrough(t *testing.T) {
+	p := newTestProxy(t)
+	h, result := p.client(t, "", "").Health(context.Background())
+	if result.Error != nil || h.Status != "ok" {
+		t.Fatalf("GET /health without credentials: %+v %v", h, result.Error)
+	}
+	if keys := p.up.seen(); len(keys) != 1 {
+		t.Errorf("dispenser received %d requests, want 1", len(keys))
+	}
+	if name, method := p.log.last(t); name != "-" || method != AuthNone {
+		t.Errorf("logged as %s/%s, want -/%s", name, method, AuthNone)
+	}
+}
@@ -0,0 +1,133 @@
+// Package cassette records the HTTP traffic of a DispenserClient and plays
+// it back. A cassette is a JSON Lines file with one Interaction per
+// request: what was sent, what the dispenser answered and when. Recording
+// one on a misbehaving terminal and serving it with dispenser-replay lets
+// the TUI and other tools run against the incident without hardware.
+package cassette
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	// ElapsedMS is the request start relative to the first one of the
+	// recording, DurationMS how long the response took to arrive in full
+	ElapsedMS  float64 `json:"elapsed_ms"`
+	DurationMS float64 `json:"duration_ms"`
+
+	Request  Request   `json:"request"`
+	Response *Response `json:"response,omitempty"`
+	// Error is the transport error when there was no response
+	Error string `json:"error,omitempty"`
+}
+
+// Request is the recorded request. Credentials are redacted.
+type Request struct {
+	Method string      `json:"method"`
+	Host   string      `json:"host"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is the recorded response
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// Streamed is set for event streams, whose body is not recorded
+	Streamed bool `json:"streamed,omitempty"`
+}
+
+// Elapsed returns ElapsedMS as a duration
+func (in Interaction) Elapsed() time.Duration {
+	return millis(in.ElapsedMS)
+}
+
+// Duration returns DurationMS as a duration
+func (in Interaction) Duration() time.Duration {
+	return millis(in.DurationMS)
+}
+
+// Read parses a cassette. A truncated last line, left when the recording
+// process died mid-write, is ignored.
+func Read(r io.Reader) ([]Interaction, error) {
+	var interactions []Interaction
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	var pending error
+	for sc.Scan() {
+		line++
+		if pending != nil {
+			return nil, pending
+		}
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var in Interaction
+		if err := json.Unmarshal(sc.Bytes(), &in); err != nil {
+			pending = fmt.Errorf("line %d: %w", line, err)
+			continue
+		}
+		interactions = append(interactions, in)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(interactions, func(i, j int) bool {
+		return interactions[i].ElapsedMS < interactions[j].ElapsedMS
+	})
+	return interactions, nil
+}
+
+// Load reads the cassette at path
+func Load(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	interactions, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(interactions) == 0 {
+		return nil, errors.New(path + ": no interactions")
+	}
+	return interactions, nil
+}
+
+// Hosts returns the distinct hosts of the interactions, in order of first
+// appearance
+func Hosts(interactions []Interaction) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, in := range interactions {
+		if !seen[in.Request.Host] {
+			seen[in.Request.Host] = true
+			hosts = append(hosts, in.Request.Host)
+		}
+	}
+	return hosts
+}
+
+func millis(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,118 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/clock"
+)
+
+const apiKey = "change-this-secret-key-here"
+
+func TestRecordRedactsCredentials(t *testing.T) {
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("X-API-Key"))
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"tx_id":"a1b2c3d4","state":"dispensing","echo":` + string(body) + `}`))
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	var cassette bytes.Buffer
+	rec := NewRecorder(&cassette)
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	rec.Clock = clk
+	hc := &http.Client{Transport: rec.Transport(nil)}
+
+	do := func(method, path, body string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", apiKey)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The client still reads the whole body
+		if b, _ := io.ReadAll(resp.Body); len(b) == 0 {
+			t.Errorf("%s %s: empty body", method, path)
+		}
+		resp.Body.Close()
+	}
+	do(http.MethodGet, "/health", "")
+	clk.Advance(1500 * time.Millisecond)
+	do(http.MethodPost, "/dispense", `{"tx_id":"a1b2c3d4","quantity":2}`)
+
+	// The dispenser got the key, the cassette did not
+	if len(seen) != 2 || seen[0] != apiKey || seen[1] != apiKey {
+		t.Errorf("dispenser received keys %q", seen)
+	}
+	if strings.Contains(cassette.String(), apiKey) {
+		t.Fatalf("cassette contains the API key:\n%s", cassette.String())
+	}
+	interactions, err := Read(&cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interactions) != 2 {
+		t.Fatalf("%d interactions, want 2", len(interactions))
+	}
+	for _, in := range interactions {
+		if got := in.Request.Header.Get("X-API-Key"); got != redacted {
+			t.Errorf("%s %s: X-API-Key %q, want %s", in.Request.Method, in.Request.Path, got, redacted)
+		}
+		if got := in.Request.Header.Get("Authorization"); got != redacted {
+			t.Errorf("%s %s: Authorization %q, want %s", in.Request.Method, in.Request.Path, got, redacted)
+		}
+	}
+	post := interactions[1]
+	if post.Seq != 2 || post.ElapsedMS != 1500 || post.Request.Body != `{"tx_id":"a1b2c3d4","quantity":2}` ||
+		post.Response == nil || post.Response.Status != 200 || !strings.Contains(post.Response.Body, `"echo":{"tx_id"`) {
+		t.Errorf("POST recorded as %+v", post)
+	}
+}
+
+// line encodes one interaction as a cassette line
+func line(t *testing.T, in Interaction) string {
+	t.Helper()
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b) + "\n"
+}
+
+func TestReadTruncatedLastLine(t *testing.T) {
+	health := Interaction{Seq: 2, ElapsedMS: 250, Request: Request{Method: "GET", Path: "/health"}}
+	dispense := Interaction{Seq: 1, ElapsedMS: 100, Request: Request{Method: "POST", Path: "/dispense"}}
+	full := line(t, health) + "\n" + line(t, dispense)
+	torn := line(t, health)[:40]
+
+	interactions, err := Read(strings.NewReader(full + torn))
+	if err != nil {
+		t.Fatalf("torn last line: %v", err)
+	}
+	// Ordered by start, not by completion
+	if len(interactions) != 2 || interactions[0].Seq != 1 || interactions[1].Seq != 2 {
+		t.Errorf("read %+v, want seq 1 and 2", interactions)
+	}
+
+	// Damage anywhere else is an error
+	if _, err := Read(strings.NewReader(torn + "\n" + full)); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("torn first line: err = %v, want line 1", err)
+	}
+}
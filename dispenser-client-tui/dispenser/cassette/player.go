@@ -0,0 +1,265 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/clock"
+)
+
+// AdminPrefix is where the replay control endpoints are mounted
+const AdminPrefix = "/_replay/"
+
+// Player serves a cassette as if it were the dispenser. By default a
+// request gets the response that was current at the same point of the
+// recording, so health and transaction states unfold as they did, and
+// requests from before the first matching interaction get that one.
+// Sequential mode instead serves each endpoint's responses in recorded
+// order, one per request, repeating the last.
+//
+// Requests match recorded ones by method and path, or by endpoint when the
+// path is not in the cassette, e.g. a status request for a transaction ID
+// the replaying client generated. Transaction IDs in such responses are
+// rewritten to the requested one.
+type Player struct {
+	// Speed scales time: 1 replays with the original timing and latency,
+	// 10 ten times faster; zero means 1
+	Speed float64
+	// Sequential serves responses per endpoint in order instead of by time
+	Sequential bool
+	// Loop restarts the timeline after the last interaction
+	Loop bool
+	// Clock paces the replay; nil means clock.Real
+	Clock clock.Clock
+
+	interactions []Interaction
+	span         time.Duration
+
+	mu     sync.Mutex
+	start  time.Time // of the replay, set by the first request
+	next   map[string]int
+	served int
+}
+
+// NewPlayer returns a Player for interactions ordered by ElapsedMS, as
+// returned by Read
+func NewPlayer(interactions []Interaction) *Player {
+	var span time.Duration
+	for _, in := range interactions {
+		span = max(span, in.Elapsed()+in.Duration())
+	}
+	return &Player{interactions: interactions, span: span, next: make(map[string]int)}
+}
+
+func (p *Player) clock() clock.Clock {
+	if p.Clock == nil {
+		return clock.Real
+	}
+	return p.Clock
+}
+
+func (p *Player) speed() float64 {
+	if p.Speed <= 0 {
+		return 1
+	}
+	return p.Speed
+}
+
+// Rewind starts the replay over with the next request
+func (p *Player) Rewind() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start = time.Time{}
+	p.next = make(map[string]int)
+	p.served = 0
+}
+
+// positionLocked returns how far into the recording the replay is; p.mu
+// must be held
+func (p *Player) positionLocked() time.Duration {
+	if p.start.IsZero() {
+		return 0
+	}
+	pos := time.Duration(float64(p.clock().Since(p.start)) * p.speed())
+	if p.Loop && p.span > 0 {
+		pos %= p.span
+	}
+	return pos
+}
+
+// match picks the interaction answering a request
+func (p *Player) match(method, path string) (Interaction, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.start.IsZero() {
+		p.start = p.clock().Now()
+	}
+
+	key := method + " " + path
+	candidates := p.candidates(func(r Request) bool { return r.Method == method && r.Path == path })
+	if len(candidates) == 0 {
+		key = method + " " + endpoint(path)
+		candidates = p.candidates(func(r Request) bool { return r.Method == method && endpoint(r.Path) == endpoint(path) })
+	}
+	if len(candidates) == 0 {
+		return Interaction{}, false
+	}
+	p.served++
+
+	if p.Sequential {
+		i := min(p.next[key], len(candidates)-1)
+		p.next[key] = i + 1
+		return candidates[i], true
+	}
+	pos := p.positionLocked()
+	best := candidates[0]
+	for _, in := range candidates[1:] {
+		if in.Elapsed() > pos {
+			break
+		}
+		best = in
+	}
+	return best, true
+}
+
+func (p *Player) candidates(match func(Request) bool) []Interaction {
+	var out []Interaction
+	for _, in := range p.interactions {
+		if match(in.Request) {
+			out = append(out, in)
+		}
+	}
+	return out
+}
+
+// endpoint collapses request paths into the protocol's endpoints
+func endpoint(path string) string {
+	if strings.HasPrefix(path, "/dispense/") {
+		return "/dispense/{tx_id}"
+	}
+	return path
+}
+
+// Handler serves the cassette, and the replay controls under AdminPrefix:
+//
+//	GET  /_replay/state   position in the recording and requests served
+//	POST /_replay/rewind  start over with the next request
+func (p *Player) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+AdminPrefix+"state", p.handleState)
+	mux.HandleFunc("POST "+AdminPrefix+"rewind", p.handleRewind)
+	mux.HandleFunc("/", p.handleReplay)
+	return mux
+}
+
+func (p *Player) handleReplay(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	in, ok := p.match(req.Method, req.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no recorded response for "+req.Method+" "+req.URL.Path)
+		return
+	}
+
+	// The original latency, scaled like the timeline
+	select {
+	case <-req.Context().Done():
+		return
+	case <-p.clock().After(time.Duration(float64(in.Duration()) / p.speed())):
+	}
+
+	if in.Response == nil {
+		// The request failed without a response: fail it the same way as
+		// far as HTTP allows
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		writeError(w, http.StatusBadGateway, "recorded error: "+in.Error)
+		return
+	}
+
+	resp := in.Response
+	for name, values := range resp.Header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Date", "Connection", "Transfer-Encoding":
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.Status)
+	if !resp.Streamed {
+		w.Write(rewriteTxID([]byte(resp.Body), txID(in.Request.Path, []byte(in.Request.Body)), txID(req.URL.Path, body)))
+	}
+}
+
+// txID returns the transaction a request is about, from the path of a
+// status request or the body of a dispense request
+func txID(path string, body []byte) string {
+	if id, ok := strings.CutPrefix(path, "/dispense/"); ok {
+		if unescaped, err := url.PathUnescape(id); err == nil {
+			return unescaped
+		}
+		return id
+	}
+	var req struct {
+		TxID string `json:"tx_id"`
+	}
+	json.Unmarshal(body, &req)
+	return req.TxID
+}
+
+// rewriteTxID replaces the recorded transaction ID in a response body
+func rewriteTxID(body []byte, recorded, requested string) []byte {
+	if recorded == "" || requested == "" || recorded == requested {
+		return body
+	}
+	from, _ := json.Marshal(recorded)
+	to, _ := json.Marshal(requested)
+	return bytes.ReplaceAll(body, from, to)
+}
+
+// State is GET /_replay/state
+type State struct {
+	PositionMS   float64 `json:"position_ms"`
+	DurationMS   float64 `json:"duration_ms"`
+	Interactions int     `json:"interactions"`
+	Served       int     `json:"served"`
+	Speed        float64 `json:"speed"`
+	Sequential   bool    `json:"sequential"`
+	Loop         bool    `json:"loop"`
+}
+
+func (p *Player) handleState(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	st := State{
+		PositionMS:   toMillis(p.positionLocked()),
+		DurationMS:   toMillis(p.span),
+		Interactions: len(p.interactions),
+		Served:       p.served,
+		Speed:        p.speed(),
+		Sequential:   p.Sequential,
+		Loop:         p.Loop,
+	}
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func (p *Player) handleRewind(w http.ResponseWriter, req *http.Request) {
+	p.Rewind()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,130 @@
+package cassette
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/clock"
+)
+
+// recording is a dispense of a1b2c3d4 while /health is polled
+var recording = []Interaction{
+	{ElapsedMS: 0, Request: Request{Method: "GET", Path: "/health"},
+		Response: &Response{Status: 200, Body: `{"dispenser":"idle"}`}},
+	{ElapsedMS: 500, Request: Request{Method: "POST", Path: "/dispense", Body: `{"tx_id":"a1b2c3d4","quantity":2}`},
+		Response: &Response{Status: 200, Body: `{"tx_id":"a1b2c3d4","state":"dispensing","dispensed":0}`}},
+	{ElapsedMS: 1000, Request: Request{Method: "GET", Path: "/health"},
+		Response: &Response{Status: 200, Body: `{"dispenser":"dispensing"}`}},
+	{ElapsedMS: 1500, Request: Request{Method: "GET", Path: "/dispense/a1b2c3d4"},
+		Response: &Response{Status: 200, Body: `{"tx_id":"a1b2c3d4","state":"dispensing","dispensed":1}`}},
+	{ElapsedMS: 2000, Request: Request{Method: "GET", Path: "/health"},
+		Response: &Response{Status: 200, Body: `{"dispenser":"idle"}`}},
+	{ElapsedMS: 2500, Request: Request{Method: "GET", Path: "/dispense/a1b2c3d4"},
+		Response: &Response{Status: 200, Body: `{"tx_id":"a1b2c3d4","state":"done","dispensed":2}`}},
+}
+
+// serve starts a player for the recording on a fake clock
+func serve(t *testing.T, sequential bool) (*httptest.Server, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := NewPlayer(recording)
+	p.Clock = clk
+	p.Sequential = sequential
+	srv := httptest.NewServer(p.Handler())
+	t.Cleanup(srv.Close)
+	return srv, clk
+}
+
+// get returns the body the player answers a request with
+func get(t *testing.T, srv *httptest.Server, method, path, body string) string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestPlayerFollowsTimeline(t *testing.T) {
+	srv, clk := serve(t, false)
+
+	for _, step := range []struct {
+		advance    time.Duration
+		path, want string
+	}{
+		// Before the first status poll: the first one recorded
+		{0, "/dispense/a1b2c3d4", `"dispensed":1`},
+		{0, "/health", `"idle"`},
+		{1200 * time.Millisecond, "/health", `"dispensing"`},
+		{200 * time.Millisecond, "/dispense/a1b2c3d4", `"dispensed":1`},
+		{time.Second, "/health", `"idle"`},
+		{200 * time.Millisecond, "/dispense/a1b2c3d4", `"done"`},
+		// Past the end: the last response stays
+		{time.Minute, "/dispense/a1b2c3d4", `"done"`},
+	} {
+		clk.Advance(step.advance)
+		if got := get(t, srv, "GET", step.path, ""); !strings.Contains(got, step.want) {
+			t.Errorf("GET %s at %s: %s, want %s", step.path, clk.Since(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)), got, step.want)
+		}
+	}
+}
+
+func TestPlayerSequential(t *testing.T) {
+	srv, clk := serve(t, true)
+	clk.Advance(time.Hour) // time does not matter
+
+	for i, want := range []string{`"idle"`, `"dispensing"`, `"idle"`, `"idle"`} {
+		if got := get(t, srv, "GET", "/health", ""); !strings.Contains(got, want) {
+			t.Errorf("health #%d: %s, want %s", i+1, got, want)
+		}
+	}
+	// Each endpoint has its own position
+	for i, want := range []string{`"dispensed":1`, `"done"`, `"done"`} {
+		if got := get(t, srv, "GET", "/dispense/a1b2c3d4", ""); !strings.Contains(got, want) {
+			t.Errorf("status #%d: %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestPlayerRewritesTxID(t *testing.T) {
+	srv, clk := serve(t, true)
+	clk.Advance(3 * time.Second)
+
+	if got := get(t, srv, "POST", "/dispense", `{"tx_id":"ffff0000","quantity":2}`); !strings.Contains(got, `"tx_id":"ffff0000"`) {
+		t.Errorf("POST for another tx: %s, want tx_id ffff0000", got)
+	}
+	if got := get(t, srv, "GET", "/dispense/ffff0000", ""); !strings.Contains(got, `"tx_id":"ffff0000"`) || strings.Contains(got, "a1b2c3d4") {
+		t.Errorf("status of another tx: %s, want tx_id ffff0000", got)
+	}
+	if got := get(t, srv, "GET", "/nowhere", ""); !strings.Contains(got, "no recorded response") {
+		t.Errorf("unrecorded endpoint: %s", got)
+	}
+}
+
+func TestRewriteTxID(t *testing.T) {
+	for _, tc := range []struct {
+		body, recorded, requested, want string
+	}{
+		{`{"tx_id":"a1b2c3d4"}`, "a1b2c3d4", "ffff0000", `{"tx_id":"ffff0000"}`},
+		{`{"tx_id":"a1b2c3d4","active_tx_id":"a1b2c3d4"}`, "a1b2c3d4", "ffff0000", `{"tx_id":"ffff0000","active_tx_id":"ffff0000"}`},
+		// Only whole JSON strings, not text mentioning the ID
+		{`{"tx_id":"a1b2c3d4","error":"a1b2c3d4 jammed"}`, "a1b2c3d4", "ffff0000", `{"tx_id":"ffff0000","error":"a1b2c3d4 jammed"}`},
+		{`{"tx_id":"a1b2c3d4"}`, "", "ffff0000", `{"tx_id":"a1b2c3d4"}`},
+		{`{"tx_id":"a1b2c3d4"}`, "a1b2c3d4", "", `{"tx_id":"a1b2c3d4"}`},
+	} {
+		if got := string(rewriteTxID([]byte(tc.body), tc.recorded, tc.requested)); got != tc.want {
+			t.Errorf("rewriteTxID(%s, %q, %q) = %s, want %s", tc.body, tc.recorded, tc.requested, got, tc.want)
+		}
+	}
+}
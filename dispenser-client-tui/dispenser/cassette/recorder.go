@@ -0,0 +1,192 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/clock"
+)
+
+// redacted replaces credential header values in a cassette
+const redacted = "REDACTED"
+
+// credentialHeaders are never written to a cassette
+var credentialHeaders = []string{"X-API-Key", "Authorization", "Cookie"}
+
+// Recorder writes interactions to a cassette. One Recorder can serve the
+// transports of several clients, e.g. a fleet, which then share the
+// timeline. Each interaction is a single Write, so the cassette stays
+// readable if the process dies.
+type Recorder struct {
+	// Clock timestamps interactions; nil means clock.Real
+	Clock clock.Clock
+
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	seq   int
+	err   error
+}
+
+// NewRecorder returns a Recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+func (r *Recorder) clock() clock.Clock {
+	if r.Clock == nil {
+		return clock.Real
+	}
+	return r.Clock
+}
+
+// Err returns the first error writing the cassette. Recording failures do
+// not fail the requests.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Transport returns an http.RoundTripper that performs requests with base,
+// nil meaning http.DefaultTransport, and records them
+func (r *Recorder) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{rec: r, base: base}
+}
+
+type transport struct {
+	rec  *Recorder
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper. The response body is read in
+// full, except for event streams, so the duration covers the whole
+// response as the client saw it.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, req, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	clk := t.rec.clock()
+	start := clk.Now()
+	t.rec.begin(start)
+	in := Interaction{
+		Time: start,
+		Request: Request{
+			Method: req.Method,
+			Host:   req.URL.Host,
+			Path:   req.URL.Path,
+			Query:  req.URL.RawQuery,
+			Header: redact(req.Header),
+			Body:   string(reqBody),
+		},
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		in.Error = err.Error()
+		t.rec.write(in, start, clk.Since(start))
+		return nil, err
+	}
+
+	in.Response = &Response{Status: resp.StatusCode, Header: resp.Header.Clone()}
+	if isStream(resp) {
+		in.Response.Streamed = true
+		t.rec.write(in, start, clk.Since(start))
+		return resp, nil
+	}
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	in.Response.Body = string(body)
+	var rest io.Reader = bytes.NewReader(body)
+	if readErr != nil {
+		in.Error = readErr.Error()
+		rest = io.MultiReader(rest, errReader{readErr})
+	}
+	resp.Body = io.NopCloser(rest)
+	t.rec.write(in, start, clk.Since(start))
+	return resp, nil
+}
+
+// begin starts the timeline with the first request
+func (r *Recorder) begin(start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() {
+		r.start = start
+	}
+}
+
+// write appends one interaction, numbered in order of completion and timed
+// relative to the first request
+func (r *Recorder) write(in Interaction, start time.Time, took time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	in.Seq = r.seq
+	in.ElapsedMS = toMillis(start.Sub(r.start))
+	in.DurationMS = toMillis(took)
+
+	line, err := json.Marshal(in)
+	if err == nil {
+		_, err = r.w.Write(append(line, '\n'))
+	}
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// readRequestBody returns the body of req and a request that can still be
+// sent with it
+func readRequestBody(req *http.Request) ([]byte, *http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, req, nil
+	}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rc.Close()
+		body, err := io.ReadAll(rc)
+		return body, req, err
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, req, nil
+}
+
+func redact(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range credentialHeaders {
+		if h.Get(name) != "" {
+			h.Set(name, redacted)
+		}
+	}
+	return h
+}
+
+func isStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// errReader returns err after the recorded part of a body that failed to
+// read, so the client sees the same failure
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
@@ -0,0 +1,203 @@
+// Package client is a Go client for the token dispenser HTTP API described in
+// dispenser-protocol.md. It is used by the TUI and can be imported by POS
+// backends and scripts that talk to the ESP8266 directly.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"token-tui/dispenser/clock"
+)
+
+// DispenserClient wraps HTTP calls to the ESP8266
+type DispenserClient struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	// Clock measures latency and paces polling; nil means clock.Real
+	Clock clock.Clock
+	// Journal, if set, records every dispense intent before it is sent and
+	// each state observed afterwards
+	Journal *Journal
+}
+
+// NewDispenserClient returns a client for the dispenser at baseURL. A missing
+// scheme defaults to http://. The timeout is applied per request in addition
+// to any deadline carried by the caller's context.
+func NewDispenserClient(baseURL, apiKey string, timeout time.Duration) *DispenserClient {
+	// Normalize base URL
+	baseURL = strings.TrimRight(baseURL, "/")
+	if !strings.HasPrefix(baseURL, "http") {
+		baseURL = "http://" + baseURL
+	}
+
+	return &DispenserClient{
+		BaseURL: baseURL,
+		APIKey:  apiKey,
+		HTTPClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (c *DispenserClient) clock() clock.Clock {
+	if c.Clock == nil {
+		return clock.Real
+	}
+	return c.Clock
+}
+
+// APIResult describes the outcome of a single HTTP round trip
+type APIResult struct {
+	StatusCode int
+	Latency    time.Duration
+	Error      error
+	// Raw bodies as sent and received, for request logs; nil when there
+	// was none or the request never got that far
+	RequestBody  []byte
+	ResponseBody []byte
+}
+
+// rawResponse is an HTTP response whose body has been fully read
+type rawResponse struct {
+	statusCode int
+	body       []byte
+}
+
+// do performs one request against the dispenser. Transport errors and
+// context cancellation are reported in the returned APIResult; HTTP error
+// statuses are left for the caller to turn into an *APIError.
+func (c *DispenserClient) do(ctx context.Context, method, path string, payload any, auth bool) (*rawResponse, APIResult) {
+	clk := c.clock()
+	start := clk.Now()
+
+	var body io.Reader
+	var reqBody []byte
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, APIResult{Error: err, Latency: clk.Since(start)}
+		}
+		body, reqBody = bytes.NewReader(data), data
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, APIResult{Error: err, Latency: clk.Since(start), RequestBody: reqBody}
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, APIResult{Error: err, Latency: clk.Since(start), RequestBody: reqBody}
+	}
+	defer resp.Body.Close()
+
+	latency := clk.Since(start)
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, APIResult{StatusCode: resp.StatusCode, Error: err, Latency: latency, RequestBody: reqBody, ResponseBody: data}
+	}
+
+	return &rawResponse{statusCode: resp.StatusCode, body: data},
+		APIResult{StatusCode: resp.StatusCode, Latency: latency, RequestBody: reqBody, ResponseBody: data}
+}
+
+// Health fetches GET /health (no auth required)
+func (c *DispenserClient) Health(ctx context.Context) (*HealthResponse, APIResult) {
+	raw, result := c.do(ctx, http.MethodGet, "/health", nil, false)
+	if result.Error != nil {
+		return nil, result
+	}
+
+	if raw.statusCode != http.StatusOK {
+		result.Error = newAPIError(raw.statusCode, raw.body)
+		return nil, result
+	}
+
+	var health HealthResponse
+	if err := json.Unmarshal(raw.body, &health); err != nil {
+		result.Error = err
+		return nil, result
+	}
+
+	return &health, result
+}
+
+// Dispense sends POST /dispense (auth required). With a Journal the intent
+// is stored first; if that fails the request is not sent. A refusal that
+// cannot be journaled returns both errors.
+func (c *DispenserClient) Dispense(ctx context.Context, txID string, quantity int) (*DispenseResponse, APIResult) {
+	if c.Journal != nil {
+		if err := c.Journal.Intent(txID, quantity); err != nil {
+			return nil, APIResult{Error: err}
+		}
+	}
+
+	raw, result := c.do(ctx, http.MethodPost, "/dispense", DispenseRequest{TxID: txID, Quantity: quantity}, true)
+	if result.Error != nil {
+		return nil, result
+	}
+
+	if raw.statusCode != http.StatusOK {
+		result.Error = newAPIError(raw.statusCode, raw.body)
+		if c.Journal != nil {
+			if err := c.Journal.Reject(txID, result.Error); err != nil {
+				result.Error = fmt.Errorf("%w; %w", result.Error, err)
+			}
+		}
+		return nil, result
+	}
+
+	var dispResp DispenseResponse
+	if err := json.Unmarshal(raw.body, &dispResp); err != nil {
+		result.Error = err
+		return nil, result
+	}
+
+	c.observe(&dispResp)
+	return &dispResp, result
+}
+
+// Status fetches GET /dispense/{tx_id} (auth required)
+func (c *DispenserClient) Status(ctx context.Context, txID string) (*DispenseResponse, APIResult) {
+	raw, result := c.do(ctx, http.MethodGet, "/dispense/"+url.PathEscape(txID), nil, true)
+	if result.Error != nil {
+		return nil, result
+	}
+
+	if raw.statusCode != http.StatusOK {
+		result.Error = newAPIError(raw.statusCode, raw.body)
+		return nil, result
+	}
+
+	var dispResp DispenseResponse
+	if err := json.Unmarshal(raw.body, &dispResp); err != nil {
+		result.Error = err
+		return nil, result
+	}
+
+	c.observe(&dispResp)
+	return &dispResp, result
+}
+
+// observe journals a state reported by the dispenser. A failed write is
+// not fatal here: the intent is already stored, so recovery still works.
+func (c *DispenserClient) observe(resp *DispenseResponse) {
+	if c.Journal != nil {
+		c.Journal.Observe(*resp)
+	}
+}
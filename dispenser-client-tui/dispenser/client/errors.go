@@ -0,0 +1,86 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors for the HTTP error statuses documented in
+// dispenser-protocol.md. Errors returned in APIResult.Error match them via
+// errors.Is; use errors.As with *APIError to get at the response details.
+var (
+	ErrInvalidRequest       = errors.New("invalid request")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrNotFound             = errors.New("transaction not found")
+	ErrBusy                 = errors.New("dispenser busy")
+	ErrDispenserFault       = errors.New("dispenser in error state")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// APIError is a non-200 response from the dispenser
+type APIError struct {
+	StatusCode int
+	// Message is the "error" field of the response body, or the raw body
+	// if it was not JSON.
+	Message string
+	// ActiveTxID and ActiveState are only set on 409 Conflict. ActiveState
+	// "dispensing" means busy, "error" means jammed or faulted.
+	ActiveTxID  string
+	ActiveState string
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.StatusCode == http.StatusConflict && e.ActiveState == "error":
+		return fmt.Sprintf("dispenser fault: active tx %s in error state", e.ActiveTxID)
+	case e.StatusCode == http.StatusConflict:
+		return fmt.Sprintf("busy: active tx %s", e.ActiveTxID)
+	case e.Message != "":
+		return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+	default:
+		return fmt.Sprintf("unexpected status %d", e.StatusCode)
+	}
+}
+
+// Is maps the status code (and, for 409, the active state) to the
+// matching sentinel error.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInvalidRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrBusy:
+		return e.StatusCode == http.StatusConflict && !e.isFault()
+	case ErrDispenserFault:
+		return e.StatusCode == http.StatusConflict && e.isFault()
+	case ErrUnsupportedMediaType:
+		return e.StatusCode == http.StatusUnsupportedMediaType
+	}
+	return false
+}
+
+func (e *APIError) isFault() bool {
+	return e.ActiveState == "error" || e.Message == "error"
+}
+
+// newAPIError builds an APIError from a raw non-200 response
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode}
+
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != "" {
+		apiErr.Message = errResp.Error
+		apiErr.ActiveTxID = errResp.ActiveTxID
+		apiErr.ActiveState = errResp.ActiveState
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+
+	return apiErr
+}
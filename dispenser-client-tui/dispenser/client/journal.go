@@ -0,0 +1,319 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/clock"
+)
+
+// Journal states besides the protocol's dispensing, done and error
+const (
+	JournalIntent   = "intent"   // about to POST, not yet acknowledged
+	JournalRejected = "rejected" // the dispenser refused the POST, nothing ran
+	JournalUnknown  = "unknown"  // final state never observed, needs manual review
+	JournalReviewed = "reviewed" // an unknown outcome settled by an operator
+)
+
+// errJournalReadOnly is returned when recording to a journal from ReadJournal
+var errJournalReadOnly = errors.New("journal opened read-only")
+
+// JournalRecord is one line of the journal: the state of a transaction as
+// last observed at Time
+type JournalRecord struct {
+	Time      time.Time `json:"time"`
+	TxID      string    `json:"tx_id"`
+	Quantity  int       `json:"quantity"`
+	State     string    `json:"state"`
+	Dispensed int       `json:"dispensed"`
+	Error     string    `json:"error,omitempty"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// Finished reports whether the record is a final state
+func (r JournalRecord) Finished() bool {
+	switch r.State {
+	case "done", "error", JournalRejected, JournalUnknown, JournalReviewed:
+		return true
+	}
+	return false
+}
+
+// Journal is an append-only JSON Lines file of transaction state changes.
+// The intent to dispense is written and synced before POST /dispense, so a
+// crash of the client at any point leaves a record that Recover can resolve
+// against the dispenser. It is safe for concurrent use.
+type Journal struct {
+	// Clock timestamps records; nil means clock.Real
+	Clock clock.Clock
+
+	mu     sync.Mutex
+	f      *os.File
+	latest map[string]JournalRecord
+}
+
+// OpenJournal opens or creates the journal at path and replays it. A torn
+// last line from a crash during a write is cut off so that new records
+// start on a line of their own.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{f: f}
+	complete, err := j.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading journal %s: %w", path, err)
+	}
+	if err := f.Truncate(complete); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("repairing journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// ReadJournal replays the existing journal at path without ever writing to
+// it, for reports on a journal another process may be appending to. A torn
+// last line is skipped. Methods that record a state return an error on the
+// returned journal.
+func ReadJournal(path string) (*Journal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	j := &Journal{}
+	if _, err := j.replay(f); err != nil {
+		return nil, fmt.Errorf("reading journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// replay loads the records from r and returns the length up to the last
+// newline
+func (j *Journal) replay(r io.Reader) (int64, error) {
+	j.latest = make(map[string]JournalRecord)
+	var complete int64
+	rd := bufio.NewReader(r)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			return complete, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		complete += int64(len(line))
+
+		var rec JournalRecord
+		if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		j.latest[rec.TxID] = rec
+	}
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// Intent records that txID is about to be dispensed. It returns only once
+// the record is on stable storage. Repeated intents for a known tx_id, as
+// on a POST retry, are not written again, unless the previous POST was
+// rejected (e.g. busy) and this is a new attempt.
+func (j *Journal) Intent(txID string, quantity int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if prev, ok := j.latest[txID]; ok && prev.State != JournalRejected {
+		return nil
+	}
+	return j.appendLocked(JournalRecord{TxID: txID, Quantity: quantity, State: JournalIntent})
+}
+
+// Observe records a state reported by the dispenser for a journaled
+// transaction. Responses for unknown tx_ids and unchanged states are ignored.
+func (j *Journal) Observe(resp DispenseResponse) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	prev, ok := j.latest[resp.TxID]
+	if !ok || (prev.State == resp.State && prev.Dispensed == resp.Dispensed) {
+		return nil
+	}
+	return j.appendLocked(JournalRecord{
+		TxID:      resp.TxID,
+		Quantity:  resp.Quantity,
+		State:     resp.State,
+		Dispensed: resp.Dispensed,
+		Error:     resp.Error,
+	})
+}
+
+// Reject records that the dispenser refused the POST for txID. It has no
+// effect once the dispenser acknowledged the transaction.
+func (j *Journal) Reject(txID string, cause error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	prev, ok := j.latest[txID]
+	if !ok || prev.State != JournalIntent {
+		return nil
+	}
+	prev.State = JournalRejected
+	prev.Error = cause.Error()
+	return j.appendLocked(prev)
+}
+
+// MarkUnknown flags txID for manual review because its final state can no
+// longer be observed
+func (j *Journal) MarkUnknown(txID, note string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	prev, ok := j.latest[txID]
+	if !ok || prev.Finished() {
+		return nil
+	}
+	prev.State = JournalUnknown
+	prev.Error = ""
+	prev.Note = note
+	return j.appendLocked(prev)
+}
+
+// Acknowledge settles an unknown outcome after manual review. The note
+// should say what was found, e.g. how many tokens the customer received.
+func (j *Journal) Acknowledge(txID, note string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	prev, ok := j.latest[txID]
+	if !ok || prev.State != JournalUnknown {
+		return fmt.Errorf("tx %s has no unknown outcome to acknowledge", txID)
+	}
+	prev.State = JournalReviewed
+	prev.Note = note
+	return j.appendLocked(prev)
+}
+
+// Lookup returns the latest record of txID
+func (j *Journal) Lookup(txID string) (JournalRecord, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec, ok := j.latest[txID]
+	return rec, ok
+}
+
+// Records returns the latest record of every transaction, oldest first
+func (j *Journal) Records() []JournalRecord {
+	return j.filter(func(JournalRecord) bool { return true })
+}
+
+// Unfinished returns the transactions without a final state, oldest first
+func (j *Journal) Unfinished() []JournalRecord {
+	return j.filter(func(r JournalRecord) bool { return !r.Finished() })
+}
+
+// NeedsReview returns the transactions whose outcome is unknown
+func (j *Journal) NeedsReview() []JournalRecord {
+	return j.filter(func(r JournalRecord) bool { return r.State == JournalUnknown })
+}
+
+func (j *Journal) filter(keep func(JournalRecord) bool) []JournalRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []JournalRecord
+	for _, rec := range j.latest {
+		if keep(rec) {
+			out = append(out, rec)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Time.Before(out[b].Time) })
+	return out
+}
+
+func (j *Journal) appendLocked(rec JournalRecord) error {
+	clk := j.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	rec.Time = clk.Now()
+
+	if j.f == nil {
+		return errJournalReadOnly
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("journal write: %w", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("journal sync: %w", err)
+	}
+	j.latest[rec.TxID] = rec
+	return nil
+}
+
+// Recovery is the resolution of one unfinished journal entry
+type Recovery struct {
+	Record JournalRecord `json:"record"` // latest record after recovery
+	Err    error         `json:"-"`      // set when the dispenser could not be asked
+}
+
+// Recover resolves every unfinished transaction of the client's journal via
+// GET /dispense/{tx_id}, waiting for ones still dispensing. Transactions
+// that fell out of the firmware's 8-entry history are marked unknown for
+// manual review. Entries that could not be checked stay unfinished and are
+// reported with Err set.
+func (c *DispenserClient) Recover(ctx context.Context, opts WaitOptions) ([]Recovery, error) {
+	if c.Journal == nil {
+		return nil, errors.New("client has no journal")
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+
+	var out []Recovery
+	for _, rec := range c.Journal.Unfinished() {
+		err := c.resolve(ctx, rec.TxID, opts)
+		if errors.Is(err, ErrUnauthorized) || ctx.Err() != nil {
+			return out, err
+		}
+		final, _ := c.Journal.Lookup(rec.TxID)
+		out = append(out, Recovery{Record: final, Err: err})
+	}
+	return out, nil
+}
+
+func (c *DispenserClient) resolve(ctx context.Context, txID string, opts WaitOptions) error {
+	resp, result := c.Status(ctx, txID)
+	for {
+		switch {
+		case errors.Is(result.Error, ErrNotFound):
+			return c.Journal.MarkUnknown(txID, "not in dispenser history (restarted or more than 8 newer transactions)")
+		case result.Error != nil:
+			return result.Error
+		case !resp.InProgress():
+			return nil
+		}
+
+		var err error
+		resp, err = c.pollUntilSeen(ctx, txID, opts)
+		result = APIResult{Error: err}
+	}
+}
@@ -0,0 +1,85 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+func TestReadJournalNeverWrites(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing.jsonl")
+	if _, err := client.ReadJournal(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing journal: %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadJournal created %s", missing)
+	}
+
+	// Two records and the torn tail of a third
+	path := filepath.Join(dir, "journal.jsonl")
+	data := `{"time":"2026-01-01T12:00:00Z","tx_id":"a1b2c3d4","quantity":2,"state":"intent","dispensed":0}
+{"time":"2026-01-01T12:00:05Z","tx_id":"a1b2c3d4","quantity":2,"state":"done","dispensed":2}
+{"time":"2026-01-01T12:01:00Z","tx_id":"e5f6a7b8","quan`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := client.ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := j.Records()
+	if len(recs) != 1 || recs[0].TxID != "a1b2c3d4" || recs[0].State != "done" {
+		t.Errorf("records = %+v, want a1b2c3d4 done", recs)
+	}
+	if err := j.Intent("e5f6a7b8", 1); err == nil {
+		t.Error("Intent on a read-only journal succeeded")
+	}
+	if err := j.Close(); err != nil {
+		t.Error(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("journal changed to %q", got)
+	}
+}
+
+func TestDispenseReportsFailedReject(t *testing.T) {
+	j, err := client.OpenJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The journal breaks while the request is out, after the intent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(client.ErrorResponse{Error: "busy", ActiveTxID: "e5f6a7b8", ActiveState: "dispensing"})
+	}))
+	defer srv.Close()
+
+	c := client.NewDispenserClient(srv.URL, "key", time.Second)
+	c.Journal = j
+	_, result := c.Dispense(context.Background(), "a1b2c3d4", 1)
+	if !errors.Is(result.Error, client.ErrBusy) {
+		t.Errorf("err = %v, want ErrBusy", result.Error)
+	}
+	if result.Error == nil || !strings.Contains(result.Error.Error(), "journal write") {
+		t.Errorf("err = %v, want the journal failure too", result.Error)
+	}
+}
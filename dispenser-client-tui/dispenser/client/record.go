@@ -0,0 +1,10 @@
+package client
+
+import "token-tui/dispenser/cassette"
+
+// Record sends the client's requests through rec, which writes them and the
+// responses to a cassette for dispenser-replay. Call it after UseTLS, which
+// replaces the transport.
+func (c *DispenserClient) Record(rec *cassette.Recorder) {
+	c.HTTPClient.Transport = rec.Transport(c.HTTPClient.Transport)
+}
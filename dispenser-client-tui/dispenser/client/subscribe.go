@@ -0,0 +1,196 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types of a dispenser-relay stream
+const (
+	EventHealth   = "health"   // health changed; Health is nil while unreachable
+	EventDispense = "dispense" // a transaction started, dispensed a token or finished
+	EventError    = "error"    // a new error_history entry
+
+	// Generated by Subscribe, not sent by the relay
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+)
+
+// Event is one message of a dispenser-relay Server-Sent Events stream
+type Event struct {
+	ID       uint64            `json:"id"`
+	Type     string            `json:"type"`
+	Time     time.Time         `json:"time"`
+	Health   *HealthResponse   `json:"health,omitempty"`
+	Dispense *DispenseResponse `json:"dispense,omitempty"`
+	Error    *ErrorRecord      `json:"error,omitempty"`
+	Message  string            `json:"message,omitempty"`
+}
+
+// SubscribeOptions tunes Subscribe. The zero value streams everything.
+type SubscribeOptions struct {
+	// TxID restricts the stream to that transaction's dispense events and
+	// makes the relay follow it even if it finished between two polls. The
+	// channel is closed after its final state.
+	TxID string
+	// RetryInterval between reconnection attempts; default 1s
+	RetryInterval time.Duration
+}
+
+// Subscribe streams events from the dispenser-relay at relayURL, sending
+// the client's API key. The first connection is made before Subscribe
+// returns, so an error means no relay is available and the caller should
+// poll instead. Later disconnects are reported as EventDisconnected, and
+// the stream resumes where it left off after EventConnected. The channel
+// is closed when ctx ends.
+func (c *DispenserClient) Subscribe(ctx context.Context, relayURL string, opts SubscribeOptions) (<-chan Event, error) {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	u := strings.TrimRight(relayURL, "/") + "/events"
+	if opts.TxID != "" {
+		u += "?tx_id=" + url.QueryEscape(opts.TxID)
+	}
+
+	resp, err := c.openStream(ctx, u, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 16)
+	go func() {
+		defer close(events)
+		var lastID uint64
+		send := func(ev Event) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			done := false
+			err := readStream(resp, func(ev Event) bool {
+				lastID = ev.ID
+				if !send(ev) {
+					return false
+				}
+				done = opts.TxID != "" && ev.Dispense != nil && !ev.Dispense.InProgress()
+				return !done
+			})
+			resp.Body.Close()
+			if done || ctx.Err() != nil {
+				return
+			}
+			if !send(Event{Type: EventDisconnected, Time: c.clock().Now(), Message: fmt.Sprint(err)}) {
+				return
+			}
+			for {
+				if c.sleep(ctx, opts.RetryInterval) != nil {
+					return
+				}
+				if resp, err = c.openStream(ctx, u, lastID); err == nil {
+					break
+				}
+			}
+			if !send(Event{Type: EventConnected, Time: c.clock().Now()}) {
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// openStream connects to the event stream. The client's timeout bounds the
+// wait for the response headers only, not the stream.
+func (c *DispenserClient) openStream(ctx context.Context, u string, lastID uint64) (*http.Response, error) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, u, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	if lastID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	}
+
+	hc := *c.HTTPClient
+	hc.Timeout = 0
+	if c.HTTPClient.Timeout > 0 {
+		timer := time.AfterFunc(c.HTTPClient.Timeout, cancel)
+		defer timer.Stop()
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("relay: unexpected status %d", resp.StatusCode)
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request context with the body
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// readStream parses Server-Sent Events until the stream ends or handle
+// returns false. Comments (heartbeats) and fields other than data are
+// skipped; the payload is a JSON Event.
+func readStream(resp *http.Response, handle func(Event) bool) error {
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var data strings.Builder
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			var ev Event
+			err := json.Unmarshal([]byte(data.String()), &ev)
+			data.Reset()
+			if err != nil {
+				continue
+			}
+			if !handle(ev) {
+				return nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("relay closed the stream")
+}
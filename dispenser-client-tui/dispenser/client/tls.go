@@ -0,0 +1,45 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// UseTLS configures the client for an HTTPS endpoint such as
+// dispenser-proxy. caFile, if set, replaces the system roots to verify the
+// server; certFile and keyFile, if set, are the client certificate for
+// mutual TLS.
+func (c *DispenserClient) UseTLS(caFile, certFile, keyFile string) error {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if c.HTTPClient.Transport == nil || !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	} else {
+		transport = transport.Clone()
+	}
+	transport.TLSClientConfig = cfg
+	c.HTTPClient.Transport = transport
+	return nil
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	// MaxTxIDLen bounds tx_id length per the protocol spec; any ID of 1 to
+	// MaxTxIDLen characters is valid
+	MaxTxIDLen = 16
+	// MinTxIDLen is the shortest ID a TxIDGenerator produces. Generated IDs
+	// are MinTxIDLen-MaxTxIDLen lowercase hex characters.
+	MinTxIDLen = 8
+	// HistorySize is the firmware's idempotency ring buffer size
+	HistorySize = 8
+
+	minRandomHex = 4
+	sequenceHex  = 4
+)
+
+var (
+	ErrInvalidTxID = errors.New("invalid tx_id")
+	ErrTxIDReused  = errors.New("tx_id reused within history window")
+)
+
+// TxID is a protocol-compliant transaction ID of 1-16 characters
+type TxID string
+
+// ParseTxID validates an existing ID, e.g. one to retry or one from another
+// POS client, against the protocol and returns it unchanged. The firmware
+// matches IDs byte for byte, so a retry must send exactly the original.
+func ParseTxID(s string) (TxID, error) {
+	if len(s) < 1 || len(s) > MaxTxIDLen {
+		return "", fmt.Errorf("%w: %q must be 1-%d characters", ErrInvalidTxID, s, MaxTxIDLen)
+	}
+	return TxID(s), nil
+}
+
+func (id TxID) String() string {
+	return string(id)
+}
+
+// TxIDOptions configures a TxIDGenerator. The zero value produces 16 random
+// hex characters and guards against reuse within the firmware history.
+type TxIDOptions struct {
+	// Length of generated IDs (8-16); defaults to MaxTxIDLen
+	Length int
+	// Prefix is a hex terminal identifier placed at the start of every ID
+	Prefix string
+	// Sequence inserts a 4-hex-digit monotonic counter after the prefix
+	Sequence bool
+	// StartSequence is the first counter value when Sequence is set
+	StartSequence uint16
+	// Window is how many recent IDs may not be reused; defaults to HistorySize
+	Window int
+	// Rand is the entropy source; defaults to crypto/rand
+	Rand io.Reader
+}
+
+// TxIDGenerator produces transaction IDs and refuses to hand out or accept
+// an ID seen within the last Window transactions, so a new dispense can
+// never be mistaken for an idempotent retry of an older one.
+type TxIDGenerator struct {
+	mu      sync.Mutex
+	opts    TxIDOptions
+	seq     uint16
+	recent  []TxID
+	nextPos int
+}
+
+// NewTxIDGenerator validates opts and returns a generator
+func NewTxIDGenerator(opts TxIDOptions) (*TxIDGenerator, error) {
+	if opts.Length == 0 {
+		opts.Length = MaxTxIDLen
+	}
+	if opts.Length < MinTxIDLen || opts.Length > MaxTxIDLen {
+		return nil, fmt.Errorf("tx_id length %d out of range %d-%d", opts.Length, MinTxIDLen, MaxTxIDLen)
+	}
+	if opts.Prefix != "" && !isHex(opts.Prefix) {
+		return nil, fmt.Errorf("tx_id prefix %q is not hex", opts.Prefix)
+	}
+	opts.Prefix = strings.ToLower(opts.Prefix)
+
+	fixed := len(opts.Prefix)
+	if opts.Sequence {
+		fixed += sequenceHex
+	}
+	if opts.Length-fixed < minRandomHex {
+		return nil, fmt.Errorf("tx_id prefix and sequence leave fewer than %d random characters", minRandomHex)
+	}
+
+	if opts.Window <= 0 {
+		opts.Window = HistorySize
+	}
+	if opts.Rand == nil {
+		opts.Rand = rand.Reader
+	}
+
+	return &TxIDGenerator{
+		opts:   opts,
+		seq:    opts.StartSequence,
+		recent: make([]TxID, 0, opts.Window),
+	}, nil
+}
+
+// Next returns a fresh ID that has not been used in the current window
+func (g *TxIDGenerator) Next() (TxID, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	randomLen := g.opts.Length - len(g.opts.Prefix)
+	if g.opts.Sequence {
+		randomLen -= sequenceHex
+	}
+	buf := make([]byte, (randomLen+1)/2)
+
+	for attempt := 0; attempt < 16; attempt++ {
+		if _, err := io.ReadFull(g.opts.Rand, buf); err != nil {
+			return "", fmt.Errorf("generate tx_id: %w", err)
+		}
+
+		var sb strings.Builder
+		sb.WriteString(g.opts.Prefix)
+		if g.opts.Sequence {
+			fmt.Fprintf(&sb, "%04x", g.seq)
+		}
+		sb.WriteString(hex.EncodeToString(buf)[:randomLen])
+
+		id := TxID(sb.String())
+		if g.seenLocked(id) {
+			continue
+		}
+		if g.opts.Sequence {
+			g.seq++
+		}
+		g.rememberLocked(id)
+		return id, nil
+	}
+	return "", fmt.Errorf("generate tx_id: no unused ID after 16 attempts")
+}
+
+// Use registers an externally supplied ID, e.g. one given on the command
+// line or recovered from a journal. It fails with ErrTxIDReused if the ID is
+// still in the window.
+func (g *TxIDGenerator) Use(id TxID) error {
+	id, err := ParseTxID(string(id))
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.seenLocked(id) {
+		return fmt.Errorf("%w: %s", ErrTxIDReused, id)
+	}
+	g.rememberLocked(id)
+	return nil
+}
+
+// Seen reports whether id is within the current window
+func (g *TxIDGenerator) Seen(id TxID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.seenLocked(id)
+}
+
+func (g *TxIDGenerator) seenLocked(id TxID) bool {
+	for _, r := range g.recent {
+		if r == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *TxIDGenerator) rememberLocked(id TxID) {
+	if len(g.recent) < g.opts.Window {
+		g.recent = append(g.recent, id)
+		return
+	}
+	g.recent[g.nextPos] = id
+	g.nextPos = (g.nextPos + 1) % g.opts.Window
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
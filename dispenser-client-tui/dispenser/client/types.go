@@ -0,0 +1,140 @@
+package client
+
+import "time"
+
+// BootTolerance absorbs the jitter between wall clock and whole-second
+// uptime when comparing boot times
+const BootTolerance = time.Minute
+
+// HealthResponse matches GET /health from the dispenser protocol
+type HealthResponse struct {
+	Status       string        `json:"status"`
+	Uptime       int           `json:"uptime"`
+	Firmware     string        `json:"firmware"`
+	WiFi         *WiFiInfo     `json:"wifi,omitempty"`
+	Dispenser    string        `json:"dispenser"`
+	GPIO         *GPIOInfo     `json:"gpio,omitempty"`
+	Metrics      Metrics       `json:"metrics"`
+	ActiveTx     *ActiveTxInfo `json:"active_tx,omitempty"`
+	Error        *ErrorInfo    `json:"error,omitempty"`
+	ErrorHistory []ErrorRecord `json:"error_history,omitempty"`
+}
+
+// HopperLow reports whether the hopper low sensor is asserted
+func (h *HealthResponse) HopperLow() bool {
+	return h.GPIO != nil && h.GPIO.HopperLow.Active
+}
+
+// BootTime estimates when the dispenser booted from a response received at
+// seen
+func (h *HealthResponse) BootTime(seen time.Time) time.Time {
+	return seen.Add(-time.Duration(h.Uptime) * time.Second)
+}
+
+// Rebooted reports whether the dispenser rebooted between the health
+// responses prev and cur: its uptime went down. A nil prev is the first
+// response, not a reboot. A reboot between polls further apart than the new
+// uptime does not show here; BootMoved catches it from the boot times.
+func Rebooted(prev, cur *HealthResponse) bool {
+	return prev != nil && cur.Uptime < prev.Uptime
+}
+
+// BootMoved reports whether the boot time estimated from a later response
+// is past the earlier estimate by more than BootTolerance, i.e. the
+// dispenser booted again in between
+func BootMoved(prev, cur time.Time) bool {
+	return cur.Sub(prev) > BootTolerance
+}
+
+type Metrics struct {
+	TotalDispenses int    `json:"total_dispenses"`
+	Successful     int    `json:"successful"`
+	Jams           int    `json:"jams"`
+	Partial        int    `json:"partial"`
+	Failures       int    `json:"failures"`
+	LastError      string `json:"last_error"`
+	LastErrorType  string `json:"last_error_type"`
+}
+
+type ActiveTxInfo struct {
+	TxID      string `json:"tx_id"`
+	Quantity  int    `json:"quantity"`
+	Dispensed int    `json:"dispensed"`
+}
+
+type WiFiInfo struct {
+	RSSI int    `json:"rssi"`
+	IP   string `json:"ip"`
+	SSID string `json:"ssid"`
+}
+
+// PinState is one GPIO input as reported by /health
+type PinState struct {
+	Raw    int  `json:"raw"`
+	Active bool `json:"active"`
+}
+
+type GPIOInfo struct {
+	CoinPulse   PinState `json:"coin_pulse"`
+	ErrorSignal PinState `json:"error_signal"`
+	HopperLow   PinState `json:"hopper_low"`
+}
+
+type ErrorInfo struct {
+	Active      bool   `json:"active"`
+	Code        int    `json:"code,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Timestamp   int64  `json:"timestamp,omitempty"` // millis() since boot
+	Description string `json:"description,omitempty"`
+}
+
+type ErrorRecord struct {
+	Code      int    `json:"code"`
+	Type      string `json:"type"`
+	Timestamp int64  `json:"timestamp"` // millis() since boot
+	Cleared   bool   `json:"cleared"`
+}
+
+// DispenseRequest matches POST /dispense
+type DispenseRequest struct {
+	TxID     string `json:"tx_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// DispenseResponse matches dispense endpoint responses
+type DispenseResponse struct {
+	TxID      string `json:"tx_id"`
+	State     string `json:"state"`
+	Quantity  int    `json:"quantity"`
+	Dispensed int    `json:"dispensed"`
+	Error     string `json:"error,omitempty"`
+	// QueuePosition is set by dispenser-gateway for state "queued", 1 being
+	// next in line
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+// StateQueued is reported by dispenser-gateway for a transaction waiting
+// for the dispenser; the firmware itself never reports it
+const StateQueued = "queued"
+
+// StateUnknown is reported by dispenser-gateway for a transaction whose
+// final state fell out of the dispenser's history before it was seen. The
+// firmware answers 404 instead.
+const StateUnknown = "unknown"
+
+// StateRejected is reported by dispenser-gateway for a transaction the
+// dispenser refused, or that never left the queue. Nothing was dispensed
+// and the dispenser is not in error.
+const StateRejected = "rejected"
+
+// InProgress reports whether the transaction has not reached a final state
+func (r DispenseResponse) InProgress() bool {
+	return r.State == "dispensing" || r.State == StateQueued
+}
+
+// ErrorResponse for 4xx/5xx
+type ErrorResponse struct {
+	Error       string `json:"error"`
+	ActiveTxID  string `json:"active_tx_id,omitempty"`
+	ActiveState string `json:"active_state,omitempty"`
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultPollInterval is the status polling rate recommended by the protocol
+	DefaultPollInterval = 250 * time.Millisecond
+	// DefaultPostAttempts bounds POST /dispense retries on network errors
+	DefaultPostAttempts = 5
+)
+
+// ErrOutcomeUnknown is returned by DispenseAndWait when the transaction was
+// accepted but later fell out of the firmware's 8-entry history before a
+// final state was observed. The tokens may or may not have been dispensed.
+var ErrOutcomeUnknown = errors.New("transaction outcome unknown")
+
+// Outcome classifies a finished dispense transaction
+type Outcome string
+
+const (
+	OutcomeDone    Outcome = "done"    // all tokens dispensed
+	OutcomePartial Outcome = "partial" // error after some tokens dropped
+	OutcomeFailed  Outcome = "failed"  // error before any token dropped
+	OutcomeUnknown Outcome = "unknown" // final state was never observed
+)
+
+// WaitOptions tunes DispenseAndWait. The zero value uses the defaults.
+type WaitOptions struct {
+	// PollInterval between GET /dispense/{tx_id} calls and POST retries
+	PollInterval time.Duration
+	// PostAttempts is how often POST /dispense is tried on network errors
+	PostAttempts int
+	// OnProgress is called once for every token dispensed, in order, even
+	// when a poll sees several at once: a count going from 2 to 5 reports
+	// 3, 4 and 5. The calls before the last of a poll report the state as
+	// dispensing.
+	OnProgress func(DispenseResponse)
+}
+
+// DispenseResult is the final state of a transaction run by DispenseAndWait
+type DispenseResult struct {
+	TxID      string        `json:"tx_id"`
+	Quantity  int           `json:"quantity"`
+	Dispensed int           `json:"dispensed"`
+	State     string        `json:"state"`
+	Error     string        `json:"error,omitempty"`
+	Outcome   Outcome       `json:"outcome"`
+	Duration  time.Duration `json:"duration_ns"`
+}
+
+// DispenseAndWait runs a complete transaction: it POSTs /dispense, retrying
+// with the same tx_id on network errors (safe thanks to the firmware's
+// idempotency guarantee), then polls the status until the transaction is
+// done or in error. Transient poll failures are ignored until ctx expires.
+//
+// A jam or hardware error is reported through the result's Outcome, not as
+// an error. The returned error is non-nil when the dispenser rejected the
+// request (see the sentinel errors), ctx ended, or the outcome is unknown.
+func (c *DispenserClient) DispenseAndWait(ctx context.Context, txID string, quantity int, opts WaitOptions) (*DispenseResult, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.PostAttempts <= 0 {
+		opts.PostAttempts = DefaultPostAttempts
+	}
+
+	clk := c.clock()
+	start := clk.Now()
+	result := &DispenseResult{TxID: txID, Quantity: quantity, Outcome: OutcomeUnknown}
+
+	resp, err := c.postWithRetry(ctx, txID, quantity, opts)
+	if err != nil {
+		result.Duration = clk.Since(start)
+		return result, err
+	}
+
+	for {
+		if opts.OnProgress != nil {
+			reportProgress(*resp, result.Dispensed, opts.OnProgress)
+		}
+		result.Quantity = resp.Quantity
+		result.Dispensed = resp.Dispensed
+		result.State = resp.State
+		result.Error = resp.Error
+
+		if !resp.InProgress() {
+			result.Outcome = OutcomeOf(resp)
+			result.Duration = clk.Since(start)
+			if result.Outcome == OutcomeUnknown {
+				return result, ErrOutcomeUnknown
+			}
+			return result, nil
+		}
+
+		resp, err = c.pollUntilSeen(ctx, txID, opts)
+		if err != nil {
+			result.Duration = clk.Since(start)
+			if errors.Is(err, ErrNotFound) {
+				if c.Journal != nil {
+					c.Journal.MarkUnknown(txID, "fell out of dispenser history while polling")
+				}
+				return result, ErrOutcomeUnknown
+			}
+			return result, err
+		}
+	}
+}
+
+// postWithRetry sends POST /dispense until the dispenser answers. Only
+// transport errors are retried; any HTTP error status is final.
+func (c *DispenserClient) postWithRetry(ctx context.Context, txID string, quantity int, opts WaitOptions) (*DispenseResponse, error) {
+	var lastErr error
+	for attempt := 0; attempt < opts.PostAttempts; attempt++ {
+		if attempt > 0 {
+			if err := c.sleep(ctx, opts.PollInterval); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, result := c.Dispense(ctx, txID, quantity)
+		if result.Error == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var apiErr *APIError
+		if errors.As(result.Error, &apiErr) {
+			return nil, result.Error
+		}
+		lastErr = result.Error
+	}
+	return nil, lastErr
+}
+
+// pollUntilSeen waits one poll interval and fetches the status, retrying
+// through transport errors. A 404 is returned as is so the caller can flag
+// the outcome as unknown.
+func (c *DispenserClient) pollUntilSeen(ctx context.Context, txID string, opts WaitOptions) (*DispenseResponse, error) {
+	for {
+		if err := c.sleep(ctx, opts.PollInterval); err != nil {
+			return nil, err
+		}
+
+		resp, result := c.Status(ctx, txID)
+		if result.Error == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var apiErr *APIError
+		if errors.As(result.Error, &apiErr) {
+			return nil, result.Error
+		}
+	}
+}
+
+// reportProgress calls onProgress for every count after prev up to the one
+// of resp
+func reportProgress(resp DispenseResponse, prev int, onProgress func(DispenseResponse)) {
+	for n := prev + 1; n < resp.Dispensed; n++ {
+		step := resp
+		step.State = "dispensing"
+		step.Error = ""
+		step.Dispensed = n
+		onProgress(step)
+	}
+	if resp.Dispensed > prev {
+		onProgress(resp)
+	}
+}
+
+// OutcomeOf classifies the final state of a transaction
+func OutcomeOf(resp *DispenseResponse) Outcome {
+	switch {
+	case resp.State == "done":
+		return OutcomeDone
+	case resp.State == StateUnknown:
+		return OutcomeUnknown
+	case resp.Dispensed > 0:
+		return OutcomePartial
+	default:
+		return OutcomeFailed
+	}
+}
+
+// sleep waits d on the client's clock unless ctx ends first
+func (c *DispenserClient) sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.clock().After(d):
+		return nil
+	}
+}
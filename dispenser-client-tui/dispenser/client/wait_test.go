@@ -0,0 +1,193 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/sim"
+)
+
+// newSim starts a simulator with the firmware timing on a fake clock
+func newSim(t *testing.T) (*sim.Server, *client.DispenserClient, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := sim.DefaultConfig()
+	cfg.Clock = clk
+	srv := sim.NewServer(cfg)
+	t.Cleanup(srv.Close)
+	return srv, srv.DispenserClient(), clk
+}
+
+// settle waits in real time until the simulator's motor goroutine has
+// caught up with the fake clock and txID left the dispensing state
+func settle(t *testing.T, c *client.DispenserClient, txID string) *client.DispenseResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, result := c.Status(context.Background(), txID)
+		if result.Error != nil {
+			t.Fatalf("status %s: %v", txID, result.Error)
+		}
+		if !resp.InProgress() || time.Now().After(deadline) {
+			return resp
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDispenseAndWaitCompletes(t *testing.T) {
+	_, c, clk := newSim(t)
+	const quantity = 20
+
+	var progress []int
+	done := make(chan struct{})
+	var (
+		res *client.DispenseResult
+		err error
+	)
+	go func() {
+		defer close(done)
+		res, err = c.DispenseAndWait(context.Background(), "a1b2c3d4", quantity, client.WaitOptions{
+			OnProgress: func(r client.DispenseResponse) { progress = append(progress, r.Dispensed) },
+		})
+	}()
+
+	// Drive virtual time until the transaction finishes: 20 tokens take 50s
+	// on the hardware
+	timeout := time.After(10 * time.Second)
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-timeout:
+			t.Fatal("DispenseAndWait did not return")
+		default:
+			clk.Advance(client.DefaultPollInterval)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+
+	if err != nil {
+		t.Fatalf("DispenseAndWait: %v", err)
+	}
+	if res.Outcome != client.OutcomeDone || res.State != "done" || res.Dispensed != quantity {
+		t.Errorf("result = %+v, want done with %d dispensed", res, quantity)
+	}
+	if min := quantity * sim.DefaultConfig().TokenInterval; res.Duration < min {
+		t.Errorf("duration = %v, want at least %v of virtual time", res.Duration, min)
+	}
+	if len(progress) != quantity {
+		t.Fatalf("progress = %v, want one call per token", progress)
+	}
+	for i, n := range progress {
+		if n != i+1 {
+			t.Fatalf("progress = %v, want 1 to %d", progress, quantity)
+		}
+	}
+}
+
+func TestJamDetectedAfterFiveSeconds(t *testing.T) {
+	srv, c, clk := newSim(t)
+	cfg := sim.DefaultConfig()
+	ctx := context.Background()
+	const txID = "deadbeef"
+
+	if err := srv.Sim.Inject(sim.Fault{Action: sim.ActionJam, AfterTokens: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, result := c.Dispense(ctx, txID, 3); result.Error != nil {
+		t.Fatalf("dispense: %v", result.Error)
+	}
+
+	// First coin drops, then the motor stalls
+	clk.BlockUntil(1)
+	clk.Advance(cfg.TokenInterval)
+	clk.BlockUntil(1)
+
+	clk.Advance(cfg.JamTimeout - time.Millisecond)
+	clk.BlockUntil(1)
+	resp, result := c.Status(ctx, txID)
+	if result.Error != nil {
+		t.Fatalf("status: %v", result.Error)
+	}
+	if resp.State != "dispensing" || resp.Dispensed != 1 {
+		t.Fatalf("just before the jam timeout: %+v, want dispensing with 1 dispensed", resp)
+	}
+
+	clk.Advance(time.Millisecond)
+	resp = settle(t, c, txID)
+	if resp.State != "error" || resp.Dispensed != 1 {
+		t.Fatalf("at the jam timeout: %+v, want error with 1 dispensed", resp)
+	}
+
+	// Re-running the transaction reports the jam without waiting
+	res, err := c.DispenseAndWait(ctx, txID, 3, client.WaitOptions{})
+	if err != nil {
+		t.Fatalf("DispenseAndWait: %v", err)
+	}
+	if res.Outcome != client.OutcomePartial || res.Dispensed != 1 {
+		t.Errorf("result = %+v, want partial with 1 dispensed", res)
+	}
+}
+
+func TestDispenseAndWaitGatewayUnknown(t *testing.T) {
+	// dispenser-gateway reports a transaction it lost track of as unknown
+	// instead of answering 404
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(client.DispenseResponse{
+			TxID: "a1b2c3d4", State: client.StateUnknown, Quantity: 3, Dispensed: 1,
+			Error: "outcome unknown, fell out of dispenser history",
+		})
+	}))
+	defer srv.Close()
+
+	c := client.NewDispenserClient(srv.URL, "key", time.Second)
+	res, err := c.DispenseAndWait(context.Background(), "a1b2c3d4", 3, client.WaitOptions{})
+	if !errors.Is(err, client.ErrOutcomeUnknown) {
+		t.Fatalf("err = %v, want ErrOutcomeUnknown", err)
+	}
+	if res.Outcome != client.OutcomeUnknown || res.Dispensed != 1 {
+		t.Errorf("result = %+v, want unknown with 1 dispensed", res)
+	}
+}
+
+func TestDispenseAndWaitProgressPerToken(t *testing.T) {
+	// The first poll only comes back after several tokens dropped
+	states := []client.DispenseResponse{
+		{TxID: "a1b2c3d4", State: "dispensing", Quantity: 5, Dispensed: 2},
+		{TxID: "a1b2c3d4", State: "done", Quantity: 5, Dispensed: 5},
+	}
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states[min(calls, len(states)-1)])
+		calls++
+	}))
+	defer srv.Close()
+
+	var progress []string
+	c := client.NewDispenserClient(srv.URL, "key", time.Second)
+	_, err := c.DispenseAndWait(context.Background(), "a1b2c3d4", 5, client.WaitOptions{
+		PollInterval: time.Millisecond,
+		OnProgress: func(r client.DispenseResponse) {
+			progress = append(progress, fmt.Sprintf("%s %d", r.State, r.Dispensed))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "dispensing 1, dispensing 2, dispensing 3, dispensing 4, done 5"
+	if got := strings.Join(progress, ", "); got != want {
+		t.Errorf("progress = %s, want %s", got, want)
+	}
+}
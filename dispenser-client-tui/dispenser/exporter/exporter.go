@@ -0,0 +1,259 @@
+// Package exporter exposes dispenser health as Prometheus metrics. An
+// Exporter polls GET /health in the background and serves the latest
+// state in the text exposition format, written by hand to keep the module
+// free of the Prometheus client library.
+//
+// The firmware's dispense counters restart at zero on every boot. The
+// exporter turns them into monotonic counters by adding the increase
+// between polls, and the full value after a reboot (uptime went down, or
+// the boot time estimated from it moved later), so rate() and increase()
+// stay correct across power cycles.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+// states and pins are always exported, so absent series read as 0
+var (
+	states = []string{"idle", "dispensing", "error"}
+	pins   = []string{"coin_pulse", "error_signal", "hopper_low"}
+)
+
+// counterNames maps the firmware metrics to exported counter names
+var counterNames = []struct {
+	name, help string
+	value      func(client.Metrics) int
+}{
+	{"dispenser_dispenses_total", "Dispense transactions started.", func(m client.Metrics) int { return m.TotalDispenses }},
+	{"dispenser_dispenses_successful_total", "Dispense transactions completed.", func(m client.Metrics) int { return m.Successful }},
+	{"dispenser_jams_total", "Jams detected by the firmware watchdog.", func(m client.Metrics) int { return m.Jams }},
+	{"dispenser_dispenses_partial_total", "Dispenses that stopped after some tokens.", func(m client.Metrics) int { return m.Partial }},
+	{"dispenser_failures_total", "Failed dispenses, jams and other errors.", func(m client.Metrics) int { return m.Failures }},
+}
+
+type errorKey struct {
+	code      int
+	timestamp int64
+}
+
+// Exporter polls one dispenser and serves its metrics. It is an
+// http.Handler for /metrics.
+type Exporter struct {
+	Client   *client.DispenserClient
+	Interval time.Duration
+	// Requests, if set, is the instrumented transport of Client
+	Requests *Transport
+	// Clock paces polls; nil means clock.Real
+	Clock clock.Clock
+
+	mu         sync.Mutex
+	health     *client.HealthResponse // last successful poll
+	boot       time.Time              // boot time estimated from health
+	up         bool
+	lastPoll   time.Time
+	polls      uint64
+	pollErrors uint64
+	reboots    uint64
+	prev       *client.Metrics
+	counters   []float64 // monotonic, in counterNames order
+	seenErrors map[errorKey]bool
+	errors     map[[2]string]uint64 // code, type -> new error_history entries
+}
+
+// New returns an exporter for c that records c's requests
+func New(c *client.DispenserClient, interval time.Duration) *Exporter {
+	return &Exporter{Client: c, Interval: interval, Requests: Instrument(c.HTTPClient)}
+}
+
+func (e *Exporter) clock() clock.Clock {
+	if e.Clock == nil {
+		return clock.Real
+	}
+	return e.Clock
+}
+
+// Run polls every Interval until ctx ends, starting immediately
+func (e *Exporter) Run(ctx context.Context) error {
+	for {
+		e.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.clock().After(e.Interval):
+		}
+	}
+}
+
+// Poll fetches /health once and updates the metrics
+func (e *Exporter) Poll(ctx context.Context) error {
+	h, result := e.Client.Health(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.counters == nil {
+		e.counters = make([]float64, len(counterNames))
+		e.seenErrors = make(map[errorKey]bool)
+		e.errors = make(map[[2]string]uint64)
+	}
+	e.polls++
+	e.lastPoll = e.clock().Now()
+	if result.Error != nil {
+		e.pollErrors++
+		e.up = false
+		return result.Error
+	}
+	e.up = true
+	boot := h.BootTime(e.lastPoll)
+	rebooted := client.Rebooted(e.health, h) || e.health != nil && client.BootMoved(e.boot, boot)
+	e.health, e.boot = h, boot
+
+	if rebooted {
+		e.reboots++
+		// Counters and error timestamps restart with uptime
+		e.prev = nil
+		e.seenErrors = make(map[errorKey]bool)
+	}
+
+	for i, c := range counterNames {
+		cur := c.value(h.Metrics)
+		if e.prev == nil {
+			// First poll or first after a reboot: everything counted since
+			// boot is new to the exporter
+			e.counters[i] += float64(cur)
+		} else if d := cur - c.value(*e.prev); d > 0 {
+			e.counters[i] += float64(d)
+		}
+	}
+	m := h.Metrics
+	e.prev = &m
+
+	for _, rec := range h.ErrorHistory {
+		key := errorKey{rec.Code, rec.Timestamp}
+		if e.seenErrors[key] {
+			continue
+		}
+		e.seenErrors[key] = true
+		e.errors[[2]string{fmt.Sprint(rec.Code), rec.Type}]++
+	}
+	return nil
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteMetrics(w)
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	var families []*family
+	add := func(name, help, typ string) *family {
+		f := &family{name: name, help: help, typ: typ}
+		families = append(families, f)
+		return f
+	}
+
+	e.mu.Lock()
+	up := add("dispenser_up", "Whether the last /health poll succeeded.", "gauge")
+	up.add(boolValue(e.up))
+	add("dispenser_polls_total", "Health polls by the exporter.", "counter").add(float64(e.polls))
+	add("dispenser_poll_errors_total", "Health polls that failed.", "counter").add(float64(e.pollErrors))
+	if !e.lastPoll.IsZero() {
+		add("dispenser_last_poll_timestamp_seconds", "Time of the last health poll.", "gauge").
+			add(float64(e.lastPoll.UnixMilli()) / 1000)
+	}
+	add("dispenser_reboots_total", "Reboots detected from a decreasing uptime or a later boot time.", "counter").add(float64(e.reboots))
+
+	if h := e.health; h != nil {
+		add("dispenser_info", "Firmware of the dispenser.", "gauge").add(1, label{"firmware", h.Firmware})
+		add("dispenser_uptime_seconds", "Dispenser uptime.", "gauge").add(float64(h.Uptime))
+		if h.WiFi != nil {
+			add("dispenser_wifi_rssi_dbm", "WiFi signal strength.", "gauge").add(float64(h.WiFi.RSSI))
+		}
+
+		state := add("dispenser_state", "Dispenser state, 1 for the current one.", "gauge")
+		for _, s := range states {
+			state.add(boolValue(h.Dispenser == s), label{"state", s})
+		}
+		if h.GPIO != nil {
+			active := add("dispenser_gpio_active", "Interpreted GPIO input state.", "gauge")
+			raw := add("dispenser_gpio_raw", "Raw GPIO pin level.", "gauge")
+			for i, p := range []client.PinState{h.GPIO.CoinPulse, h.GPIO.ErrorSignal, h.GPIO.HopperLow} {
+				active.add(boolValue(p.Active), label{"pin", pins[i]})
+				raw.add(float64(p.Raw), label{"pin", pins[i]})
+			}
+		}
+
+		code := 0.0
+		if h.Error != nil && h.Error.Active {
+			code = float64(h.Error.Code)
+		}
+		add("dispenser_error_active", "Whether a hardware error is active.", "gauge").add(boolValue(code > 0))
+		add("dispenser_error_code", "Active Azkoyen error code, 0 if none.", "gauge").add(code)
+
+		hist := add("dispenser_error_history", "Entries of the 5-entry error history by type.", "gauge")
+		byType := make(map[string]int)
+		for _, rec := range h.ErrorHistory {
+			byType[rec.Type]++
+		}
+		for _, t := range sortedKeys(byType) {
+			hist.add(float64(byType[t]), label{"type", t})
+		}
+	}
+
+	for i, c := range counterNames {
+		if e.prev != nil {
+			add(c.name, c.help+" Continues across reboots.", "counter").add(e.counters[i])
+		}
+	}
+	errs := add("dispenser_errors_total", "Hardware errors seen in the error history.", "counter")
+	keys := make([][2]string, 0, len(e.errors))
+	for k := range e.errors {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a][0] < keys[b][0] })
+	for _, k := range keys {
+		errs.add(float64(e.errors[k]), label{"code", k[0]}, label{"type", k[1]})
+	}
+	e.mu.Unlock()
+
+	if e.Requests != nil {
+		requests := add("dispenser_client_requests_total", "Requests to the dispenser by endpoint and status code (\"error\" for transport failures).", "counter")
+		failures := add("dispenser_client_request_failures_total", "Requests that failed in transport or with a 5xx status.", "counter")
+		duration := add("dispenser_client_request_duration_seconds", "Request latency to the response headers.", "histogram")
+		e.Requests.collect(requests, failures, duration)
+	}
+
+	for _, f := range families {
+		if err := f.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
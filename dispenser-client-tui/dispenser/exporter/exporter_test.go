@@ -0,0 +1,219 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/sim"
+)
+
+// newTestExporter returns an exporter for a simulator on a fake clock
+func newTestExporter(t *testing.T) (*Exporter, *sim.Server, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := sim.DefaultConfig()
+	cfg.Clock = clk
+	srv := sim.NewServer(cfg)
+	t.Cleanup(srv.Close)
+	e := New(srv.DispenserClient(), 15*time.Second)
+	e.Clock = clk
+	e.Requests.Clock = clk
+	return e, srv, clk
+}
+
+// dispense runs a dispense of quantity to its end
+func dispense(t *testing.T, srv *sim.Server, clk *clock.Fake, txID string, quantity int) {
+	t.Helper()
+	c := srv.DispenserClient()
+	if _, result := c.Dispense(context.Background(), txID, quantity); result.Error != nil {
+		t.Fatalf("dispense %s: %v", txID, result.Error)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, result := c.Status(context.Background(), txID)
+		if result.Error != nil {
+			t.Fatalf("status %s: %v", txID, result.Error)
+		}
+		if !resp.InProgress() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s still %s", txID, resp.State)
+		}
+		clk.Advance(100 * time.Millisecond)
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+// scrape polls e and returns its metrics
+func scrape(t *testing.T, e *Exporter) string {
+	t.Helper()
+	e.Poll(context.Background())
+	var b bytes.Buffer
+	if err := e.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// value returns the value of series in out, -1 if it is missing
+func value(t *testing.T, out, series string) float64 {
+	t.Helper()
+	for _, line := range strings.Split(out, "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			return f
+		}
+	}
+	return -1
+}
+
+// expect fails unless every series in want has its value in out
+func expect(t *testing.T, when, out string, want map[string]float64) {
+	t.Helper()
+	for series, v := range want {
+		if got := value(t, out, series); got != v {
+			t.Errorf("%s: %s = %g, want %g", when, series, got, v)
+		}
+	}
+}
+
+func TestMetricsText(t *testing.T) {
+	e, _, _ := newTestExporter(t)
+	out := scrape(t, e)
+
+	// Every sample follows the HELP and TYPE of its family
+	var family string
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if name, ok := strings.CutPrefix(line, "# HELP "); ok {
+			family, _, _ = strings.Cut(name, " ")
+			continue
+		}
+		if typ, ok := strings.CutPrefix(line, "# TYPE "+family+" "); ok {
+			if typ != "gauge" && typ != "counter" && typ != "histogram" {
+				t.Errorf("%s: unknown type", line)
+			}
+			continue
+		}
+		if family == "" || !strings.HasPrefix(line, family) {
+			t.Errorf("%q outside its family (after %s)", line, family)
+		}
+	}
+
+	for _, want := range []string{
+		"# HELP dispenser_up Whether the last /health poll succeeded.\n# TYPE dispenser_up gauge\ndispenser_up 1\n",
+		"# TYPE dispenser_dispenses_total counter\n",
+		`dispenser_info{firmware="`,
+		`dispenser_state{state="idle"} 1` + "\n",
+		`dispenser_state{state="dispensing"} 0` + "\n",
+		`dispenser_gpio_raw{pin="coin_pulse"} `,
+		`dispenser_client_requests_total{method="GET",endpoint="/health",code="200"} 1` + "\n",
+		`dispenser_client_request_duration_seconds_bucket{method="GET",endpoint="/health",le="0.005"} 1` + "\n",
+		`dispenser_client_request_duration_seconds_bucket{method="GET",endpoint="/health",le="+Inf"} 1` + "\n",
+		`dispenser_client_request_duration_seconds_count{method="GET",endpoint="/health"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+	expect(t, "first poll", out, map[string]float64{
+		"dispenser_polls_total":                 1,
+		"dispenser_poll_errors_total":           0,
+		"dispenser_reboots_total":               0,
+		"dispenser_error_code":                  0,
+		"dispenser_dispenses_total":             0,
+		"dispenser_uptime_seconds":              0,
+		"dispenser_last_poll_timestamp_seconds": float64(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC).Unix()),
+	})
+}
+
+func TestLabelEscaping(t *testing.T) {
+	f := &family{name: "x", help: "a\\b\nc", typ: "gauge"}
+	f.add(0.5, label{"v", "say \"hi\"\\\n"})
+	var b strings.Builder
+	f.write(&b)
+	want := "# HELP x a\\\\b\\nc\n# TYPE x gauge\nx{v=\"say \\\"hi\\\"\\\\\\n\"} 0.5\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestPollError(t *testing.T) {
+	e, srv, _ := newTestExporter(t)
+	scrape(t, e)
+	srv.Close()
+
+	expect(t, "dispenser down", scrape(t, e), map[string]float64{
+		"dispenser_up":                0,
+		"dispenser_polls_total":       2,
+		"dispenser_poll_errors_total": 1,
+		`dispenser_client_requests_total{method="GET",endpoint="/health",code="error"}`:    1,
+		`dispenser_client_request_failures_total{method="GET",endpoint="/health"}`:         1,
+		`dispenser_client_request_duration_seconds_count{method="GET",endpoint="/health"}`: 2,
+	})
+}
+
+func TestCountersContinueAcrossReboot(t *testing.T) {
+	e, srv, clk := newTestExporter(t)
+	scrape(t, e)
+
+	dispense(t, srv, clk, "00000001", 2)
+	if err := srv.Sim.Inject(sim.Fault{Action: sim.ActionError, Code: 1}); err != nil {
+		t.Fatal(err)
+	}
+	out := scrape(t, e)
+	expect(t, "after a dispense", out, map[string]float64{
+		"dispenser_dispenses_total":                          1,
+		"dispenser_dispenses_successful_total":               1,
+		"dispenser_error_active":                             1,
+		"dispenser_error_code":                               1,
+		`dispenser_errors_total{code="1",type="COIN_STUCK"}`: 1,
+	})
+	// The error history is not counted again on the next poll
+	if got := value(t, scrape(t, e), `dispenser_errors_total{code="1",type="COIN_STUCK"}`); got != 1 {
+		t.Errorf("errors_total = %g after polling again, want 1", got)
+	}
+
+	// The firmware counters restart at zero, the exported ones go on
+	srv.Sim.Reboot()
+	dispense(t, srv, clk, "00000002", 1)
+	expect(t, "after a reboot", scrape(t, e), map[string]float64{
+		"dispenser_reboots_total":                            1,
+		"dispenser_dispenses_total":                          2,
+		"dispenser_dispenses_successful_total":               2,
+		"dispenser_error_active":                             0,
+		`dispenser_errors_total{code="1",type="COIN_STUCK"}`: 1,
+	})
+}
+
+func TestRebootWithHigherUptime(t *testing.T) {
+	e, srv, clk := newTestExporter(t)
+	clk.Advance(10 * time.Minute)
+	dispense(t, srv, clk, "00000001", 1)
+	scrape(t, e)
+
+	// Rebooted and dispensed again while nobody polled for longer than the
+	// last uptime: the uptime went up, the boot time moved
+	clk.Advance(time.Minute)
+	srv.Sim.Reboot()
+	clk.Advance(20 * time.Minute)
+	dispense(t, srv, clk, "00000002", 1)
+	expect(t, "after the reboot", scrape(t, e), map[string]float64{
+		"dispenser_reboots_total":   1,
+		"dispenser_dispenses_total": 2,
+	})
+
+	// Polls after that are no reboot
+	clk.Advance(time.Minute)
+	if got := value(t, scrape(t, e), "dispenser_reboots_total"); got != 1 {
+		t.Errorf("reboots_total = %g, want 1", got)
+	}
+}
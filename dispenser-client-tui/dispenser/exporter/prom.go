@@ -0,0 +1,119 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// family is one metric in the Prometheus text exposition format
+type family struct {
+	name    string
+	help    string
+	typ     string // gauge, counter or histogram
+	samples []sample
+}
+
+type sample struct {
+	suffix string // _bucket, _sum, _count for histograms
+	labels []label
+	value  float64
+}
+
+type label struct {
+	name, value string
+}
+
+func (f *family) add(value float64, labels ...label) {
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+func (f *family) write(w io.Writer) error {
+	if len(f.samples) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range f.samples {
+		b.WriteString(f.name)
+		b.WriteString(s.suffix)
+		if len(s.labels) > 0 {
+			b.WriteByte('{')
+			for i, l := range s.labels {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(&b, "%s=\"%s\"", l.name, escapeLabel(l.value))
+			}
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(formatValue(s.value))
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// histogram is a cumulative latency histogram in seconds
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// latencyBuckets suit a LAN device answering in milliseconds, with room
+// for the slow responses of a weak WiFi link
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	if i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) addTo(f *family, labels ...label) {
+	var cum uint64
+	for i, le := range latencyBuckets {
+		if h.counts != nil {
+			cum += h.counts[i]
+		}
+		f.samples = append(f.samples, sample{suffix: "_bucket",
+			labels: append(append([]label{}, labels...), label{"le", formatValue(le)}), value: float64(cum)})
+	}
+	f.samples = append(f.samples,
+		sample{suffix: "_bucket", labels: append(append([]label{}, labels...), label{"le", "+Inf"}), value: float64(h.count)},
+		sample{suffix: "_sum", labels: labels, value: h.sum},
+		sample{suffix: "_count", labels: labels, value: float64(h.count)},
+	)
+}
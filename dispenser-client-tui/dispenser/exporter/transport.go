@@ -0,0 +1,103 @@
+package exporter
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"token-tui/dispenser/clock"
+)
+
+// Transport is an http.RoundTripper that records latency and outcome of
+// every dispenser request per endpoint. Install it on a DispenserClient with
+// Instrument; the Exporter serves what it recorded.
+type Transport struct {
+	// Base performs the requests; nil means http.DefaultTransport
+	Base http.RoundTripper
+	// Clock measures latency; nil means clock.Real
+	Clock clock.Clock
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointStats
+}
+
+type endpointStats struct {
+	codes   map[string]uint64 // HTTP status or "error" for transport failures
+	latency histogram
+}
+
+// endpoint collapses request paths into the protocol's endpoints so that
+// tx_ids do not become label values
+func endpoint(method, path string) string {
+	switch {
+	case strings.HasPrefix(path, "/dispense/"):
+		return method + " /dispense/{tx_id}"
+	default:
+		return method + " " + path
+	}
+}
+
+// RoundTrip implements http.RoundTripper. Latency is measured to the
+// response headers.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	clk := t.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	start := clk.Now()
+	resp, err := base.RoundTrip(req)
+	elapsed := clk.Since(start)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.endpoints == nil {
+		t.endpoints = make(map[string]*endpointStats)
+	}
+	ep := endpoint(req.Method, req.URL.Path)
+	st := t.endpoints[ep]
+	if st == nil {
+		st = &endpointStats{codes: make(map[string]uint64)}
+		t.endpoints[ep] = st
+	}
+	st.codes[code]++
+	st.latency.observe(elapsed.Seconds())
+	return resp, err
+}
+
+// Instrument wraps the transport of hc, typically a DispenserClient's
+// HTTPClient, and returns the Transport recording its requests
+func Instrument(hc *http.Client) *Transport {
+	t := &Transport{Base: hc.Transport}
+	hc.Transport = t
+	return t
+}
+
+// collect adds the request families
+func (t *Transport) collect(requests, failures, duration *family) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ep := range sortedKeys(t.endpoints) {
+		st := t.endpoints[ep]
+		method, path, _ := strings.Cut(ep, " ")
+		var failed uint64
+		for _, code := range sortedKeys(st.codes) {
+			n := st.codes[code]
+			requests.add(float64(n), label{"method", method}, label{"endpoint", path}, label{"code", code})
+			if code == "error" || strings.HasPrefix(code, "5") {
+				failed += n
+			}
+		}
+		failures.add(float64(failed), label{"method", method}, label{"endpoint", path})
+		st.latency.addTo(duration, label{"method", method}, label{"endpoint", path})
+	}
+}
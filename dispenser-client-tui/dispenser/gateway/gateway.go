@@ -0,0 +1,448 @@
+// Package gateway serializes dispense requests in front of a dispenser.
+// The firmware runs one transaction at a time and answers 409 busy to
+// everyone else, leaving the retrying to each client. A Gateway accepts the
+// same POST /dispense and GET /dispense/{tx_id} API, queues requests FIFO
+// and forwards them one by one, so concurrent clients only wait. It keeps
+// its own transaction history, much longer than the firmware's 8 entries,
+// and rejects requests with 409 only while the hardware is in error.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/logging"
+)
+
+const (
+	// DefaultMaxQueue bounds the transactions waiting for the dispenser
+	DefaultMaxQueue = 32
+	// DefaultHistory is the number of finished transactions kept
+	DefaultHistory = 10000
+	// retryInterval paces retries while the dispenser is unreachable or
+	// busy with a transaction not started through the gateway
+	retryInterval = 2 * time.Second
+)
+
+// Errors returned by Submit
+var (
+	ErrQueueFull = errors.New("queue full")
+	ErrFault     = errors.New("dispenser in error state")
+	ErrClosed    = errors.New("gateway stopped")
+)
+
+// FaultError is ErrFault with the transaction the dispenser failed on
+type FaultError struct {
+	TxID string
+}
+
+func (e *FaultError) Error() string {
+	if e.TxID == "" {
+		return "dispenser fault: in error state"
+	}
+	return "dispenser fault: tx " + e.TxID + " in error state"
+}
+
+// Is matches ErrFault
+func (e *FaultError) Is(target error) bool { return target == ErrFault }
+
+// Tx is a transaction known to the gateway
+type Tx struct {
+	client.DispenseResponse
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// QueueStatus is the answer of GET /queue
+type QueueStatus struct {
+	Active  *Tx    `json:"active,omitempty"`
+	Queue   []Tx   `json:"queue"`
+	Faulted bool   `json:"faulted,omitempty"` // dispenser in error
+	Fault   string `json:"fault,omitempty"`   // tx_id it failed on, if known
+}
+
+// Gateway queues dispense requests for one dispenser. Run must be running
+// for queued transactions to be forwarded.
+type Gateway struct {
+	// Client talks to the dispenser. A journal attached to it makes the
+	// gateway's history survive restarts.
+	Client *client.DispenserClient
+	// MaxQueue and History default to DefaultMaxQueue and DefaultHistory
+	MaxQueue int
+	History  int
+	// PollInterval of the forwarded transaction; 0 means the client default
+	PollInterval time.Duration
+	// Logger reports forwarded transactions; nil discards
+	Logger *slog.Logger
+	// Clock stamps transactions; nil means clock.Real
+	Clock clock.Clock
+
+	mu       sync.Mutex
+	txs      map[string]*Tx
+	finished []string // tx_ids in order of completion, for eviction
+	queue    []*Tx
+	active   *Tx
+	faulted  bool
+	fault    string // tx_id the dispenser failed on, if known
+	closed   bool
+	wake     chan struct{}
+}
+
+// New returns a gateway for c. Finished transactions in c's journal seed
+// the history.
+func New(c *client.DispenserClient) *Gateway {
+	g := &Gateway{Client: c}
+	g.init()
+	g.seed()
+	return g
+}
+
+// seed adds the finished journal transactions the gateway does not know yet
+// to the history
+func (g *Gateway) seed() {
+	if g.Client.Journal == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, rec := range g.Client.Journal.Records() {
+		if _, ok := g.txs[rec.TxID]; ok || !rec.Finished() {
+			continue
+		}
+		g.remember(&Tx{
+			DispenseResponse: client.DispenseResponse{
+				TxID: rec.TxID, State: journalState(rec), Quantity: rec.Quantity,
+				Dispensed: rec.Dispensed, Error: rec.Error,
+			},
+			Queued:   rec.Time,
+			Finished: &rec.Time,
+		})
+	}
+}
+
+// journalState maps the journal's bookkeeping states to protocol states
+func journalState(rec client.JournalRecord) string {
+	switch rec.State {
+	case "done":
+		return "done"
+	case client.JournalRejected:
+		return client.StateRejected
+	case client.JournalUnknown:
+		return client.StateUnknown
+	}
+	return "error"
+}
+
+func (g *Gateway) init() {
+	if g.txs == nil {
+		g.txs = make(map[string]*Tx)
+		g.wake = make(chan struct{}, 1)
+	}
+}
+
+func (g *Gateway) clock() clock.Clock {
+	if g.Clock == nil {
+		return clock.Real
+	}
+	return g.Clock
+}
+
+func (g *Gateway) logger() *slog.Logger {
+	return logging.OrDiscard(g.Logger)
+}
+
+// Submit queues a transaction. A tx_id the gateway already knows returns
+// its current state, like the firmware's idempotent POST. New transactions
+// are refused with ErrFault while the dispenser is in error and with
+// ErrQueueFull when MaxQueue transactions wait.
+func (g *Gateway) Submit(txID string, quantity int) (Tx, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+
+	if tx, ok := g.txs[txID]; ok {
+		return g.viewLocked(tx), nil
+	}
+	switch {
+	case g.closed:
+		return Tx{}, ErrClosed
+	case g.faulted:
+		return Tx{}, &FaultError{TxID: g.fault}
+	case len(g.queue) >= g.maxQueue():
+		return Tx{}, ErrQueueFull
+	}
+
+	tx := &Tx{
+		DispenseResponse: client.DispenseResponse{TxID: txID, State: client.StateQueued, Quantity: quantity},
+		Queued:           g.clock().Now(),
+	}
+	g.txs[txID] = tx
+	g.queue = append(g.queue, tx)
+	select {
+	case g.wake <- struct{}{}:
+	default:
+	}
+	return g.viewLocked(tx), nil
+}
+
+// Status returns a transaction known to the gateway
+func (g *Gateway) Status(txID string) (Tx, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tx, ok := g.txs[txID]
+	if !ok {
+		return Tx{}, false
+	}
+	return g.viewLocked(tx), true
+}
+
+// Queue returns the active and waiting transactions
+func (g *Gateway) Queue() QueueStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := QueueStatus{Queue: make([]Tx, 0, len(g.queue)), Faulted: g.faulted, Fault: g.fault}
+	if g.active != nil {
+		a := *g.active
+		st.Active = &a
+	}
+	for _, tx := range g.queue {
+		st.Queue = append(st.Queue, g.viewLocked(tx))
+	}
+	return st
+}
+
+// viewLocked copies tx with its current queue position
+func (g *Gateway) viewLocked(tx *Tx) Tx {
+	v := *tx
+	v.QueuePosition = 0
+	if tx.State == client.StateQueued {
+		for i, q := range g.queue {
+			if q == tx {
+				v.QueuePosition = i + 1
+			}
+		}
+	}
+	return v
+}
+
+func (g *Gateway) maxQueue() int {
+	if g.MaxQueue > 0 {
+		return g.MaxQueue
+	}
+	return DefaultMaxQueue
+}
+
+// remember adds a finished transaction to the history, evicting the oldest
+func (g *Gateway) remember(tx *Tx) {
+	g.txs[tx.TxID] = tx
+	g.finished = append(g.finished, tx.TxID)
+	limit := g.History
+	if limit <= 0 {
+		limit = DefaultHistory
+	}
+	for len(g.finished) > limit {
+		delete(g.txs, g.finished[0])
+		g.finished = g.finished[1:]
+	}
+}
+
+// Run forwards queued transactions until ctx ends. It resolves unfinished
+// journal entries first, then checks whether the dispenser is in error.
+// Queued transactions that were not started when ctx ends are failed.
+func (g *Gateway) Run(ctx context.Context) error {
+	g.mu.Lock()
+	g.init()
+	g.mu.Unlock()
+	defer g.close()
+
+	if g.Client.Journal != nil {
+		if _, err := g.Client.Recover(ctx, client.WaitOptions{PollInterval: g.PollInterval}); err != nil {
+			g.logger().Warn("journal recovery incomplete", "err", err)
+		}
+		g.seed()
+	}
+	g.checkFault(ctx)
+
+	for {
+		g.mu.Lock()
+		var tx *Tx
+		if !g.faulted && len(g.queue) > 0 {
+			tx = g.queue[0]
+			g.queue = g.queue[1:]
+			g.active = tx
+			tx.State = "dispensing"
+			now := g.clock().Now()
+			tx.Started = &now
+		}
+		faulted := g.faulted
+		g.mu.Unlock()
+
+		switch {
+		case tx != nil:
+			g.forward(ctx, tx)
+		case faulted:
+			if err := g.sleep(ctx, retryInterval); err != nil {
+				return err
+			}
+			g.checkFault(ctx)
+			continue
+		default:
+			select {
+			case <-ctx.Done():
+			case <-g.wake:
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// forward runs one transaction on the dispenser, retrying the POST with
+// the same tx_id while the dispenser is unreachable or busy with a
+// transaction not started through the gateway
+func (g *Gateway) forward(ctx context.Context, tx *Tx) {
+	log := g.logger().With("tx_id", tx.TxID, "quantity", tx.Quantity)
+	log.Info("forwarding", "waited", tx.Started.Sub(tx.Queued))
+	opts := client.WaitOptions{
+		PollInterval: g.PollInterval,
+		OnProgress: func(r client.DispenseResponse) {
+			g.mu.Lock()
+			tx.Dispensed = r.Dispensed
+			g.mu.Unlock()
+		},
+	}
+
+	for {
+		res, err := g.Client.DispenseAndWait(ctx, tx.TxID, tx.Quantity, opts)
+		switch {
+		case err == nil:
+			g.finish(tx, res.State, res.Dispensed, res.Error)
+			log.Info("finished", "state", res.State, "dispensed", res.Dispensed, "outcome", res.Outcome)
+			if res.State == "error" {
+				g.setFault(tx.TxID)
+				log.Error("dispenser in error state, queue failed", "error", res.Error)
+			}
+			return
+		case ctx.Err() != nil:
+			// Shutting down mid-transaction; the journal, if any, keeps it
+			return
+		case errors.Is(err, client.ErrDispenserFault):
+			// Refused before anything ran
+			var apiErr *client.APIError
+			active := tx.TxID
+			if errors.As(err, &apiErr) && apiErr.ActiveTxID != "" {
+				active = apiErr.ActiveTxID
+			}
+			g.finish(tx, "error", 0, "dispenser_fault")
+			g.setFault(active)
+			log.Error("dispenser in error state, queue failed", "active_tx_id", active)
+			return
+		case errors.Is(err, client.ErrOutcomeUnknown):
+			g.finish(tx, client.StateUnknown, res.Dispensed, "outcome unknown, fell out of dispenser history")
+			log.Error("outcome unknown", "dispensed", res.Dispensed)
+			return
+		case errors.Is(err, client.ErrBusy), !isAPIError(err):
+			log.Warn("dispenser not available, retrying", "err", err)
+			if g.sleep(ctx, retryInterval) != nil {
+				return
+			}
+		default:
+			// 400, 401: the dispenser will never accept it
+			g.finish(tx, client.StateRejected, 0, err.Error())
+			log.Error("rejected by dispenser", "err", err)
+			return
+		}
+	}
+}
+
+func isAPIError(err error) bool {
+	var apiErr *client.APIError
+	return errors.As(err, &apiErr)
+}
+
+func (g *Gateway) finish(tx *Tx, state string, dispensed int, errMsg string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tx.State = state
+	tx.Dispensed = dispensed
+	tx.Error = errMsg
+	now := g.clock().Now()
+	tx.Finished = &now
+	if g.active == tx {
+		g.active = nil
+	}
+	g.remember(tx)
+}
+
+// setFault marks the dispenser as in error and fails the waiting
+// transactions: they never started, and a jam needs a power cycle that may
+// take longer than any customer waits
+func (g *Gateway) setFault(txID string) {
+	g.mu.Lock()
+	g.faulted, g.fault = true, txID
+	queue := g.queue
+	g.queue = nil
+	g.mu.Unlock()
+
+	for _, tx := range queue {
+		g.finish(tx, "error", 0, "dispenser_fault")
+	}
+}
+
+// checkFault asks the dispenser for its state and clears the fault once
+// it is no longer in error
+func (g *Gateway) checkFault(ctx context.Context) {
+	h, result := g.Client.Health(ctx)
+	if result.Error != nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case h.Dispenser == "error" && !g.faulted:
+		g.faulted, g.fault = true, ""
+		if h.ActiveTx != nil {
+			g.fault = h.ActiveTx.TxID
+		}
+		g.logger().Error("dispenser in error state", "tx_id", g.fault)
+	case h.Dispenser != "error" && g.faulted:
+		g.logger().Info("dispenser recovered, accepting transactions", "uptime", h.Uptime)
+		g.faulted, g.fault = false, ""
+	}
+}
+
+// close fails the transactions still queued
+func (g *Gateway) close() {
+	g.mu.Lock()
+	g.closed = true
+	queue := g.queue
+	g.queue = nil
+	g.mu.Unlock()
+	for _, tx := range queue {
+		g.finish(tx, client.StateRejected, 0, ErrClosed.Error())
+	}
+}
+
+func (g *Gateway) sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-g.clock().After(d):
+		return nil
+	}
+}
+
+// Fault reports whether the dispenser is in error and the tx_id it failed
+// on, empty if the dispenser did not say
+func (g *Gateway) Fault() (txID string, faulted bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.fault, g.faulted
+}
@@ -0,0 +1,366 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/sim"
+)
+
+const gatewayKey = "gateway-key"
+
+// harness is a gateway in front of a simulator on a fake clock
+type harness struct {
+	srv *sim.Server
+	clk *clock.Fake
+	g   *Gateway
+	gw  *client.DispenserClient // talks to the gateway
+}
+
+func newHarness(t *testing.T, journal *client.Journal) *harness {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := sim.DefaultConfig()
+	cfg.Clock = clk
+	srv := sim.NewServer(cfg)
+	t.Cleanup(srv.Close)
+
+	c := srv.DispenserClient()
+	c.Journal = journal
+	g := New(c)
+	g.Clock = clk
+	front := httptest.NewServer(g.Handler(gatewayKey))
+	t.Cleanup(front.Close)
+	return &harness{srv: srv, clk: clk, g: g, gw: client.NewDispenserClient(front.URL, gatewayKey, time.Second)}
+}
+
+// run starts forwarding until the test ends
+func (h *harness) run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.g.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// until advances the fake clock until cond holds
+func (h *harness) until(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		h.clk.Advance(100 * time.Millisecond)
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+// state is the gateway's state of txID, empty if unknown
+func (h *harness) state(txID string) string {
+	tx, _ := h.g.Status(txID)
+	return tx.State
+}
+
+func TestQueueFIFO(t *testing.T) {
+	h := newHarness(t, nil)
+	ctx := context.Background()
+	ids := []string{"aaaa0001", "bbbb0002", "cccc0003"}
+
+	for i, id := range ids {
+		resp, result := h.gw.Dispense(ctx, id, 2)
+		if result.Error != nil {
+			t.Fatalf("POST %s: %v", id, result.Error)
+		}
+		if resp.State != client.StateQueued || resp.QueuePosition != i+1 {
+			t.Errorf("POST %s: %s at %d, want queued at %d", id, resp.State, resp.QueuePosition, i+1)
+		}
+	}
+	// A retried POST returns the queued transaction, not a second one
+	if resp, _ := h.gw.Dispense(ctx, "bbbb0002", 2); resp.QueuePosition != 2 || len(h.g.Queue().Queue) != 3 {
+		t.Errorf("re-POST: position %d, %d queued; want 2, 3", resp.QueuePosition, len(h.g.Queue().Queue))
+	}
+	if resp, result := h.gw.Status(ctx, "cccc0003"); result.Error != nil || resp.QueuePosition != 3 {
+		t.Errorf("GET cccc0003: %+v %v, want queue position 3", resp, result.Error)
+	}
+
+	h.run(t)
+	h.until(t, "first forwarded", func() bool { return h.state("aaaa0001") == "dispensing" })
+	if resp, _ := h.gw.Status(ctx, "cccc0003"); resp.QueuePosition != 2 {
+		t.Errorf("cccc0003 at %d while the first dispenses, want 2", resp.QueuePosition)
+	}
+	h.until(t, "all done", func() bool { return h.state("cccc0003") == "done" })
+
+	var prev Tx
+	for i, id := range ids {
+		tx, _ := h.g.Status(id)
+		if tx.State != "done" || tx.Dispensed != 2 || tx.QueuePosition != 0 {
+			t.Errorf("%s: %+v, want done with 2", id, tx.DispenseResponse)
+		}
+		if i > 0 && tx.Started.Before(*prev.Finished) {
+			t.Errorf("%s started at %s, before %s finished at %s", id, tx.Started, prev.TxID, prev.Finished)
+		}
+		prev = tx
+	}
+	health, _ := h.srv.DispenserClient().Health(ctx)
+	if health.Metrics.TotalDispenses != 3 {
+		t.Errorf("dispenser ran %d transactions, want 3", health.Metrics.TotalDispenses)
+	}
+}
+
+func TestConflictOnlyOnFault(t *testing.T) {
+	h := newHarness(t, nil)
+	ctx := context.Background()
+	h.run(t)
+
+	if _, result := h.gw.Dispense(ctx, "aaaa0001", 3); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	h.until(t, "forwarded", func() bool { return h.state("aaaa0001") == "dispensing" })
+
+	// The dispenser is busy: the gateway queues instead of answering 409
+	resp, result := h.gw.Dispense(ctx, "bbbb0002", 1)
+	if result.Error != nil || resp.State != client.StateQueued || resp.QueuePosition != 1 {
+		t.Fatalf("POST while dispensing: %+v %v, want queued", resp, result.Error)
+	}
+
+	if err := h.srv.Sim.Inject(sim.Fault{Action: sim.ActionJam}); err != nil {
+		t.Fatal(err)
+	}
+	h.until(t, "jam", func() bool { return h.state("aaaa0001") == "error" })
+	if tx, _ := h.g.Status("bbbb0002"); tx.State != "error" || tx.Dispensed != 0 || tx.Error != "dispenser_fault" {
+		t.Errorf("waiting tx after the jam: %+v, want failed with dispenser_fault", tx.DispenseResponse)
+	}
+
+	_, result = h.gw.Dispense(ctx, "cccc0003", 1)
+	var apiErr *client.APIError
+	if !errors.Is(result.Error, client.ErrDispenserFault) || result.StatusCode != http.StatusConflict ||
+		!errors.As(result.Error, &apiErr) || apiErr.ActiveTxID != "aaaa0001" {
+		t.Fatalf("POST while jammed: %d %v, want 409 error on aaaa0001", result.StatusCode, result.Error)
+	}
+
+	// A power cycle clears the jam; the gateway notices on its next check
+	h.srv.Sim.Reboot()
+	h.until(t, "fault cleared", func() bool {
+		_, faulted := h.g.Fault()
+		return !faulted
+	})
+	if _, result := h.gw.Dispense(ctx, "cccc0003", 1); result.Error != nil {
+		t.Fatalf("POST after reboot: %v", result.Error)
+	}
+	h.until(t, "done after reboot", func() bool { return h.state("cccc0003") == "done" })
+}
+
+func TestSeedFromJournal(t *testing.T) {
+	j, err := client.OpenJournal(filepath.Join(t.TempDir(), "gateway.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	record := func(txID, state string, quantity, dispensed int) {
+		t.Helper()
+		if err := j.Intent(txID, quantity); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Observe(client.DispenseResponse{TxID: txID, State: state, Quantity: quantity, Dispensed: dispensed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Finished before the restart, long gone from the dispenser's history
+	record("done0001", "done", 2, 2)
+	record("jam00001", "error", 3, 1)
+	record("unkn0001", "dispensing", 2, 1)
+	if err := j.MarkUnknown("unkn0001", "fell out of dispenser history"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Intent("rejd0001", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Reject("rejd0001", client.ErrBusy); err != nil {
+		t.Fatal(err)
+	}
+	// Forwarded right before the restart, finished since
+	if err := j.Intent("open0001", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHarness(t, j)
+	ctx := context.Background()
+	if _, result := h.srv.DispenserClient().Dispense(ctx, "open0001", 1); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	for txID, want := range map[string]string{
+		"done0001": "done",
+		"jam00001": "error",
+		"unkn0001": client.StateUnknown,
+		"rejd0001": client.StateRejected,
+	} {
+		resp, result := h.gw.Status(ctx, txID)
+		if result.Error != nil || resp.State != want {
+			t.Errorf("GET %s: %+v %v, want %s from the journal", txID, resp, result.Error, want)
+		}
+	}
+	if _, ok := h.g.Status("open0001"); ok {
+		t.Error("unfinished journal entry seeded before recovery")
+	}
+
+	h.run(t)
+	h.until(t, "recovery", func() bool { return h.state("open0001") == "done" })
+	if _, faulted := h.g.Fault(); faulted {
+		t.Error("journal with a jam and a rejected tx faulted a healthy dispenser")
+	}
+	if _, result := h.gw.Dispense(ctx, "done0001", 2); result.Error != nil {
+		t.Errorf("re-POST of a journaled tx: %v", result.Error)
+	}
+	if health, _ := h.srv.DispenserClient().Health(ctx); health.Metrics.TotalDispenses != 1 {
+		t.Errorf("dispenser ran %d transactions, want only open0001", health.Metrics.TotalDispenses)
+	}
+}
+
+func TestLostTransactionUnknown(t *testing.T) {
+	// A dispenser that acknowledges the POST, then no longer knows the tx,
+	// as when it rebooted or ran 8 others before the next poll
+	dispenser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/health":
+			json.NewEncoder(w).Encode(client.HealthResponse{Status: "ok", Dispenser: "idle"})
+		case r.Method == http.MethodPost:
+			json.NewEncoder(w).Encode(client.DispenseResponse{TxID: "a1b2c3d4", State: "dispensing", Quantity: 3, Dispensed: 1})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(client.ErrorResponse{Error: "transaction not found"})
+		}
+	}))
+	defer dispenser.Close()
+
+	g := New(client.NewDispenserClient(dispenser.URL, "key", time.Second))
+	g.PollInterval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if _, err := g.Submit("a1b2c3d4", 3); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tx, _ := g.Status("a1b2c3d4")
+		if !tx.InProgress() {
+			if tx.State != client.StateUnknown || tx.Dispensed != 1 || !strings.Contains(tx.Error, "unknown") {
+				t.Errorf("lost tx: %+v, want unknown with 1 dispensed", tx.DispenseResponse)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lost tx still %s", tx.State)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if txID, faulted := g.Fault(); faulted {
+		t.Errorf("fault on %q after a lost tx, want none", txID)
+	}
+
+	// Over HTTP it is unknown, not 404
+	front := httptest.NewServer(g.Handler(gatewayKey))
+	defer front.Close()
+	resp, result := client.NewDispenserClient(front.URL, gatewayKey, time.Second).Status(ctx, "a1b2c3d4")
+	if result.Error != nil || resp.State != client.StateUnknown {
+		t.Errorf("GET: %+v %v, want state unknown", resp, result.Error)
+	}
+}
+
+func TestFaultWithoutActiveTx(t *testing.T) {
+	// In error without a transaction, e.g. a hardware error at boot
+	dispenser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(client.HealthResponse{Status: "error", Dispenser: "error"})
+	}))
+	defer dispenser.Close()
+
+	g := New(client.NewDispenserClient(dispenser.URL, "key", time.Second))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		txID, faulted := g.Fault()
+		if faulted {
+			if txID != "" {
+				t.Errorf("fault on %q, want no tx_id", txID)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fault not detected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	front := httptest.NewServer(g.Handler(gatewayKey))
+	defer front.Close()
+	_, result := client.NewDispenserClient(front.URL, gatewayKey, time.Second).Dispense(ctx, "a1b2c3d4", 1)
+	var apiErr *client.APIError
+	if !errors.Is(result.Error, client.ErrDispenserFault) || !errors.As(result.Error, &apiErr) || apiErr.ActiveTxID != "" {
+		t.Errorf("POST while in error: %d %v, want 409 error without a tx_id", result.StatusCode, result.Error)
+	}
+	if st := g.Queue(); !st.Faulted || st.Fault != "" {
+		t.Errorf("queue status %+v, want faulted without a tx_id", st)
+	}
+}
+
+func TestHandlerAuth(t *testing.T) {
+	h := newHarness(t, nil)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		handlerKey, clientKey string
+	}{
+		{gatewayKey, ""},
+		{gatewayKey, "wrong-key"},
+		{"", ""}, // no key configured refuses everyone
+	} {
+		front := httptest.NewServer(h.g.Handler(tc.handlerKey))
+		c := client.NewDispenserClient(front.URL, tc.clientKey, time.Second)
+		if _, result := c.Dispense(ctx, "a1b2c3d4", 1); !errors.Is(result.Error, client.ErrUnauthorized) {
+			t.Errorf("gateway key %q, client key %q: POST %d %v, want 401", tc.handlerKey, tc.clientKey, result.StatusCode, result.Error)
+		}
+		if _, result := c.Health(ctx); result.Error != nil {
+			t.Errorf("gateway key %q: GET /health %v, want no auth", tc.handlerKey, result.Error)
+		}
+		front.Close()
+	}
+	if _, ok := h.g.Status("a1b2c3d4"); ok {
+		t.Error("unauthorized POST was queued")
+	}
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"token-tui/dispenser/client"
+)
+
+// MaxTokens is the firmware's per-transaction limit
+const MaxTokens = 20
+
+// Health is GET /health of the gateway: the dispenser's health plus the
+// queue
+type Health struct {
+	*client.HealthResponse
+	Gateway GatewayHealth `json:"gateway"`
+}
+
+// GatewayHealth summarizes the queue in GET /health
+type GatewayHealth struct {
+	Queued   int    `json:"queued"`
+	ActiveTx string `json:"active_tx,omitempty"`
+	Faulted  bool   `json:"faulted,omitempty"`
+	Fault    string `json:"fault,omitempty"`
+}
+
+// Handler returns the dispenser API served by the gateway:
+//
+//	GET  /health              dispenser health plus queue summary
+//	POST /dispense            queue a transaction
+//	GET  /dispense/{tx_id}    transaction state and queue position
+//	GET  /queue               active and waiting transactions
+//
+// Clients authenticate with apiKey in X-API-Key, as with the firmware; an
+// empty apiKey refuses everything but /health. Status of a transaction the
+// gateway does not know is fetched from the dispenser.
+func (g *Gateway) Handler(apiKey string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", g.handleHealth)
+	mux.HandleFunc("POST /dispense", g.handleDispensePost)
+	mux.HandleFunc("GET /dispense/{tx_id...}", g.handleDispenseGet)
+	mux.HandleFunc("GET /queue", g.handleQueue)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" && (apiKey == "" || r.Header.Get("X-API-Key") != apiKey) {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (g *Gateway) handleHealth(w http.ResponseWriter, r *http.Request) {
+	h, result := g.Client.Health(r.Context())
+	if result.Error != nil {
+		writeError(w, http.StatusBadGateway, "dispenser unreachable: "+result.Error.Error())
+		return
+	}
+	q := g.Queue()
+	gh := GatewayHealth{Queued: len(q.Queue), Faulted: q.Faulted, Fault: q.Fault}
+	if q.Active != nil {
+		gh.ActiveTx = q.Active.TxID
+	}
+	writeJSON(w, http.StatusOK, Health{HealthResponse: h, Gateway: gh})
+}
+
+func (g *Gateway) handleDispensePost(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, "content-type must be application/json")
+		return
+	}
+
+	// Same validation as the firmware, so that nothing is queued that the
+	// dispenser would refuse
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	txID, okID := req["tx_id"].(string)
+	qtyNum, okQty := req["quantity"].(float64)
+	if !okID || !okQty || qtyNum != math.Trunc(qtyNum) || qtyNum < 0 || qtyNum > 255 {
+		writeError(w, http.StatusBadRequest, "invalid request format")
+		return
+	}
+	quantity := int(qtyNum)
+	if len(txID) == 0 || len(txID) > client.MaxTxIDLen || quantity == 0 || quantity > MaxTokens {
+		writeError(w, http.StatusBadRequest, "invalid tx_id or quantity")
+		return
+	}
+
+	tx, err := g.Submit(txID, quantity)
+	var fault *FaultError
+	switch {
+	case errors.As(err, &fault):
+		// As the firmware answers while its active transaction is in error
+		writeJSON(w, http.StatusConflict, client.ErrorResponse{
+			Error:       "busy",
+			ActiveTxID:  fault.TxID,
+			ActiveState: "error",
+		})
+	case err != nil:
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, tx.DispenseResponse)
+	}
+}
+
+func (g *Gateway) handleDispenseGet(w http.ResponseWriter, r *http.Request) {
+	txID := strings.TrimSpace(r.PathValue("tx_id"))
+	if len(txID) == 0 || len(txID) > client.MaxTxIDLen {
+		writeError(w, http.StatusBadRequest, "invalid tx_id")
+		return
+	}
+
+	if tx, ok := g.Status(txID); ok {
+		writeJSON(w, http.StatusOK, tx.DispenseResponse)
+		return
+	}
+	resp, result := g.Client.Status(r.Context(), txID)
+	var apiErr *client.APIError
+	switch {
+	case errors.As(result.Error, &apiErr):
+		writeJSON(w, apiErr.StatusCode, client.ErrorResponse{Error: apiErr.Message})
+	case result.Error != nil:
+		writeError(w, http.StatusBadGateway, "dispenser unreachable: "+result.Error.Error())
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func (g *Gateway) handleQueue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, g.Queue())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, client.ErrorResponse{Error: msg})
+}
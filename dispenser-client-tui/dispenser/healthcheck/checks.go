@@ -0,0 +1,42 @@
+package healthcheck
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// thermalZone is the Raspberry Pi CPU temperature in millidegrees Celsius
+const thermalZone = "/sys/class/thermal/thermal_zone0/temp"
+
+// Checks are the local signals of the report. A nil check is reported as
+// "unknown"; the frontend watchdog, sync queue and display manager live
+// in other components and plug in here.
+type Checks struct {
+	Frontend   func() string // running, restarted or unresponsive
+	Display    func() string // on, off or dimmed
+	SyncQueue  func() (int, error)
+	DiskFreeMB func() (uint64, error)
+	CPUTempC   func() (float64, error)
+}
+
+// SystemChecks reads disk space of / and the CPU temperature of this
+// machine
+func SystemChecks() Checks {
+	return Checks{
+		DiskFreeMB: func() (uint64, error) { return diskFreeMB("/") },
+		CPUTempC:   cpuTempC,
+	}
+}
+
+func cpuTempC() (float64, error) {
+	data, err := os.ReadFile(thermalZone)
+	if err != nil {
+		return 0, err
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, err
+	}
+	return float64(milli) / 1000, nil
+}
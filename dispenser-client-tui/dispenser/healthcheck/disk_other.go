@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package healthcheck
+
+import "errors"
+
+func diskFreeMB(path string) (uint64, error) {
+	return 0, errors.New("disk space not supported on this platform")
+}
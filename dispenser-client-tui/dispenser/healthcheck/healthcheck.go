@@ -0,0 +1,263 @@
+// Package healthcheck reports the aggregated terminal status to a
+// healthchecks.io check as described in pos-daemon-design.md, section 3:
+// one ping per cycle to <url> when everything is ok or to <url>/fail with
+// the details otherwise, plus <url>/log for informational events. A ping
+// that cannot be delivered is not retried right away; the next cycle
+// reports the then current status and resends queued log messages.
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/logging"
+	"token-tui/dispenser/monitor"
+)
+
+// maxPendingLogs bounds the log messages kept while the check is unreachable
+const maxPendingLogs = 20
+
+// Report is the body of one ping
+type Report struct {
+	Wemos     string // ok, unreachable or error
+	Hopper    string // ok, low or empty
+	Dispenser string // idle, dispensing or "error (jam)"
+	Frontend  string
+	SyncQueue string
+	DiskFree  string
+	CPUTemp   string
+	Display   string
+
+	// Problems are the warning and critical findings; any makes the
+	// report a failure
+	Problems []string
+}
+
+// OK reports whether the report goes to the success URL
+func (r Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// String formats the payload of pos-daemon-design.md, followed by the
+// problems if there are any
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "wemos: %s\n", r.Wemos)
+	fmt.Fprintf(&b, "hopper: %s\n", r.Hopper)
+	fmt.Fprintf(&b, "dispenser: %s\n", r.Dispenser)
+	fmt.Fprintf(&b, "frontend: %s\n", r.Frontend)
+	fmt.Fprintf(&b, "sync_queue: %s\n", r.SyncQueue)
+	fmt.Fprintf(&b, "disk_free: %s\n", r.DiskFree)
+	fmt.Fprintf(&b, "cpu_temp: %s\n", r.CPUTemp)
+	fmt.Fprintf(&b, "display: %s\n", r.Display)
+	if len(r.Problems) > 0 {
+		b.WriteString("\nproblems:\n")
+		for _, p := range r.Problems {
+			fmt.Fprintf(&b, "- %s\n", p)
+		}
+	}
+	return b.String()
+}
+
+// Build aggregates the dispenser status and the local checks into a report
+func Build(st monitor.Status, checks Checks, limits monitor.SystemConfig) Report {
+	r := Report{Wemos: "ok", Hopper: "ok", Dispenser: "unknown"}
+
+	switch {
+	case !st.Reachable:
+		r.Wemos = "unreachable"
+	case st.Health != nil && st.Health.Status == "error":
+		// "degraded" only means hopper low, which has its own line
+		r.Wemos = "error"
+	}
+	switch {
+	case st.Has(monitor.HopperEmpty):
+		r.Hopper = "empty"
+	case st.Has(monitor.HopperLow):
+		r.Hopper = "low"
+	}
+	if st.Reachable && st.Health != nil {
+		r.Dispenser = st.Health.Dispenser
+		if r.Dispenser == "error" {
+			r.Dispenser = "error (jam)"
+		}
+	}
+	for _, c := range st.Active {
+		if sev := c.Severity(); sev > monitor.SeverityInfo {
+			r.Problems = append(r.Problems, fmt.Sprintf("%s: %s", sev, c))
+		}
+	}
+
+	r.Frontend = checkString(checks.Frontend)
+	r.Display = checkString(checks.Display)
+
+	r.SyncQueue = "unknown"
+	if checks.SyncQueue != nil {
+		if n, err := checks.SyncQueue(); err == nil {
+			r.SyncQueue = fmt.Sprintf("%d pending transactions", n)
+			if limits.SyncWarnCount > 0 && n > limits.SyncWarnCount {
+				r.Problems = append(r.Problems, fmt.Sprintf("warning: sync queue above %d", limits.SyncWarnCount))
+			}
+		}
+	}
+	r.DiskFree = "unknown"
+	if checks.DiskFreeMB != nil {
+		if mb, err := checks.DiskFreeMB(); err == nil {
+			r.DiskFree = fmt.Sprintf("%d MB", mb)
+			if limits.DiskWarnMB > 0 && mb < uint64(limits.DiskWarnMB) {
+				r.Problems = append(r.Problems, fmt.Sprintf("warning: disk free below %d MB", limits.DiskWarnMB))
+			}
+		}
+	}
+	r.CPUTemp = "unknown"
+	if checks.CPUTempC != nil {
+		if t, err := checks.CPUTempC(); err == nil {
+			r.CPUTemp = fmt.Sprintf("%.1f°C", t)
+			if limits.TempWarnC > 0 && t > limits.TempWarnC {
+				r.Problems = append(r.Problems, fmt.Sprintf("warning: cpu temperature above %.0f°C", limits.TempWarnC))
+			}
+		}
+	}
+	return r
+}
+
+func checkString(f func() string) string {
+	if f == nil {
+		return "unknown"
+	}
+	return f()
+}
+
+// Reporter pings one healthchecks.io check
+type Reporter struct {
+	// BaseURL is the ping URL of the check, e.g. https://hc-ping.com/<uuid>
+	// or a local stand-in server
+	BaseURL    string
+	HTTPClient *http.Client
+	Checks     Checks
+	Limits     monitor.SystemConfig
+	// Logger reports failed pings; nil discards
+	Logger *slog.Logger
+	// Clock paces Run; nil means clock.Real
+	Clock clock.Clock
+
+	mu      sync.Mutex
+	pending []string
+}
+
+// New returns a reporter for baseURL with the local checks of this machine
+func New(baseURL string, limits monitor.SystemConfig, logger *slog.Logger) *Reporter {
+	return &Reporter{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Checks:     SystemChecks(),
+		Limits:     limits,
+		Logger:     logger,
+	}
+}
+
+// Log queues an informational message for <url>/log. It is sent with the
+// next Report.
+func (r *Reporter) Log(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, msg)
+	if len(r.pending) > maxPendingLogs {
+		r.pending = r.pending[len(r.pending)-maxPendingLogs:]
+	}
+}
+
+// Report pings the success or fail URL with the current status and sends
+// the queued log messages. The status is pinged even when the messages
+// fail, which then stay queued; the error joins both failures.
+func (r *Reporter) Report(ctx context.Context, st monitor.Status) (Report, error) {
+	rep := Build(st, r.Checks, r.Limits)
+
+	r.mu.Lock()
+	logs := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	var logErr error
+	if len(logs) > 0 {
+		if logErr = r.ping(ctx, "/log", strings.Join(logs, "\n")+"\n"); logErr != nil {
+			r.requeue(logs)
+		}
+	}
+
+	path := "/"
+	if !rep.OK() {
+		path = "/fail"
+	}
+	return rep, errors.Join(logErr, r.ping(ctx, path, rep.String()))
+}
+
+func (r *Reporter) requeue(logs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(logs, r.pending...)
+	if len(r.pending) > maxPendingLogs {
+		r.pending = r.pending[len(r.pending)-maxPendingLogs:]
+	}
+}
+
+func (r *Reporter) ping(ctx context.Context, path, body string) error {
+	url := r.BaseURL
+	if path != "/" {
+		url += path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	hc := r.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("ping %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ping %s: HTTP %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// Run reports status() every interval until ctx ends. The first report is
+// sent after one interval, once the monitor has polled. Failed pings are
+// logged and left for the next cycle.
+func (r *Reporter) Run(ctx context.Context, interval time.Duration, status func() monitor.Status) error {
+	clk := r.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clk.After(interval):
+		}
+		rep, err := r.Report(ctx, status())
+		if err != nil && ctx.Err() == nil {
+			r.logger().Warn("healthcheck ping failed, retrying next cycle", "err", err)
+		} else if err == nil {
+			r.logger().Debug("healthcheck ping", "ok", rep.OK())
+		}
+	}
+}
+
+func (r *Reporter) logger() *slog.Logger {
+	return logging.OrDiscard(r.Logger)
+}
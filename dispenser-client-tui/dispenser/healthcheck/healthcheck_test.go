@@ -0,0 +1,61 @@
+package healthcheck
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"token-tui/dispenser/monitor"
+)
+
+func TestReportPingsStatusWhenLogFails(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+		logOK bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, req.URL.Path)
+		if req.URL.Path == "/log" {
+			if !logOK {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if got := string(body); got != "first\nsecond\n" {
+				t.Errorf("log body = %q", got)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	r := &Reporter{BaseURL: srv.URL}
+	st := monitor.Status{Reachable: true}
+	r.Log("first")
+
+	_, err := r.Report(context.Background(), st)
+	if err == nil || !strings.Contains(err.Error(), "ping /log: HTTP 503") {
+		t.Errorf("err = %v, want the /log failure", err)
+	}
+	if want := []string{"/log", "/"}; strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Fatalf("pinged %v, want %v", paths, want)
+	}
+
+	// The failed message is resent ahead of newer ones
+	mu.Lock()
+	paths, logOK = nil, true
+	mu.Unlock()
+	r.Log("second")
+	if _, err := r.Report(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/log", "/"}; strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("pinged %v, want %v", paths, want)
+	}
+}
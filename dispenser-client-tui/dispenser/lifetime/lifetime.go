@@ -0,0 +1,259 @@
+// Package lifetime keeps dispense counters across dispenser reboots. The
+// firmware's metrics restart at zero on every power cycle, and power
+// cycling is how a jam is cleared, so its success rate only covers the time
+// since the last jam. A Tracker watches successive /health responses,
+// detects reboots and counter resets, and adds the counters of every
+// finished boot to a lifetime total that can be persisted to a file.
+package lifetime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+// maxReboots bounds the reboot history kept in the state file
+const maxReboots = 20
+
+// Reasons a reboot was detected
+const (
+	ReasonUptime   = "uptime_decreased"
+	ReasonFirmware = "firmware_changed"
+	ReasonBootTime = "boot_time_moved" // rebooted while nobody was watching
+	ReasonCounters = "counters_reset"
+)
+
+// Counts are the firmware's dispense counters
+type Counts struct {
+	TotalDispenses int `json:"total_dispenses"`
+	Successful     int `json:"successful"`
+	Jams           int `json:"jams"`
+	Partial        int `json:"partial"`
+	Failures       int `json:"failures"`
+}
+
+// CountsOf returns the counters of m
+func CountsOf(m client.Metrics) Counts {
+	return Counts{m.TotalDispenses, m.Successful, m.Jams, m.Partial, m.Failures}
+}
+
+// Add returns the sum of c and o
+func (c Counts) Add(o Counts) Counts {
+	return Counts{
+		TotalDispenses: c.TotalDispenses + o.TotalDispenses,
+		Successful:     c.Successful + o.Successful,
+		Jams:           c.Jams + o.Jams,
+		Partial:        c.Partial + o.Partial,
+		Failures:       c.Failures + o.Failures,
+	}
+}
+
+// SuccessRate is the percentage of successful dispenses, 0 without any
+func (c Counts) SuccessRate() float64 {
+	if c.TotalDispenses == 0 {
+		return 0
+	}
+	return float64(c.Successful) / float64(c.TotalDispenses) * 100
+}
+
+// less reports whether any counter of c is below the one in o
+func (c Counts) less(o Counts) bool {
+	return c.TotalDispenses < o.TotalDispenses || c.Successful < o.Successful ||
+		c.Jams < o.Jams || c.Partial < o.Partial || c.Failures < o.Failures
+}
+
+// Observation is the last health seen from the dispenser
+type Observation struct {
+	Time     time.Time         `json:"time"`
+	Uptime   int               `json:"uptime"`
+	Firmware string            `json:"firmware"`
+	Counts   Counts            `json:"counts"`
+	Error    *client.ErrorInfo `json:"error,omitempty"` // active error, if any
+}
+
+// BootTime estimates when the dispenser booted
+func (o Observation) BootTime() time.Time {
+	return o.health().BootTime(o.Time)
+}
+
+// health returns the fields of the observed response that tell a reboot
+func (o Observation) health() *client.HealthResponse {
+	return &client.HealthResponse{Uptime: o.Uptime, Firmware: o.Firmware}
+}
+
+// Reboot records one detected reboot or counter reset. The Last fields
+// describe the dispenser as it was last seen before it.
+type Reboot struct {
+	Time         time.Time         `json:"time"` // when it was detected
+	Reason       string            `json:"reason"`
+	LastSeen     time.Time         `json:"last_seen"`
+	LastUptime   int               `json:"last_uptime"`
+	LastFirmware string            `json:"last_firmware"`
+	LastCounts   Counts            `json:"last_counts"`
+	LastError    *client.ErrorInfo `json:"last_error,omitempty"`
+	Firmware     string            `json:"firmware"`
+	Uptime       int               `json:"uptime"`
+}
+
+// String describes the reboot for a log line
+func (r Reboot) String() string {
+	s := fmt.Sprintf("reboot detected (%s): last seen %s at uptime %ds, firmware %s",
+		r.Reason, r.LastSeen.Format(time.TimeOnly), r.LastUptime, r.LastFirmware)
+	if r.Firmware != r.LastFirmware {
+		s += " -> " + r.Firmware
+	}
+	if r.LastError != nil && r.LastError.Active {
+		s += fmt.Sprintf(", active error %d %s", r.LastError.Code, r.LastError.Type)
+	}
+	return s
+}
+
+// State is what a Tracker persists
+type State struct {
+	Since     time.Time    `json:"since"`     // first observation
+	Completed Counts       `json:"completed"` // sum over finished boots
+	Reboots   int          `json:"reboots"`
+	Last      *Observation `json:"last,omitempty"`
+	History   []Reboot     `json:"history,omitempty"` // most recent last
+}
+
+// Tracker accumulates lifetime counters. The zero value tracks in memory;
+// Open persists the state to a file.
+type Tracker struct {
+	// Path is the state file; empty keeps the state in memory only
+	Path string
+	// Clock stamps observations; nil means clock.Real
+	Clock clock.Clock
+
+	state State
+}
+
+// Open loads the tracker state from path. A missing file starts a new
+// lifetime.
+func Open(path string) (*Tracker, error) {
+	t := &Tracker{Path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.state); err != nil {
+		return nil, fmt.Errorf("lifetime state %s: %w", path, err)
+	}
+	return t, nil
+}
+
+func (t *Tracker) clock() clock.Clock {
+	if t.Clock == nil {
+		return clock.Real
+	}
+	return t.Clock
+}
+
+// Observe records a health response. It returns the reboot if one
+// happened since the previous observation; the counters of the previous
+// boot then move to the completed total. Dispenses between the last
+// observation and the reboot are not seen and not counted. The error is
+// from saving the state; the observation counts regardless.
+func (t *Tracker) Observe(h *client.HealthResponse) (*Reboot, error) {
+	obs := Observation{
+		Time:     t.clock().Now(),
+		Uptime:   h.Uptime,
+		Firmware: h.Firmware,
+		Counts:   CountsOf(h.Metrics),
+	}
+	if h.Error != nil && h.Error.Active {
+		e := *h.Error
+		obs.Error = &e
+	}
+
+	var reboot *Reboot
+	if last := t.state.Last; last == nil {
+		t.state.Since = obs.Time
+	} else if reason := rebootReason(*last, obs); reason != "" {
+		reboot = &Reboot{
+			Time:         obs.Time,
+			Reason:       reason,
+			LastSeen:     last.Time,
+			LastUptime:   last.Uptime,
+			LastFirmware: last.Firmware,
+			LastCounts:   last.Counts,
+			LastError:    last.Error,
+			Firmware:     obs.Firmware,
+			Uptime:       obs.Uptime,
+		}
+		t.state.Completed = t.state.Completed.Add(last.Counts)
+		t.state.Reboots++
+		t.state.History = append(t.state.History, *reboot)
+		if len(t.state.History) > maxReboots {
+			t.state.History = t.state.History[len(t.state.History)-maxReboots:]
+		}
+	}
+	t.state.Last = &obs
+
+	if t.Path == "" {
+		return reboot, nil
+	}
+	return reboot, t.save()
+}
+
+func rebootReason(last, cur Observation) string {
+	switch {
+	case client.Rebooted(last.health(), cur.health()):
+		return ReasonUptime
+	case cur.Firmware != last.Firmware:
+		return ReasonFirmware
+	case client.BootMoved(last.BootTime(), cur.BootTime()):
+		return ReasonBootTime
+	case cur.Counts.less(last.Counts):
+		return ReasonCounters
+	}
+	return ""
+}
+
+func (t *Tracker) save() error {
+	data, err := json.MarshalIndent(t.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.Path)
+}
+
+// Boot returns the counters since the last boot
+func (t *Tracker) Boot() Counts {
+	if t.state.Last == nil {
+		return Counts{}
+	}
+	return t.state.Last.Counts
+}
+
+// Lifetime returns the counters over all observed boots
+func (t *Tracker) Lifetime() Counts {
+	return t.state.Completed.Add(t.Boot())
+}
+
+// State returns a copy of the tracked state
+func (t *Tracker) State() State {
+	st := t.state
+	st.History = append([]Reboot(nil), t.state.History...)
+	return st
+}
+
+// LastReboot returns the most recent reboot, if any was seen
+func (t *Tracker) LastReboot() (Reboot, bool) {
+	if len(t.state.History) == 0 {
+		return Reboot{}, false
+	}
+	return t.state.History[len(t.state.History)-1], true
+}
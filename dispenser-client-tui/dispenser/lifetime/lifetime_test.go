@@ -0,0 +1,63 @@
+package lifetime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/sim"
+)
+
+func TestRebootDetectedFromUptime(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := sim.DefaultConfig()
+	cfg.Clock = clk
+	srv := sim.NewServer(cfg)
+	defer srv.Close()
+	c := srv.DispenserClient()
+	tr := &Tracker{Clock: clk}
+
+	observe := func() *Reboot {
+		t.Helper()
+		h, result := c.Health(context.Background())
+		if result.Error != nil {
+			t.Fatalf("health: %v", result.Error)
+		}
+		reboot, err := tr.Observe(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reboot
+	}
+
+	observe()
+	clk.Advance(10 * time.Minute)
+	if r := observe(); r != nil {
+		t.Fatalf("reboot reported while up: %v", r)
+	}
+
+	// Power cycled shortly before the next poll: the uptime went down
+	clk.Advance(time.Minute)
+	srv.Sim.Reboot()
+	clk.Advance(5 * time.Second)
+	r := observe()
+	if r == nil || r.Reason != ReasonUptime {
+		t.Fatalf("reboot = %v, want %s", r, ReasonUptime)
+	}
+	if r.LastUptime != 600 || r.Uptime != 5 {
+		t.Errorf("uptimes = %d -> %d, want 600 -> 5", r.LastUptime, r.Uptime)
+	}
+
+	// Power cycled while nobody polled for longer than the last uptime:
+	// only the boot time gives it away
+	clk.Advance(10 * time.Minute)
+	srv.Sim.Reboot()
+	clk.Advance(30 * time.Minute)
+	if r := observe(); r == nil || r.Reason != ReasonBootTime {
+		t.Fatalf("reboot = %v, want %s", r, ReasonBootTime)
+	}
+	if got := tr.State().Reboots; got != 2 {
+		t.Errorf("reboots = %d, want 2", got)
+	}
+}
@@ -0,0 +1,27 @@
+// Package logging holds the log/slog helpers shared by the daemon
+// packages, whose Logger fields are optional.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discard is a logger that drops every record
+var discard = slog.New(discardHandler{})
+
+// OrDiscard returns l, or a logger that drops every record if l is nil
+func OrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discard
+	}
+	return l
+}
+
+// discardHandler drops all records
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
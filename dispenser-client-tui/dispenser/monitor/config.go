@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the part of the POS daemon config file (pos-daemon-design.md)
+// the monitor reads. Other sections are ignored, so the daemon's own
+// /etc/pos-daemon/config.toml can be used as is.
+type Config struct {
+	Wemos       WemosConfig       `toml:"wemos"`
+	Healthcheck HealthcheckConfig `toml:"healthcheck"`
+	System      SystemConfig      `toml:"system"`
+}
+
+// WemosConfig is the [wemos] section
+type WemosConfig struct {
+	Host          string `toml:"host"`
+	Port          int    `toml:"port"`
+	PollIntervalS int    `toml:"poll_interval_s"`
+	TimeoutMS     int    `toml:"timeout_ms"`
+	Retries       int    `toml:"retries"`
+	// RetryDelayMS is the pause between the attempts of one poll, so a
+	// WiFi hiccup is not retried into the same outage
+	RetryDelayMS int `toml:"retry_delay_ms"`
+
+	// ExpectedFirmware raises a firmware mismatch when /health reports a
+	// different version; empty disables the check
+	ExpectedFirmware string `toml:"expected_firmware"`
+	// APIKey is sent with every request; /health does not require it
+	APIKey string `toml:"api_key"`
+}
+
+// HealthcheckConfig is the [healthcheck] section
+type HealthcheckConfig struct {
+	// URL is the check's ping URL, e.g. https://hc-ping.com/<uuid>; empty
+	// disables reporting
+	URL       string `toml:"url"`
+	IntervalS int    `toml:"interval_s"`
+}
+
+// Interval is the time between reports
+func (h HealthcheckConfig) Interval() time.Duration {
+	return time.Duration(h.IntervalS) * time.Second
+}
+
+// SystemConfig is the [system] section: alert thresholds of the local
+// checks included in the report
+type SystemConfig struct {
+	DiskWarnMB    int     `toml:"disk_warn_mb"`
+	TempWarnC     float64 `toml:"temp_warn_c"`
+	SyncWarnCount int     `toml:"sync_warn_count"`
+}
+
+// DefaultConfig matches the defaults of pos-daemon-design.md
+func DefaultConfig() Config {
+	return Config{
+		Wemos: WemosConfig{
+			Host:          "192.168.4.1",
+			Port:          80,
+			PollIntervalS: 60,
+			TimeoutMS:     3000,
+			Retries:       3,
+			RetryDelayMS:  1000,
+		},
+		Healthcheck: HealthcheckConfig{IntervalS: 60},
+		System:      SystemConfig{DiskWarnMB: 500, TempWarnC: 80, SyncWarnCount: 50},
+	}
+}
+
+// LoadConfig reads a TOML config file on top of DefaultConfig
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
+	if err := cfg.Wemos.Validate(); err != nil {
+		return cfg, err
+	}
+	if cfg.Healthcheck.URL != "" && cfg.Healthcheck.IntervalS < 1 {
+		return cfg, fmt.Errorf("healthcheck.interval_s must be positive")
+	}
+	return cfg, nil
+}
+
+// Validate checks the section for values the poller cannot work with
+func (w WemosConfig) Validate() error {
+	switch {
+	case w.Host == "":
+		return fmt.Errorf("wemos.host is empty")
+	case w.Port < 1 || w.Port > 65535:
+		return fmt.Errorf("wemos.port %d out of range", w.Port)
+	case w.PollIntervalS < 1:
+		return fmt.Errorf("wemos.poll_interval_s must be positive")
+	case w.TimeoutMS < 1:
+		return fmt.Errorf("wemos.timeout_ms must be positive")
+	case w.Retries < 1:
+		return fmt.Errorf("wemos.retries must be at least 1")
+	case w.RetryDelayMS < 0:
+		return fmt.Errorf("wemos.retry_delay_ms must not be negative")
+	}
+	return nil
+}
+
+// BaseURL is the dispenser address for client.NewDispenserClient
+func (w WemosConfig) BaseURL() string {
+	if w.Port == 80 {
+		return "http://" + w.Host
+	}
+	return "http://" + net.JoinHostPort(w.Host, strconv.Itoa(w.Port))
+}
+
+// PollInterval is the time between health polls
+func (w WemosConfig) PollInterval() time.Duration {
+	return time.Duration(w.PollIntervalS) * time.Second
+}
+
+// Timeout is the per-attempt HTTP timeout
+func (w WemosConfig) Timeout() time.Duration {
+	return time.Duration(w.TimeoutMS) * time.Millisecond
+}
+
+// RetryDelay is the pause between the attempts of one poll
+func (w WemosConfig) RetryDelay() time.Duration {
+	return time.Duration(w.RetryDelayMS) * time.Millisecond
+}
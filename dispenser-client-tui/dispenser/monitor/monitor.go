@@ -0,0 +1,301 @@
+// Package monitor implements the Wemos health poller of the POS daemon
+// (pos-daemon-design.md, section 2). A Monitor polls GET /health, evaluates
+// the tracked conditions and reports every transition as an Event with a
+// severity, so that alerting only fires when something changes.
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/logging"
+	"token-tui/dispenser/timebase"
+)
+
+// Severity ranks conditions as in the design's condition table
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return "info"
+	}
+}
+
+// Level is the slog level raised conditions of this severity are logged
+// at; clearing is always logged at info
+func (s Severity) Level() slog.Level {
+	switch s {
+	case SeverityWarning:
+		return slog.LevelWarn
+	case SeverityCritical:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// MarshalText makes severities readable in JSON
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Condition is one of the tracked conditions
+type Condition string
+
+const (
+	Unreachable      Condition = "wemos_unreachable"
+	HopperLow        Condition = "hopper_low"
+	HopperEmpty      Condition = "hopper_empty"
+	Jammed           Condition = "dispenser_jammed"
+	Rebooted         Condition = "wemos_rebooted"
+	FirmwareMismatch Condition = "firmware_mismatch"
+)
+
+// Severity is the fixed severity of the condition
+func (c Condition) Severity() Severity {
+	switch c {
+	case Unreachable, HopperEmpty, Jammed:
+		return SeverityCritical
+	case HopperLow:
+		return SeverityWarning
+	default:
+		return SeverityInfo
+	}
+}
+
+// Event is a condition being raised or cleared. A reboot is a one-off
+// event: it is raised once and never active.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Condition Condition `json:"condition"`
+	Severity  Severity  `json:"severity"`
+	Cleared   bool      `json:"cleared"`
+	Message   string    `json:"message"`
+}
+
+// Status is the result of the latest poll
+type Status struct {
+	Time      time.Time              `json:"time"`
+	Reachable bool                   `json:"reachable"`
+	Health    *client.HealthResponse `json:"health,omitempty"` // last successful poll
+	Error     string                 `json:"error,omitempty"`  // of the latest poll
+	Active    []Condition            `json:"active"`           // most severe first
+}
+
+// Worst is the highest severity among the active conditions, and false if
+// none is active
+func (s Status) Worst() (Severity, bool) {
+	if len(s.Active) == 0 {
+		return SeverityInfo, false
+	}
+	return s.Active[0].Severity(), true
+}
+
+// Has reports whether c is active
+func (s Status) Has(c Condition) bool {
+	for _, a := range s.Active {
+		if a == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Monitor polls one dispenser. Check and Status are safe for concurrent use.
+type Monitor struct {
+	Client *client.DispenserClient
+	Config WemosConfig
+	// Logger receives every event and a debug line per poll; nil discards
+	Logger *slog.Logger
+	// OnEvent, if set, is called for every event after it was logged
+	OnEvent func(Event)
+	// Clock paces polls; nil means clock.Real
+	Clock clock.Clock
+
+	mu       sync.Mutex
+	active   map[Condition]string // condition -> message
+	timebase timebase.TimeBase
+	status   Status
+}
+
+// New returns a monitor for the dispenser described by cfg
+func New(cfg WemosConfig, logger *slog.Logger) *Monitor {
+	c := client.NewDispenserClient(cfg.BaseURL(), cfg.APIKey, cfg.Timeout())
+	return &Monitor{Client: c, Config: cfg, Logger: logger}
+}
+
+func (m *Monitor) clock() clock.Clock {
+	if m.Clock == nil {
+		return clock.Real
+	}
+	return m.Clock
+}
+
+func (m *Monitor) logger() *slog.Logger {
+	return logging.OrDiscard(m.Logger)
+}
+
+// Run polls every PollInterval until ctx ends, starting immediately
+func (m *Monitor) Run(ctx context.Context) error {
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.clock().After(m.Config.PollInterval()):
+		}
+	}
+}
+
+// Check polls /health once, with up to Retries attempts RetryDelay apart,
+// and returns the transitions it caused
+func (m *Monitor) Check(ctx context.Context) []Event {
+	var h *client.HealthResponse
+	var result client.APIResult
+	retries := max(1, m.Config.Retries)
+	for attempt := 1; attempt <= retries; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+			case <-m.clock().After(m.Config.RetryDelay()):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		h, result = m.Client.Health(ctx)
+		if result.Error == nil {
+			break
+		}
+		m.logger().Debug("health poll failed", "attempt", attempt, "err", result.Error)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	now := m.clock().Now()
+	if m.active == nil {
+		m.active = make(map[Condition]string)
+	}
+	var events []Event
+	// set raises c with msg or clears it, reporting only changes
+	set := func(c Condition, on bool, msg string) {
+		raised, was := m.active[c]
+		switch {
+		case on && !was:
+			m.active[c] = msg
+			events = append(events, Event{Time: now, Condition: c, Severity: c.Severity(), Message: msg})
+		case !on && was:
+			delete(m.active, c)
+			events = append(events, Event{Time: now, Condition: c, Severity: c.Severity(), Cleared: true,
+				Message: "cleared: " + raised})
+		}
+	}
+
+	m.status.Time = now
+	if result.Error != nil {
+		// The other conditions keep their last known state
+		set(Unreachable, true, fmt.Sprintf("no response after %d attempts: %v", retries, result.Error))
+		m.status.Reachable = false
+		m.status.Error = result.Error.Error()
+	} else {
+		set(Unreachable, false, "")
+		m.timebase.Observe(h, now, result.Latency)
+		m.evaluate(h, now, set, func(e Event) { events = append(events, e) })
+		m.status.Reachable = true
+		m.status.Health = h
+		m.status.Error = ""
+		m.logger().Debug("health", "dispenser", h.Dispenser, "uptime", h.Uptime,
+			"firmware", h.Firmware, "hopper_low", h.HopperLow(), "latency", result.Latency)
+	}
+	m.status.Active = m.activeLocked()
+	m.mu.Unlock()
+
+	for _, e := range events {
+		level := e.Severity.Level()
+		if e.Cleared {
+			level = slog.LevelInfo
+		}
+		m.logger().Log(ctx, level, e.Message,
+			"condition", string(e.Condition), "severity", e.Severity.String(), "cleared", e.Cleared)
+		if m.OnEvent != nil {
+			m.OnEvent(e)
+		}
+	}
+	return events
+}
+
+// evaluate derives the conditions from a successful poll
+func (m *Monitor) evaluate(h *client.HealthResponse, now time.Time, set func(Condition, bool, string), emit func(Event)) {
+	// m.status.Health is still the last successful poll
+	if prev := m.status.Health; client.Rebooted(prev, h) {
+		emit(Event{Time: now, Condition: Rebooted, Severity: Rebooted.Severity(),
+			Message: fmt.Sprintf("uptime went from %ds to %ds", prev.Uptime, h.Uptime)})
+	}
+
+	set(HopperLow, h.HopperLow(), "hopper low sensor asserted")
+	set(HopperEmpty, isEmpty(h), "hopper empty: dispense failed with the low sensor asserted")
+
+	jamMsg := "dispenser in error state"
+	if h.Error != nil && h.Error.Active {
+		jamMsg = fmt.Sprintf("dispenser in error state: code %d %s since %s", h.Error.Code, h.Error.Type,
+			m.timebase.Format(h.Error.Timestamp, "2006-01-02 15:04:05"))
+	}
+	set(Jammed, h.Dispenser == "error", jamMsg)
+
+	if want := m.Config.ExpectedFirmware; want != "" {
+		set(FirmwareMismatch, h.Firmware != want, fmt.Sprintf("firmware %s, expected %s", h.Firmware, want))
+	}
+}
+
+// isEmpty reports an empty hopper. The firmware has no empty sensor: an
+// empty hopper shows as a failed dispense while the low sensor is asserted,
+// or as a last error naming it.
+func isEmpty(h *client.HealthResponse) bool {
+	if strings.Contains(strings.ToLower(h.Metrics.LastErrorType+" "+h.Metrics.LastError), "empty") {
+		return true
+	}
+	return h.HopperLow() && h.Dispenser == "error"
+}
+
+func (m *Monitor) activeLocked() []Condition {
+	out := make([]Condition, 0, len(m.active))
+	for c := range m.active {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Severity() != out[b].Severity() {
+			return out[a].Severity() > out[b].Severity()
+		}
+		return out[a] < out[b]
+	})
+	return out
+}
+
+// Status returns the result of the latest poll
+func (m *Monitor) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.status
+	st.Active = append([]Condition{}, st.Active...)
+	return st
+}
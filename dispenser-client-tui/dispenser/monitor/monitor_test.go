@@ -0,0 +1,60 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+func TestCheckWaitsBetweenRetries(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first two attempts fail, the third answers
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(client.HealthResponse{Status: "ok", Uptime: 60, Dispenser: "idle"})
+	}))
+	defer srv.Close()
+
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := DefaultConfig().Wemos
+	m := &Monitor{
+		Client: client.NewDispenserClient(srv.URL, "", time.Second),
+		Config: cfg,
+		Clock:  clk,
+	}
+
+	done := make(chan []Event)
+	go func() { done <- m.Check(context.Background()) }()
+
+	for attempt := int32(1); attempt < 3; attempt++ {
+		clk.BlockUntil(1)
+		if got := requests.Load(); got != attempt {
+			t.Fatalf("%d requests before the retry delay passed, want %d", got, attempt)
+		}
+		clk.Advance(cfg.RetryDelay() - time.Millisecond)
+		if got := requests.Load(); got != attempt {
+			t.Fatalf("%d requests before the retry delay passed, want %d", got, attempt)
+		}
+		clk.Advance(time.Millisecond)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Check did not return")
+	}
+	if st := m.Status(); !st.Reachable || requests.Load() != 3 {
+		t.Errorf("reachable = %v after %d requests, want reachable after 3", st.Reachable, requests.Load())
+	}
+}
@@ -0,0 +1,389 @@
+// Package reconcile cross-checks what a client believes it dispensed, as
+// recorded in its transaction journal, against what the dispenser reports:
+// the deltas of the /health metrics since the last reconciliation and the
+// per-transaction status of the firmware's 8-entry history.
+//
+// The firmware counters restart at zero on every boot. A reconciliation
+// stores a Checkpoint of the counters and uptime; when the next run finds a
+// lower uptime the dispenser rebooted in between, and the window starts at
+// the estimated boot time instead.
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+// Discrepancy kinds
+const (
+	KindMissing     = "missing"     // the client recorded a dispense the dispenser does not know
+	KindMismatch    = "mismatch"    // state or count differs between client and dispenser
+	KindUnexplained = "unexplained" // the dispenser counted dispenses the client did not start
+	KindStale       = "stale"       // the journal lags the dispenser; run recover
+	KindLost        = "lost"        // unfinished before a reboot, outcome gone with the history
+	KindMetrics     = "metrics"     // counter deltas differ from the journal
+	KindInFlight    = "in_flight"   // a dispense is running, counts may be off by one
+	KindUnreachable = "unreachable" // a transaction status could not be fetched
+)
+
+// Checkpoint is the dispenser state at the end of a reconciliation
+type Checkpoint struct {
+	Time     time.Time      `json:"time"`
+	Uptime   int            `json:"uptime"` // seconds
+	Firmware string         `json:"firmware"`
+	Metrics  client.Metrics `json:"metrics"`
+}
+
+// BootTime estimates when the dispenser booted
+func (cp Checkpoint) BootTime() time.Time {
+	return cp.health().BootTime(cp.Time)
+}
+
+// health returns the fields of the checkpointed response that tell a
+// reboot
+func (cp Checkpoint) health() *client.HealthResponse {
+	return &client.HealthResponse{Uptime: cp.Uptime, Firmware: cp.Firmware}
+}
+
+// LoadCheckpoint reads a checkpoint file. A missing file returns nil and
+// no error: the first reconciliation covers everything since boot.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// SaveCheckpoint writes cp to path, replacing the file atomically
+func SaveCheckpoint(path string, cp Checkpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Counts are dispense counters comparable to the firmware metrics
+type Counts struct {
+	Total      int `json:"total_dispenses"`
+	Successful int `json:"successful"`
+	Partial    int `json:"partial"`
+	Failures   int `json:"failures"`
+	Unknown    int `json:"unknown"` // client only: outcome never observed
+}
+
+func metricsDelta(cur, prev client.Metrics) Counts {
+	return Counts{
+		Total:      cur.TotalDispenses - prev.TotalDispenses,
+		Successful: cur.Successful - prev.Successful,
+		Partial:    cur.Partial - prev.Partial,
+		Failures:   cur.Failures - prev.Failures,
+	}
+}
+
+// TxCheck compares one journaled transaction with the dispenser history
+type TxCheck struct {
+	TxID      string `json:"tx_id"`
+	Journal   string `json:"journal_state"`
+	Dispensed int    `json:"journal_dispensed"`
+	Quantity  int    `json:"quantity"`
+	// Firmware is the dispenser's state, empty if it does not know the tx
+	Firmware          string `json:"firmware_state,omitempty"`
+	FirmwareDispensed int    `json:"firmware_dispensed,omitempty"`
+	OK                bool   `json:"ok"`
+}
+
+// Discrepancy is one finding of a reconciliation
+type Discrepancy struct {
+	Kind    string `json:"kind"`
+	TxID    string `json:"tx_id,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is the result of a reconciliation
+type Report struct {
+	Time     time.Time   `json:"time"`
+	Previous *Checkpoint `json:"previous,omitempty"`
+	Current  Checkpoint  `json:"current"`
+	Rebooted bool        `json:"rebooted"`
+	// From is the start of the compared window: the previous checkpoint,
+	// or the estimated boot time after a reboot or on the first run
+	From time.Time `json:"from"`
+
+	Firmware Counts `json:"firmware"` // metric deltas over the window
+	Client   Counts `json:"client"`   // journaled dispenses over the window
+
+	Transactions  []TxCheck     `json:"transactions"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// OK reports whether nothing needs attention
+func (rep *Report) OK() bool {
+	return len(rep.Discrepancies) == 0
+}
+
+// Reconciler compares a journal with a dispenser
+type Reconciler struct {
+	// Client talks to the dispenser. It should not have the journal
+	// attached, so that reconciling never changes the journal.
+	Client  *client.DispenserClient
+	Journal *client.Journal
+	// Clock dates the checkpoint; nil means clock.Real
+	Clock clock.Clock
+}
+
+// Run reconciles the window since prev, which may be nil for the first run.
+// The returned report's Current is the checkpoint for the next run.
+func (rc *Reconciler) Run(ctx context.Context, prev *Checkpoint) (*Report, error) {
+	clk := rc.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+
+	h, result := rc.Client.Health(ctx)
+	if result.Error != nil {
+		return nil, fmt.Errorf("health: %w", result.Error)
+	}
+	rep := &Report{
+		Time:          clk.Now(),
+		Previous:      prev,
+		Discrepancies: []Discrepancy{},
+	}
+	rep.Current = Checkpoint{Time: rep.Time, Uptime: h.Uptime, Firmware: h.Firmware, Metrics: h.Metrics}
+	boot := rep.Current.BootTime()
+
+	switch {
+	case prev == nil:
+		rep.From = boot
+		rep.Firmware = metricsDelta(h.Metrics, client.Metrics{})
+	case client.Rebooted(prev.health(), h) || client.BootMoved(prev.BootTime(), boot):
+		// A boot time that moved later means a reboot even though uptime
+		// grew, e.g. after a reboot long ago
+		rep.Rebooted = true
+		rep.From = boot
+		rep.Firmware = metricsDelta(h.Metrics, client.Metrics{})
+	default:
+		rep.From = prev.Time
+		rep.Firmware = metricsDelta(h.Metrics, prev.Metrics)
+	}
+
+	records := rc.Journal.Records()
+	rep.Client = clientCounts(records, rep.From, prev != nil && !rep.Rebooted)
+	rep.compareCounts()
+	if h.ActiveTx != nil {
+		rep.add(KindInFlight, h.ActiveTx.TxID, "dispense in progress, counters may be off by one")
+	}
+
+	if err := rc.checkHistory(ctx, rep, records, boot); err != nil {
+		return rep, err
+	}
+	return rep, nil
+}
+
+func (rep *Report) add(kind, txID, format string, args ...any) {
+	rep.Discrepancies = append(rep.Discrepancies, Discrepancy{Kind: kind, TxID: txID, Message: fmt.Sprintf(format, args...)})
+}
+
+// clientCounts tallies the journaled dispenses that reached the dispenser
+// and last changed since from. A record at a checkpoint's time was counted
+// by the run that took it, so with checkpoint set from itself is left out.
+func clientCounts(records []client.JournalRecord, from time.Time, checkpoint bool) Counts {
+	var c Counts
+	for _, rec := range records {
+		if rec.Time.Before(from) || checkpoint && rec.Time.Equal(from) {
+			continue
+		}
+		switch rec.State {
+		case "done":
+			c.Total++
+			c.Successful++
+		case "error":
+			c.Total++
+			c.Failures++
+			if rec.Dispensed > 0 {
+				c.Partial++
+			}
+		case "dispensing", client.JournalUnknown, client.JournalReviewed:
+			c.Total++
+			c.Unknown++
+		}
+	}
+	return c
+}
+
+// compareCounts reports metric deltas the journal does not explain.
+// Transactions with an unknown outcome may account for either a success or
+// a failure.
+func (rep *Report) compareCounts() {
+	fw, cl := rep.Firmware, rep.Client
+	switch {
+	case fw.Total > cl.Total:
+		rep.add(KindUnexplained, "", "dispenser counted %d dispenses, the journal has %d: %d not started by this client",
+			fw.Total, cl.Total, fw.Total-cl.Total)
+	case fw.Total < cl.Total:
+		rep.add(KindMissing, "", "the journal has %d dispenses, the dispenser counted only %d",
+			cl.Total, fw.Total)
+	}
+
+	check := func(name string, fwN, clN int) {
+		if fwN < clN || fwN > clN+cl.Unknown {
+			rep.add(KindMetrics, "", "%s: dispenser %d, journal %d (+%d unknown)", name, fwN, clN, cl.Unknown)
+		}
+	}
+	if fw.Total == cl.Total {
+		check("successful", fw.Successful, cl.Successful)
+		check("failures", fw.Failures, cl.Failures)
+		check("partial", fw.Partial, cl.Partial)
+	}
+}
+
+// checkHistory compares the last HistorySize journaled transactions with
+// GET /dispense/{tx_id}. Transactions from before a reboot are gone from
+// the firmware's RAM, which only matters if they never finished.
+func (rc *Reconciler) checkHistory(ctx context.Context, rep *Report, records []client.JournalRecord, boot time.Time) error {
+	var recent []client.JournalRecord
+	for _, rec := range records {
+		if rec.State != client.JournalRejected {
+			recent = append(recent, rec)
+		}
+	}
+	sort.SliceStable(recent, func(a, b int) bool { return recent[a].Time.Before(recent[b].Time) })
+	if len(recent) > client.HistorySize {
+		recent = recent[len(recent)-client.HistorySize:]
+	}
+
+	rep.Transactions = []TxCheck{}
+	for _, rec := range recent {
+		chk := TxCheck{TxID: rec.TxID, Journal: rec.State, Dispensed: rec.Dispensed, Quantity: rec.Quantity}
+		resp, result := rc.Client.Status(ctx, rec.TxID)
+		switch {
+		case errors.Is(result.Error, client.ErrNotFound):
+			rc.notFound(rep, &chk, rec, boot)
+		case result.Error != nil:
+			if errors.Is(result.Error, client.ErrUnauthorized) || ctx.Err() != nil {
+				return result.Error
+			}
+			rep.add(KindUnreachable, rec.TxID, "status: %v", result.Error)
+		default:
+			chk.Firmware = resp.State
+			chk.FirmwareDispensed = resp.Dispensed
+			chk.OK = compareTx(rep, rec, resp)
+		}
+		rep.Transactions = append(rep.Transactions, chk)
+	}
+	return nil
+}
+
+func (rc *Reconciler) notFound(rep *Report, chk *TxCheck, rec client.JournalRecord, boot time.Time) {
+	switch {
+	case rec.State == client.JournalIntent:
+		// The POST may never have arrived; nothing dropped
+		chk.OK = true
+	case rec.Time.Before(boot) && !rec.Finished():
+		rep.add(KindLost, rec.TxID, "journal state %s, dispenser rebooted before it finished", rec.State)
+	case rec.Time.Before(boot), rec.State == client.JournalUnknown, rec.State == client.JournalReviewed:
+		chk.OK = true
+	default:
+		rep.add(KindMissing, rec.TxID, "journal state %s (%d/%d), not in the dispenser history",
+			rec.State, rec.Dispensed, rec.Quantity)
+	}
+}
+
+// compareTx reports differences between a journal record and the
+// dispenser's view of the same transaction
+func compareTx(rep *Report, rec client.JournalRecord, resp *client.DispenseResponse) bool {
+	switch {
+	case rec.State == resp.State && rec.Dispensed == resp.Dispensed:
+		return true
+	case !rec.Finished() || rec.State == client.JournalUnknown:
+		rep.add(KindStale, rec.TxID, "journal %s (%d/%d), dispenser %s (%d/%d)",
+			rec.State, rec.Dispensed, rec.Quantity, resp.State, resp.Dispensed, resp.Quantity)
+		return false
+	case rec.State == client.JournalReviewed:
+		return true
+	default:
+		rep.add(KindMismatch, rec.TxID, "journal %s (%d/%d), dispenser %s (%d/%d)",
+			rec.State, rec.Dispensed, rec.Quantity, resp.State, resp.Dispensed, resp.Quantity)
+		return false
+	}
+}
+
+// WriteText writes a human readable summary
+func (rep *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "window: %s - %s", rep.From.Format(time.RFC3339), rep.Time.Format(time.RFC3339))
+	switch {
+	case rep.Rebooted:
+		fmt.Fprintf(&b, " (dispenser rebooted, counters reset)")
+	case rep.Previous == nil:
+		fmt.Fprintf(&b, " (first run, since boot)")
+	}
+	fmt.Fprintf(&b, "\n\n%-12s %9s %9s\n", "", "dispenser", "journal")
+	fw, cl := rep.Firmware, rep.Client
+	for _, row := range []struct {
+		name   string
+		fw, cl int
+	}{
+		{"dispenses", fw.Total, cl.Total},
+		{"successful", fw.Successful, cl.Successful},
+		{"failures", fw.Failures, cl.Failures},
+		{"partial", fw.Partial, cl.Partial},
+	} {
+		fmt.Fprintf(&b, "%-12s %9d %9d\n", row.name, row.fw, row.cl)
+	}
+	if cl.Unknown > 0 {
+		fmt.Fprintf(&b, "%-12s %9s %9d\n", "unknown", "", cl.Unknown)
+	}
+
+	if len(rep.Transactions) > 0 {
+		fmt.Fprintf(&b, "\nlast %d transactions:\n", len(rep.Transactions))
+		for _, t := range rep.Transactions {
+			mark := "✓"
+			if !t.OK {
+				mark = "✗"
+			}
+			fw := "not in history"
+			if t.Firmware != "" {
+				fw = fmt.Sprintf("%s %d/%d", t.Firmware, t.FirmwareDispensed, t.Quantity)
+			}
+			fmt.Fprintf(&b, "  %s %-16s journal %s %d/%d, dispenser %s\n", mark, t.TxID, t.Journal, t.Dispensed, t.Quantity, fw)
+		}
+	}
+
+	if rep.OK() {
+		fmt.Fprintf(&b, "\nno discrepancies\n")
+	} else {
+		fmt.Fprintf(&b, "\ndiscrepancies (%d):\n", len(rep.Discrepancies))
+		for _, d := range rep.Discrepancies {
+			if d.TxID != "" {
+				fmt.Fprintf(&b, "  %-11s tx %s: %s\n", d.Kind, d.TxID, d.Message)
+			} else {
+				fmt.Fprintf(&b, "  %-11s %s\n", d.Kind, d.Message)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
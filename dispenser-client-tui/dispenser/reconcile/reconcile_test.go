@@ -0,0 +1,223 @@
+package reconcile
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/sim"
+)
+
+type testDispenser struct {
+	srv *sim.Server
+	clk *clock.Fake
+	// pos dispenses and journals; rc reconciles without touching the journal
+	pos *client.DispenserClient
+	rc  *Reconciler
+}
+
+// newTestDispenser returns a simulator on a fake clock and a journaling
+// client for it
+func newTestDispenser(t *testing.T) *testDispenser {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := sim.DefaultConfig()
+	cfg.Clock = clk
+	srv := sim.NewServer(cfg)
+	t.Cleanup(srv.Close)
+
+	j, err := client.OpenJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	j.Clock = clk
+	pos := srv.DispenserClient()
+	pos.Journal = j
+	return &testDispenser{srv: srv, clk: clk, pos: pos,
+		rc: &Reconciler{Client: srv.DispenserClient(), Journal: j, Clock: clk}}
+}
+
+// start posts a dispense without following it
+func (d *testDispenser) start(t *testing.T, c *client.DispenserClient, txID string, quantity int) {
+	t.Helper()
+	if _, result := c.Dispense(context.Background(), txID, quantity); result.Error != nil {
+		t.Fatalf("dispense %s: %v", txID, result.Error)
+	}
+}
+
+// wait advances the clock until txID finished, following it with c
+func (d *testDispenser) wait(t *testing.T, c *client.DispenserClient, txID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, result := c.Status(context.Background(), txID)
+		if result.Error != nil {
+			t.Fatalf("status %s: %v", txID, result.Error)
+		}
+		if !resp.InProgress() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s still %s", txID, resp.State)
+		}
+		d.clk.Advance(100 * time.Millisecond)
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+// dispense runs a journaled dispense to its end
+func (d *testDispenser) dispense(t *testing.T, txID string, quantity int) {
+	t.Helper()
+	d.start(t, d.pos, txID, quantity)
+	d.wait(t, d.pos, txID)
+}
+
+func (d *testDispenser) run(t *testing.T, prev *Checkpoint) *Report {
+	t.Helper()
+	rep, err := d.rc.Run(context.Background(), prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+// kinds returns the discrepancy kinds of rep, keyed by tx_id ("" for the
+// counts)
+func kinds(rep *Report) map[string]string {
+	out := map[string]string{}
+	for _, d := range rep.Discrepancies {
+		out[d.TxID] = d.Kind
+	}
+	return out
+}
+
+// checked returns whether each transaction matched, keyed by tx_id
+func checked(rep *Report) map[string]bool {
+	out := map[string]bool{}
+	for _, tx := range rep.Transactions {
+		out[tx.TxID] = tx.OK
+	}
+	return out
+}
+
+func TestMatchingJournal(t *testing.T) {
+	d := newTestDispenser(t)
+	d.dispense(t, "00000001", 2)
+	d.dispense(t, "00000002", 1)
+
+	rep := d.run(t, nil)
+	if !rep.OK() {
+		t.Fatalf("discrepancies %+v", rep.Discrepancies)
+	}
+	want := Counts{Total: 2, Successful: 2}
+	if rep.Firmware != want || rep.Client != want {
+		t.Errorf("dispenser %+v, journal %+v, want %+v", rep.Firmware, rep.Client, want)
+	}
+	if got := checked(rep); len(got) != 2 || !got["00000001"] || !got["00000002"] {
+		t.Errorf("transactions %+v", rep.Transactions)
+	}
+
+	// The next run covers the window since the checkpoint only
+	d.clk.Advance(time.Minute)
+	d.dispense(t, "00000003", 3)
+	next := d.run(t, &rep.Current)
+	want = Counts{Total: 1, Successful: 1}
+	if !next.OK() || next.Rebooted || next.Firmware != want || next.Client != want {
+		t.Errorf("second run: dispenser %+v, journal %+v, discrepancies %+v", next.Firmware, next.Client, next.Discrepancies)
+	}
+	if !next.From.Equal(rep.Time) {
+		t.Errorf("window from %s, want the checkpoint %s", next.From, rep.Time)
+	}
+}
+
+func TestMissingTransaction(t *testing.T) {
+	d := newTestDispenser(t)
+	d.dispense(t, "00000001", 1)
+	// Journaled as done on a dispenser that never saw it, e.g. a client
+	// pointed at the wrong unit for a while
+	if err := d.pos.Journal.Intent("00000002", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.pos.Journal.Observe(client.DispenseResponse{TxID: "00000002", State: "done", Quantity: 1, Dispensed: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	rep := d.run(t, nil)
+	if got := kinds(rep); len(got) != 2 || got[""] != KindMissing || got["00000002"] != KindMissing {
+		t.Errorf("discrepancies %+v, want the count and 00000002 missing", rep.Discrepancies)
+	}
+	if rep.Firmware.Total != 1 || rep.Client.Total != 2 {
+		t.Errorf("dispenser %+v, journal %+v", rep.Firmware, rep.Client)
+	}
+	if got := checked(rep); !got["00000001"] || got["00000002"] {
+		t.Errorf("transactions %+v", rep.Transactions)
+	}
+}
+
+func TestExtraTransaction(t *testing.T) {
+	d := newTestDispenser(t)
+	d.dispense(t, "00000001", 1)
+	// Another client dispensed without this journal
+	other := d.srv.DispenserClient()
+	d.start(t, other, "00000002", 2)
+	d.wait(t, other, "00000002")
+
+	rep := d.run(t, nil)
+	if got := kinds(rep); len(got) != 1 || got[""] != KindUnexplained {
+		t.Errorf("discrepancies %+v, want one unexplained dispense", rep.Discrepancies)
+	}
+	if rep.Firmware.Total != 2 || rep.Client.Total != 1 || len(rep.Transactions) != 1 {
+		t.Errorf("dispenser %+v, journal %+v, %d transactions", rep.Firmware, rep.Client, len(rep.Transactions))
+	}
+}
+
+func TestStaleJournal(t *testing.T) {
+	d := newTestDispenser(t)
+	// The client crashed after the POST: the journal stops at dispensing
+	d.start(t, d.pos, "00000001", 2)
+	d.wait(t, d.rc.Client, "00000001")
+
+	rep := d.run(t, nil)
+	if got := kinds(rep); len(got) != 1 || got["00000001"] != KindStale {
+		t.Errorf("discrepancies %+v, want 00000001 stale", rep.Discrepancies)
+	}
+	// The unknown outcome may be the dispenser's success
+	if rep.Client != (Counts{Total: 1, Unknown: 1}) || rep.Firmware.Successful != 1 {
+		t.Errorf("dispenser %+v, journal %+v", rep.Firmware, rep.Client)
+	}
+}
+
+func TestAcrossReboot(t *testing.T) {
+	d := newTestDispenser(t)
+	d.dispense(t, "00000001", 1)
+	// Finished while the client was gone, then lost with the history
+	d.start(t, d.pos, "00000002", 1)
+	d.wait(t, d.rc.Client, "00000002")
+	rep := d.run(t, nil)
+
+	d.clk.Advance(time.Minute)
+	d.srv.Sim.Reboot()
+	d.clk.Advance(time.Minute)
+	d.dispense(t, "00000003", 2)
+
+	next := d.run(t, &rep.Current)
+	if !next.Rebooted || !next.From.Equal(next.Current.BootTime()) {
+		t.Errorf("rebooted %v, window from %s, want the boot at %s", next.Rebooted, next.From, next.Current.BootTime())
+	}
+	// Only the dispense since the boot is counted on either side
+	want := Counts{Total: 1, Successful: 1}
+	if next.Firmware != want || next.Client != want {
+		t.Errorf("dispenser %+v, journal %+v, want %+v", next.Firmware, next.Client, want)
+	}
+	if got := kinds(next); len(got) != 1 || got["00000002"] != KindLost {
+		t.Errorf("discrepancies %+v, want 00000002 lost", next.Discrepancies)
+	}
+	// A finished transaction gone with the history is no discrepancy
+	if got := checked(next); len(got) != 3 || !got["00000001"] || got["00000002"] || !got["00000003"] {
+		t.Errorf("transactions %+v", next.Transactions)
+	}
+}
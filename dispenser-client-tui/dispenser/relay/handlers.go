@@ -0,0 +1,138 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+// heartbeat is the interval of SSE comments that keep idle connections
+// open through proxies
+const heartbeat = 15 * time.Second
+
+// Handler returns the relay API:
+//
+//	GET /events               Server-Sent Events, all event types
+//	GET /events?tx_id=ID      dispense events of one transaction, ending
+//	                          with its final state
+//	GET /health               relay status
+//
+// Clients authenticate with apiKey in X-API-Key or, for browser
+// EventSource which cannot set headers, in the key query parameter; an
+// empty apiKey refuses everything but /health. A Last-Event-ID header
+// resumes a stream from the backlog.
+func (r *Relay) Handler(apiKey string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", r.handleEvents)
+	mux.HandleFunc("GET /health", r.handleHealth)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("X-API-Key")
+		if key == "" {
+			key = req.URL.Query().Get("key")
+		}
+		if req.URL.Path != "/health" && (apiKey == "" || key != apiKey) {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// Status is GET /health of the relay
+type Status struct {
+	Reachable   bool                   `json:"reachable"`
+	Subscribers int                    `json:"subscribers"`
+	Watching    int                    `json:"watching"`
+	LastEventID uint64                 `json:"last_event_id"`
+	Dispenser   *client.HealthResponse `json:"dispenser,omitempty"`
+}
+
+func (r *Relay) handleHealth(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	st := Status{
+		Reachable:   r.reachable,
+		Subscribers: len(r.subs),
+		Watching:    len(r.watch),
+		LastEventID: r.nextID,
+		Dispenser:   r.health,
+	}
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func (r *Relay) handleEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	var lastID uint64
+	if v := req.Header.Get("Last-Event-ID"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+	txID := req.URL.Query().Get("tx_id")
+
+	replay, sub := r.subscribe(txID, lastID)
+	if sub != nil {
+		defer r.unsubscribe(sub)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, ev := range replay {
+		if writeEvent(w, ev) != nil || (txID != "" && !ev.Dispense.InProgress()) {
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+	if sub == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case ev, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+			if txID != "" && !ev.Dispense.InProgress() {
+				return
+			}
+		case <-r.clock().After(heartbeat):
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes one event in text/event-stream framing
+func writeEvent(w http.ResponseWriter, ev client.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(client.ErrorResponse{Error: msg})
+}
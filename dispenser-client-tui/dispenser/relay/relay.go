@@ -0,0 +1,406 @@
+// Package relay pushes dispenser changes to many clients. The firmware only
+// answers polls, so every client watching a dispense polls GET
+// /dispense/{tx_id} on its own and the ESP8266 (Wemos D1) serves the sum of
+// them. A Relay polls the dispenser once and streams what changed,
+// dispensed tokens, transaction states, health and new error_history
+// entries, as Server-Sent Events that client.Subscribe consumes.
+package relay
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/logging"
+)
+
+const (
+	// DefaultInterval between health polls while nothing is dispensing
+	DefaultInterval = time.Second
+	// DefaultActiveInterval between polls while a transaction runs
+	DefaultActiveInterval = 100 * time.Millisecond
+	// DefaultBacklog is the number of events kept for resuming clients
+	DefaultBacklog = 256
+
+	// healthRefresh republishes unchanged health so that subscribers see
+	// uptime and RSSI move
+	healthRefresh = 30 * time.Second
+	// rssiStep is the RSSI change in dBm that counts as a health change
+	rssiStep = 5
+	// maxMisses of 404s before a watched transaction is given up on
+	maxMisses = 8
+	// finalKept is the number of finished transactions whose final state
+	// is served to late subscribers
+	finalKept = 64
+	// subscriberBuffer events may wait for a slow subscriber before it is
+	// disconnected; it resumes from the backlog
+	subscriberBuffer = 64
+)
+
+// Relay polls one dispenser and fans its changes out to subscribers. Run
+// must be running for events to be published.
+type Relay struct {
+	Client *client.DispenserClient
+	// Interval and ActiveInterval default to DefaultInterval and
+	// DefaultActiveInterval
+	Interval       time.Duration
+	ActiveInterval time.Duration
+	// Backlog defaults to DefaultBacklog
+	Backlog int
+	// Logger reports reachability changes; nil discards
+	Logger *slog.Logger
+	// Clock stamps events; nil means clock.Real
+	Clock clock.Clock
+
+	mu         sync.Mutex
+	nextID     uint64
+	backlog    []client.Event
+	subs       map[*subscriber]struct{}
+	watch      map[string]int // tx_id -> 404s so far
+	sent       map[string]client.DispenseResponse
+	final      map[string]client.DispenseResponse
+	finalOrder []string
+	health     *client.HealthResponse
+	healthAt   time.Time
+	polled     bool
+	reachable  bool
+	errSeen    map[errorKey]bool
+	wake       chan struct{}
+}
+
+type subscriber struct {
+	ch   chan client.Event
+	txID string
+}
+
+// errorKey identifies an error_history entry within one boot
+type errorKey struct {
+	code      int
+	timestamp int64
+}
+
+// New returns a relay for c
+func New(c *client.DispenserClient) *Relay {
+	r := &Relay{Client: c}
+	r.init()
+	return r
+}
+
+func (r *Relay) init() {
+	if r.subs == nil {
+		r.subs = make(map[*subscriber]struct{})
+		r.watch = make(map[string]int)
+		r.sent = make(map[string]client.DispenseResponse)
+		r.final = make(map[string]client.DispenseResponse)
+		r.errSeen = make(map[errorKey]bool)
+		r.wake = make(chan struct{}, 1)
+	}
+}
+
+func (r *Relay) clock() clock.Clock {
+	if r.Clock == nil {
+		return clock.Real
+	}
+	return r.Clock
+}
+
+func (r *Relay) logger() *slog.Logger {
+	return logging.OrDiscard(r.Logger)
+}
+
+// Run polls the dispenser until ctx ends
+func (r *Relay) Run(ctx context.Context) error {
+	r.mu.Lock()
+	r.init()
+	r.mu.Unlock()
+
+	for {
+		r.poll(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.wake:
+		case <-r.clock().After(r.interval()):
+		}
+	}
+}
+
+// interval is ActiveInterval while a transaction runs or is watched
+func (r *Relay) interval() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.watch) > 0 || (r.health != nil && r.health.ActiveTx != nil) {
+		if r.ActiveInterval > 0 {
+			return r.ActiveInterval
+		}
+		return DefaultActiveInterval
+	}
+	if r.Interval > 0 {
+		return r.Interval
+	}
+	return DefaultInterval
+}
+
+// poll fetches health, and the state of watched transactions the health
+// does not cover, and publishes what changed
+func (r *Relay) poll(ctx context.Context) {
+	h, result := r.Client.Health(ctx)
+	if result.Error != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		r.mu.Lock()
+		if r.reachable || !r.polled {
+			r.logger().Warn("dispenser unreachable", "err", result.Error)
+			r.publishLocked(client.Event{Type: client.EventHealth, Message: result.Error.Error()})
+		}
+		r.polled, r.reachable = true, false
+		r.mu.Unlock()
+		return
+	}
+
+	r.mu.Lock()
+	r.observeHealthLocked(h)
+	var pending []string
+	for txID := range r.watch {
+		if h.ActiveTx == nil || h.ActiveTx.TxID != txID {
+			pending = append(pending, txID)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, txID := range pending {
+		resp, result := r.Client.Status(ctx, txID)
+		r.mu.Lock()
+		var apiErr *client.APIError
+		switch {
+		case result.Error == nil:
+			r.observeDispenseLocked(*resp)
+		case errors.As(result.Error, &apiErr) && apiErr.StatusCode == 404:
+			if r.watch[txID]++; r.watch[txID] >= maxMisses {
+				r.forgetLocked(txID)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// observeHealthLocked publishes health, error and progress changes
+func (r *Relay) observeHealthLocked(h *client.HealthResponse) {
+	now := r.clock().Now()
+	prev := r.health
+	rebooted := client.Rebooted(prev, h)
+	if !r.reachable {
+		r.logger().Info("dispenser reachable", "uptime", h.Uptime, "firmware", h.Firmware)
+	}
+	if !r.reachable || prev == nil || rebooted || healthChanged(prev, h) || now.Sub(r.healthAt) >= healthRefresh {
+		r.publishLocked(client.Event{Type: client.EventHealth, Health: h})
+		r.healthAt = now
+	}
+
+	// error_history timestamps restart with the uptime, and the history
+	// present at the first poll is old news
+	if rebooted {
+		r.errSeen = make(map[errorKey]bool)
+	}
+	for i := range h.ErrorHistory {
+		rec := h.ErrorHistory[i]
+		key := errorKey{rec.Code, rec.Timestamp}
+		if r.errSeen[key] {
+			continue
+		}
+		r.errSeen[key] = true
+		if prev != nil {
+			r.publishLocked(client.Event{Type: client.EventError, Error: &rec})
+		}
+	}
+
+	if a := h.ActiveTx; a != nil {
+		if _, ok := r.watch[a.TxID]; !ok {
+			r.watch[a.TxID] = 0
+		}
+		r.observeDispenseLocked(client.DispenseResponse{
+			TxID: a.TxID, State: "dispensing", Quantity: a.Quantity, Dispensed: a.Dispensed,
+		})
+	}
+
+	r.health = h
+	r.polled, r.reachable = true, true
+}
+
+// observeDispenseLocked publishes a transaction state if it changed and
+// stops watching it once final
+func (r *Relay) observeDispenseLocked(resp client.DispenseResponse) {
+	if prev, ok := r.sent[resp.TxID]; !ok || prev != resp {
+		r.publishLocked(client.Event{Type: client.EventDispense, Dispense: &resp})
+		r.sent[resp.TxID] = resp
+	}
+	if resp.InProgress() {
+		r.watch[resp.TxID] = 0
+		return
+	}
+	delete(r.watch, resp.TxID)
+	delete(r.sent, resp.TxID)
+	if _, ok := r.final[resp.TxID]; !ok {
+		r.finalOrder = append(r.finalOrder, resp.TxID)
+	}
+	r.final[resp.TxID] = resp
+	for len(r.finalOrder) > finalKept {
+		delete(r.final, r.finalOrder[0])
+		r.finalOrder = r.finalOrder[1:]
+	}
+}
+
+// forgetLocked stops watching a transaction the dispenser does not know
+// and disconnects its subscribers, which then fall back to asking the
+// dispenser themselves
+func (r *Relay) forgetLocked(txID string) {
+	r.logger().Warn("transaction not found, no longer watched", "tx_id", txID)
+	delete(r.watch, txID)
+	delete(r.sent, txID)
+	for sub := range r.subs {
+		if sub.txID == txID {
+			close(sub.ch)
+			delete(r.subs, sub)
+		}
+	}
+}
+
+// healthChanged reports a change worth an event. Uptime always moves and
+// RSSI jitters, so they only count past rssiStep or through healthRefresh.
+func healthChanged(a, b *client.HealthResponse) bool {
+	switch {
+	case a.Status != b.Status, a.Dispenser != b.Dispenser, a.Firmware != b.Firmware,
+		a.Metrics != b.Metrics, len(a.ErrorHistory) != len(b.ErrorHistory),
+		(a.ActiveTx == nil) != (b.ActiveTx == nil):
+		return true
+	case (a.GPIO == nil) != (b.GPIO == nil), a.GPIO != nil && *a.GPIO != *b.GPIO:
+		return true
+	case (a.Error == nil) != (b.Error == nil), a.Error != nil && *a.Error != *b.Error:
+		return true
+	case (a.WiFi == nil) != (b.WiFi == nil):
+		return true
+	case a.WiFi != nil && (a.WiFi.IP != b.WiFi.IP || a.WiFi.SSID != b.WiFi.SSID):
+		return true
+	case a.WiFi != nil && abs(a.WiFi.RSSI-b.WiFi.RSSI) >= rssiStep:
+		return true
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// publishLocked numbers ev, keeps it in the backlog and hands it to the
+// subscribers. A subscriber whose buffer is full is disconnected rather
+// than slowing down the others; it resumes from the backlog.
+func (r *Relay) publishLocked(ev client.Event) {
+	r.nextID++
+	ev.ID = r.nextID
+	ev.Time = r.clock().Now()
+	r.backlog = append(r.backlog, ev)
+	limit := r.Backlog
+	if limit <= 0 {
+		limit = DefaultBacklog
+	}
+	if len(r.backlog) > limit {
+		r.backlog = append(r.backlog[:0], r.backlog[len(r.backlog)-limit:]...)
+	}
+
+	for sub := range r.subs {
+		if !sub.wants(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			close(sub.ch)
+			delete(r.subs, sub)
+		}
+	}
+}
+
+// wants filters a transaction subscription to its dispense events
+func (s *subscriber) wants(ev client.Event) bool {
+	if s.txID == "" {
+		return true
+	}
+	return ev.Dispense != nil && ev.Dispense.TxID == s.txID
+}
+
+// subscribe registers a subscriber and returns the backlog events after
+// lastID it missed. A new subscriber (lastID 0) gets the latest health
+// instead, or the backlog of its transaction. For a transaction that
+// already finished, the replay ends with its final state. An unfinished
+// transaction is watched until final, even if it has not started yet.
+func (r *Relay) subscribe(txID string, lastID uint64) ([]client.Event, *subscriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+
+	sub := &subscriber{ch: make(chan client.Event, subscriberBuffer), txID: txID}
+	var replay []client.Event
+	if lastID == 0 && txID == "" {
+		for i := len(r.backlog) - 1; i >= 0; i-- {
+			if r.backlog[i].Type == client.EventHealth {
+				replay = append(replay, r.backlog[i])
+				break
+			}
+		}
+	} else {
+		for _, ev := range r.backlog {
+			if ev.ID > lastID && sub.wants(ev) {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	if txID == "" {
+		r.subs[sub] = struct{}{}
+		return replay, sub
+	}
+
+	if resp, ok := r.final[txID]; ok {
+		if n := len(replay); n == 0 || replay[n-1].Dispense.InProgress() {
+			replay = append(replay, client.Event{
+				ID: r.nextID, Type: client.EventDispense, Time: r.clock().Now(), Dispense: &resp,
+			})
+		}
+		return replay, nil
+	}
+	if _, ok := r.watch[txID]; !ok {
+		r.watch[txID] = 0
+	}
+	r.subs[sub] = struct{}{}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return replay, sub
+}
+
+func (r *Relay) unsubscribe(sub *subscriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subs[sub]; ok {
+		delete(r.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscribers returns the number of connected subscribers
+func (r *Relay) Subscribers() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.subs)
+}
@@ -0,0 +1,299 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/sim"
+)
+
+const relayKey = "relay-key"
+
+// newTestRelay serves a relay for a simulator on a fake clock. Run is not
+// started.
+func newTestRelay(t *testing.T) (*sim.Server, *Relay, *clock.Fake, *httptest.Server) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := sim.DefaultConfig()
+	cfg.Clock = clk
+	srv := sim.NewServer(cfg)
+	t.Cleanup(srv.Close)
+
+	r := New(srv.DispenserClient())
+	r.Clock = clk
+	front := httptest.NewServer(r.Handler(relayKey))
+	t.Cleanup(front.Close)
+	return srv, r, clk, front
+}
+
+// run polls until the test ends while virtual time runs 250x faster than
+// real time
+func run(t *testing.T, r *Relay, clk *clock.Fake) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+	go func() {
+		for ctx.Err() == nil {
+			clk.Advance(50 * time.Millisecond)
+			time.Sleep(200 * time.Microsecond)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// subscribe connects a subscriber until the test ends
+func subscribe(t *testing.T, front *httptest.Server, opts client.SubscribeOptions) <-chan client.Event {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	c := client.NewDispenserClient(front.URL, relayKey, time.Second)
+	events, err := c.Subscribe(ctx, front.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// next returns the next event matching keep
+func next(t *testing.T, events <-chan client.Event, keep func(client.Event) bool) client.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if keep(ev) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
+// dispenses collects the dispense events of txID up to its final state,
+// as "id state dispensed"
+func dispenses(t *testing.T, events <-chan client.Event, txID string) []string {
+	t.Helper()
+	var got []string
+	for {
+		ev := next(t, events, func(ev client.Event) bool { return ev.Dispense != nil && ev.Dispense.TxID == txID })
+		got = append(got, fmt.Sprintf("%d %s %d", ev.ID, ev.Dispense.State, ev.Dispense.Dispensed))
+		if !ev.Dispense.InProgress() {
+			return got
+		}
+	}
+}
+
+// publish adds health events carrying msg to the backlog
+func publish(r *Relay, msgs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.publishLocked(client.Event{Type: client.EventHealth, Message: msg})
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	srv, r, clk, front := newTestRelay(t)
+	run(t, r, clk)
+	a := subscribe(t, front, client.SubscribeOptions{})
+	b := subscribe(t, front, client.SubscribeOptions{})
+	tx := subscribe(t, front, client.SubscribeOptions{TxID: "a1b2c3d4"})
+
+	if _, result := srv.DispenserClient().Dispense(context.Background(), "a1b2c3d4", 3); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	// Every subscriber sees the same events, one per token
+	want := strings.Join(dispenses(t, a, "a1b2c3d4"), ", ")
+	for name, events := range map[string]<-chan client.Event{"b": b, "tx_id": tx} {
+		if got := strings.Join(dispenses(t, events, "a1b2c3d4"), ", "); got != want {
+			t.Errorf("subscriber %s got %s, want %s", name, got, want)
+		}
+	}
+	var states []string
+	for _, ev := range strings.Split(want, ", ") {
+		states = append(states, ev[strings.Index(ev, " ")+1:])
+	}
+	if got := strings.Join(states, ", "); got != "dispensing 0, dispensing 1, dispensing 2, done 3" {
+		t.Errorf("events %s, want every token", got)
+	}
+	// The transaction subscription ends with the final state
+	if _, ok := <-tx; ok {
+		t.Error("tx_id stream still open after the final state")
+	}
+}
+
+func TestFinalStateReplay(t *testing.T) {
+	srv, r, clk, front := newTestRelay(t)
+	r.Backlog = 8
+	run(t, r, clk)
+
+	// Finished before anyone subscribed to it
+	watcher := subscribe(t, front, client.SubscribeOptions{})
+	if _, result := srv.DispenserClient().Dispense(context.Background(), "a1b2c3d4", 2); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	want := dispenses(t, watcher, "a1b2c3d4")
+
+	// Still in the backlog: the whole transaction is replayed
+	late := subscribe(t, front, client.SubscribeOptions{TxID: "a1b2c3d4"})
+	if got := strings.Join(dispenses(t, late, "a1b2c3d4"), ", "); got != strings.Join(want, ", ") {
+		t.Errorf("late subscriber got %s, want %s", got, strings.Join(want, ", "))
+	}
+	if _, ok := <-late; ok {
+		t.Error("stream of a finished tx still open after its final state")
+	}
+
+	// Pushed out of the backlog: only the final state
+	publish(r, "1", "2", "3", "4", "5", "6", "7", "8")
+	later := subscribe(t, front, client.SubscribeOptions{TxID: "a1b2c3d4"})
+	if got := dispenses(t, later, "a1b2c3d4"); len(got) != 1 || !strings.HasSuffix(got[0], " done 2") {
+		t.Errorf("subscriber after the backlog got %v, want done 2", got)
+	}
+	if _, ok := <-later; ok {
+		t.Error("stream of a finished tx still open after its final state")
+	}
+}
+
+func TestLastEventIDResume(t *testing.T) {
+	_, r, _, front := newTestRelay(t)
+	publish(r, "one", "two", "three", "four", "five")
+
+	req, err := http.NewRequest(http.MethodGet, front.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", relayKey)
+	req.Header.Set("Last-Event-ID", "3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var ids []string
+	sc := bufio.NewScanner(resp.Body)
+	for len(ids) < 2 && sc.Scan() {
+		if id, ok := strings.CutPrefix(sc.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	if got := strings.Join(ids, ","); got != "4,5" {
+		t.Errorf("resumed after 3 with events %s, want 4,5", got)
+	}
+
+	// A new subscriber only gets the latest health, not the backlog
+	events := subscribe(t, front, client.SubscribeOptions{})
+	if ev := next(t, events, func(client.Event) bool { return true }); ev.ID != 5 {
+		t.Errorf("new subscriber started with event %d, want 5", ev.ID)
+	}
+}
+
+func TestSlowSubscriberDisconnected(t *testing.T) {
+	_, r, _, _ := newTestRelay(t)
+	publish(r, "before")
+
+	replay, sub := r.subscribe("", 0)
+	fast, fastSub := r.subscribe("", 0)
+	if len(replay) != 1 || len(fast) != 1 || r.Subscribers() != 2 {
+		t.Fatalf("replay %v, %d subscribers", replay, r.Subscribers())
+	}
+
+	// The fast one keeps reading, the slow one never does
+	var read []uint64
+	for i := 0; i < subscriberBuffer+1; i++ {
+		publish(r, fmt.Sprint(i))
+		read = append(read, (<-fastSub.ch).ID)
+	}
+	if r.Subscribers() != 1 {
+		t.Fatalf("%d subscribers, want the slow one disconnected", r.Subscribers())
+	}
+	var got int
+	for range sub.ch {
+		got++
+	}
+	if got != subscriberBuffer {
+		t.Errorf("slow subscriber got %d events before the disconnect, want %d", got, subscriberBuffer)
+	}
+
+	// It resumes from the backlog where its buffer ended
+	resumed, sub := r.subscribe("", read[subscriberBuffer-1])
+	if len(resumed) != 1 || resumed[0].ID != read[subscriberBuffer] {
+		t.Errorf("resumed with %v, want event %d", resumed, read[subscriberBuffer])
+	}
+	r.unsubscribe(sub)
+	r.unsubscribe(fastSub)
+}
+
+func TestSubscribeReconnects(t *testing.T) {
+	_, r, _, front := newTestRelay(t)
+	publish(r, "one", "two", "three")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := client.NewDispenserClient(front.URL, relayKey, time.Second)
+	events, err := c.Subscribe(ctx, front.URL, client.SubscribeOptions{RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(t, events, func(client.Event) bool { return true }); ev.ID != 3 {
+		t.Fatalf("first event %d, want 3", ev.ID)
+	}
+
+	// The connection drops; what happens meanwhile is replayed
+	front.CloseClientConnections()
+	next(t, events, func(ev client.Event) bool { return ev.Type == client.EventDisconnected })
+	publish(r, "four", "five")
+	next(t, events, func(ev client.Event) bool { return ev.Type == client.EventConnected })
+	for _, want := range []uint64{4, 5} {
+		if ev := next(t, events, func(client.Event) bool { return true }); ev.ID != want || ev.Type != client.EventHealth {
+			t.Errorf("after reconnecting: %s %d, want health %d", ev.Type, ev.ID, want)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestHandlerAuth(t *testing.T) {
+	_, r, _, _ := newTestRelay(t)
+	for _, tc := range []struct {
+		handlerKey, clientKey string
+	}{
+		{relayKey, ""},
+		{relayKey, "wrong-key"},
+		{"", ""}, // no key configured refuses everyone
+	} {
+		front := httptest.NewServer(r.Handler(tc.handlerKey))
+		ctx, cancel := context.WithCancel(context.Background())
+		c := client.NewDispenserClient(front.URL, tc.clientKey, time.Second)
+		if _, err := c.Subscribe(ctx, front.URL, client.SubscribeOptions{}); err == nil {
+			t.Errorf("relay key %q, client key %q: subscribed, want 401", tc.handlerKey, tc.clientKey)
+		}
+		cancel()
+		resp, err := http.Get(front.URL + "/health")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("relay key %q: GET /health %v, want no auth", tc.handlerKey, err)
+		}
+		resp.Body.Close()
+		front.Close()
+	}
+}
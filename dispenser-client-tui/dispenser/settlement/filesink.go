@@ -0,0 +1,111 @@
+package settlement
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FileSink is a PaymentSink that appends payments to a JSON Lines file.
+// It is meant for testing and for terminals without a payment backend: the
+// file is replayed on open, so the one-payment-per-tx guarantee holds
+// across restarts.
+type FileSink struct {
+	mu       sync.Mutex
+	f        *os.File
+	payments map[string]Payment // by dispense tx_id
+}
+
+// OpenFileSink opens or creates the payment file at path. As with the
+// transaction journal, a torn last line from a crash is cut off.
+func OpenFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileSink{f: f, payments: make(map[string]Payment)}
+	var complete int64 // length up to the last newline
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil {
+			if err != io.EOF {
+				f.Close()
+				return nil, fmt.Errorf("reading payments %s: %w", path, err)
+			}
+			break
+		}
+		complete += int64(len(line))
+
+		var p Payment
+		if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &p) != nil {
+			continue
+		}
+		if _, dup := s.payments[p.DispenseTxID]; !dup {
+			s.payments[p.DispenseTxID] = p
+		}
+	}
+
+	if err := f.Truncate(complete); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("repairing payments %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Close closes the payment file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// Record appends p unless its tx is already paid. It returns once the
+// payment is on stable storage.
+func (s *FileSink) Record(ctx context.Context, p Payment) (Payment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.payments[p.DispenseTxID]; ok {
+		return existing, false, nil
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return Payment{}, false, err
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		return Payment{}, false, fmt.Errorf("payment write: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return Payment{}, false, fmt.Errorf("payment sync: %w", err)
+	}
+	s.payments[p.DispenseTxID] = p
+	return p, true, nil
+}
+
+// Lookup returns the payment for a dispense tx_id
+func (s *FileSink) Lookup(ctx context.Context, dispenseTxID string) (Payment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.payments[dispenseTxID]
+	return p, ok, nil
+}
+
+// Payments returns all recorded payments, oldest first
+func (s *FileSink) Payments() []Payment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Payment, 0, len(s.payments))
+	for _, p := range s.payments {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Timestamp.Before(out[b].Timestamp) })
+	return out
+}
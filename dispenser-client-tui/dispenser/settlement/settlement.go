@@ -0,0 +1,168 @@
+// Package settlement turns finished dispense transactions into payments,
+// following the dispense-first, pay-after model of ARCHITECTURE.md: the
+// customer is charged only for tokens that actually dropped. A partial
+// dispense of 2 of 3 tokens is charged for 2, a jam before the first token
+// is not charged at all, and a transaction whose outcome is unknown is never
+// charged automatically.
+//
+// Every payment is keyed by the dispense tx_id it settles. A PaymentSink
+// records at most one payment per tx_id, so settling the same transaction
+// again after a retry or a restart returns the existing payment instead of
+// charging twice.
+package settlement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+var (
+	// ErrNotFinal is returned for a transaction that is still dispensing
+	ErrNotFinal = errors.New("dispense not finished")
+	// ErrOutcomeUnknown is returned for a transaction whose final state was
+	// never observed. It needs manual review before anyone is charged.
+	ErrOutcomeUnknown = errors.New("dispense outcome unknown, not charged")
+)
+
+// Payment is the financial record of a dispense. Amounts are in minor
+// currency units (cents) to avoid rounding.
+type Payment struct {
+	PaymentID     string    `json:"payment_id"`
+	DispenseTxID  string    `json:"dispense_tx_id"`
+	Tokens        int       `json:"tokens"`    // tokens charged, i.e. dispensed
+	Requested     int       `json:"requested"` // tokens the customer asked for
+	PricePerToken int64     `json:"price_per_token"`
+	Amount        int64     `json:"amount"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// Partial reports whether fewer tokens than requested were charged
+func (p Payment) Partial() bool {
+	return p.Tokens < p.Requested
+}
+
+// PaymentSink stores payments. Implementations must be safe for concurrent
+// use and must never hold two payments for the same DispenseTxID.
+type PaymentSink interface {
+	// Record stores p unless a payment for p.DispenseTxID already exists,
+	// in which case that payment is returned unchanged and p is dropped.
+	// The returned bool is true if p was stored.
+	Record(ctx context.Context, p Payment) (Payment, bool, error)
+	// Lookup returns the payment for a dispense tx_id, if any
+	Lookup(ctx context.Context, dispenseTxID string) (Payment, bool, error)
+}
+
+// PaymentID is the payment ID for a dispense tx_id. It is derived from the
+// tx_id so that a downstream processor sees the same idempotency key no
+// matter how often a transaction is settled.
+func PaymentID(dispenseTxID string) string {
+	return "pay-" + dispenseTxID
+}
+
+// Settler charges finished dispenses at a fixed price per token
+type Settler struct {
+	Sink          PaymentSink
+	PricePerToken int64
+
+	// Clock timestamps payments; nil means clock.Real
+	Clock clock.Clock
+}
+
+// Settle creates the payment for a dispense result from DispenseAndWait.
+// It returns nil and no error when nothing is to be charged because no
+// token was dispensed. A result that is not final or whose outcome is
+// unknown returns ErrNotFinal or ErrOutcomeUnknown.
+func (s *Settler) Settle(ctx context.Context, res *client.DispenseResult) (*Payment, error) {
+	switch {
+	case res.Outcome == client.OutcomeUnknown:
+		return nil, fmt.Errorf("tx %s: %w", res.TxID, ErrOutcomeUnknown)
+	case res.State == "dispensing":
+		return nil, fmt.Errorf("tx %s: %w", res.TxID, ErrNotFinal)
+	}
+	return s.charge(ctx, res.TxID, res.Quantity, res.Dispensed)
+}
+
+// SettleRecord creates the payment for a journaled transaction. Records in
+// the intent or dispensing state return ErrNotFinal; run Recover first.
+// Unknown and reviewed outcomes return ErrOutcomeUnknown: an operator has
+// to settle those by hand. Rejected transactions are never charged.
+func (s *Settler) SettleRecord(ctx context.Context, rec client.JournalRecord) (*Payment, error) {
+	switch rec.State {
+	case "done", "error":
+		return s.charge(ctx, rec.TxID, rec.Quantity, rec.Dispensed)
+	case client.JournalRejected:
+		return nil, nil
+	case client.JournalUnknown, client.JournalReviewed:
+		return nil, fmt.Errorf("tx %s: %w", rec.TxID, ErrOutcomeUnknown)
+	}
+	return nil, fmt.Errorf("tx %s: %w", rec.TxID, ErrNotFinal)
+}
+
+// Settlement is the result of settling one journaled transaction
+type Settlement struct {
+	TxID    string   `json:"tx_id"`
+	Payment *Payment `json:"payment,omitempty"` // nil if nothing was charged
+	New     bool     `json:"new"`               // charged by this call
+	Err     error    `json:"-"`
+}
+
+// SettleJournal settles every finished transaction of j that has no payment
+// yet, e.g. after a crash between the end of a dispense and its payment.
+// Already settled transactions are reported with New false. Transactions
+// that cannot be charged automatically are reported with Err set.
+func (s *Settler) SettleJournal(ctx context.Context, j *client.Journal) ([]Settlement, error) {
+	var out []Settlement
+	for _, rec := range j.Records() {
+		if !rec.Finished() || rec.State == client.JournalRejected {
+			continue
+		}
+		existing, ok, err := s.Sink.Lookup(ctx, rec.TxID)
+		if err != nil {
+			return out, err
+		}
+		if ok {
+			out = append(out, Settlement{TxID: rec.TxID, Payment: &existing})
+			continue
+		}
+
+		p, err := s.SettleRecord(ctx, rec)
+		if err != nil && !errors.Is(err, ErrOutcomeUnknown) {
+			return out, err
+		}
+		out = append(out, Settlement{TxID: rec.TxID, Payment: p, New: p != nil, Err: err})
+	}
+	return out, nil
+}
+
+func (s *Settler) charge(ctx context.Context, txID string, requested, dispensed int) (*Payment, error) {
+	if dispensed <= 0 {
+		return nil, nil
+	}
+	if s.PricePerToken <= 0 {
+		return nil, fmt.Errorf("invalid price per token %d", s.PricePerToken)
+	}
+
+	clk := s.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	p := Payment{
+		PaymentID:     PaymentID(txID),
+		DispenseTxID:  txID,
+		Tokens:        dispensed,
+		Requested:     requested,
+		PricePerToken: s.PricePerToken,
+		Amount:        int64(dispensed) * s.PricePerToken,
+		Timestamp:     clk.Now(),
+	}
+	stored, _, err := s.Sink.Record(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("recording payment for tx %s: %w", txID, err)
+	}
+	return &stored, nil
+}
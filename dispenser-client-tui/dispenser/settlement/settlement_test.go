@@ -0,0 +1,230 @@
+package settlement
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+const price = 250 // cents per token
+
+// newSettler opens a FileSink at path and a Settler charging price
+func newSettler(t *testing.T, path string) (*Settler, *FileSink) {
+	t.Helper()
+	sink, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	return &Settler{Sink: sink, PricePerToken: price, Clock: clk}, sink
+}
+
+// lines counts the records in a JSON Lines file
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestSettleChargesDispensedTokens(t *testing.T) {
+	s, sink := newSettler(t, filepath.Join(t.TempDir(), "payments.jsonl"))
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		res     client.DispenseResult
+		tokens  int // 0: not charged
+		partial bool
+		err     error
+	}{
+		{res: client.DispenseResult{TxID: "done0001", State: "done", Outcome: client.OutcomeDone, Quantity: 3, Dispensed: 3}, tokens: 3},
+		{res: client.DispenseResult{TxID: "part0001", State: "error", Outcome: client.OutcomePartial, Quantity: 3, Dispensed: 2}, tokens: 2, partial: true},
+		{res: client.DispenseResult{TxID: "jam00001", State: "error", Outcome: client.OutcomeFailed, Quantity: 3}},
+		{res: client.DispenseResult{TxID: "unkn0001", State: client.StateUnknown, Outcome: client.OutcomeUnknown, Quantity: 3, Dispensed: 1}, err: ErrOutcomeUnknown},
+		{res: client.DispenseResult{TxID: "busy0001", State: "dispensing", Quantity: 3, Dispensed: 1}, err: ErrNotFinal},
+	} {
+		p, err := s.Settle(ctx, &tc.res)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v, want %v", tc.res.TxID, err, tc.err)
+		}
+		if tc.tokens == 0 {
+			if p != nil {
+				t.Errorf("%s: charged %+v, want nothing", tc.res.TxID, p)
+			}
+			if _, ok, _ := sink.Lookup(ctx, tc.res.TxID); ok {
+				t.Errorf("%s: payment recorded", tc.res.TxID)
+			}
+			continue
+		}
+		if p == nil || p.Tokens != tc.tokens || p.Amount != int64(tc.tokens)*price ||
+			p.Requested != tc.res.Quantity || p.Partial() != tc.partial || p.PaymentID != PaymentID(tc.res.TxID) {
+			t.Errorf("%s: payment = %+v, want %d tokens at %d", tc.res.TxID, p, tc.tokens, price)
+		}
+	}
+	if got := len(sink.Payments()); got != 2 {
+		t.Errorf("%d payments, want 2", got)
+	}
+}
+
+func TestSettleRetryChargesOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payments.jsonl")
+	s, _ := newSettler(t, path)
+	ctx := context.Background()
+	res := &client.DispenseResult{TxID: "a1b2c3d4", State: "done", Outcome: client.OutcomeDone, Quantity: 3, Dispensed: 3}
+
+	first, err := s.Settle(ctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.Settle(ctx, res)
+	if err != nil || *again != *first {
+		t.Fatalf("second Settle = %+v %v, want %+v", again, err, first)
+	}
+
+	// After a restart the payment file still knows the tx
+	restarted, sink := newSettler(t, path)
+	replay, err := restarted.Settle(ctx, res)
+	if err != nil || *replay != *first {
+		t.Fatalf("Settle after restart = %+v %v, want %+v", replay, err, first)
+	}
+	if n := len(sink.Payments()); n != 1 || lines(t, path) != 1 {
+		t.Errorf("%d payments, %d lines; want 1 of each", n, lines(t, path))
+	}
+}
+
+func TestSettleJournal(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	j, err := client.OpenJournal(filepath.Join(dir, "dispense.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	observe := func(txID, state string, quantity, dispensed int) {
+		t.Helper()
+		if err := j.Intent(txID, quantity); err != nil {
+			t.Fatal(err)
+		}
+		if state == client.JournalIntent {
+			return
+		}
+		if err := j.Observe(client.DispenseResponse{TxID: txID, State: state, Quantity: quantity, Dispensed: dispensed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	observe("paid0001", "done", 2, 2)
+	observe("part0001", "error", 3, 2)
+	observe("jam00001", "error", 3, 0)
+	observe("open0001", client.JournalIntent, 1, 0)
+	observe("unkn0001", "dispensing", 3, 1)
+	if err := j.MarkUnknown("unkn0001", "fell out of history"); err != nil {
+		t.Fatal(err)
+	}
+	observe("rejd0001", client.JournalIntent, 1, 0)
+	if err := j.Reject("rejd0001", client.ErrBusy); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client crashed after charging paid0001 but before the others
+	path := filepath.Join(dir, "payments.jsonl")
+	s, _ := newSettler(t, path)
+	paid, err := s.Settle(ctx, &client.DispenseResult{TxID: "paid0001", State: "done", Quantity: 2, Dispensed: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.SettleJournal(ctx, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTx := make(map[string]Settlement)
+	for _, st := range got {
+		byTx[st.TxID] = st
+	}
+	if len(got) != 4 {
+		t.Errorf("settled %+v, want paid0001, part0001, jam00001 and unkn0001", got)
+	}
+	if st := byTx["paid0001"]; st.New || st.Payment == nil || *st.Payment != *paid {
+		t.Errorf("paid0001: %+v, want the existing payment", st)
+	}
+	if st := byTx["part0001"]; !st.New || st.Payment == nil || st.Payment.Tokens != 2 || st.Payment.Amount != 2*price {
+		t.Errorf("part0001: %+v, want a new charge for 2 tokens", st)
+	}
+	if st := byTx["jam00001"]; st.New || st.Payment != nil || st.Err != nil {
+		t.Errorf("jam00001: %+v, want nothing charged", st)
+	}
+	if st := byTx["unkn0001"]; st.New || st.Payment != nil || !errors.Is(st.Err, ErrOutcomeUnknown) {
+		t.Errorf("unkn0001: %+v, want ErrOutcomeUnknown", st)
+	}
+
+	// Replaying the journal after a restart charges nothing new
+	restarted, sink := newSettler(t, path)
+	again, err := restarted.SettleJournal(ctx, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range again {
+		if st.New {
+			t.Errorf("replay charged %s again: %+v", st.TxID, st.Payment)
+		}
+	}
+	if n := len(sink.Payments()); n != 2 || lines(t, path) != 2 {
+		t.Errorf("%d payments, %d lines after replay; want 2 of each", n, lines(t, path))
+	}
+}
+
+func TestFileSinkDedupesAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payments.jsonl")
+	ctx := context.Background()
+	p := Payment{PaymentID: PaymentID("a1b2c3d4"), DispenseTxID: "a1b2c3d4", Tokens: 2, Requested: 2, PricePerToken: price, Amount: 2 * price}
+
+	sink, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, stored, err := sink.Record(ctx, p); err != nil || !stored {
+		t.Fatalf("first Record: stored %v, %v", stored, err)
+	}
+	sink.Close()
+
+	// A crash tore the next payment's line
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"payment_id":"pay-e5f6a7b8","dispense_tx`)
+	f.Close()
+
+	sink, err = OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if lines(t, path) != 1 {
+		t.Errorf("torn line not cut off: %d lines", lines(t, path))
+	}
+
+	dup := p
+	dup.Tokens, dup.Amount = 1, price
+	got, stored, err := sink.Record(ctx, dup)
+	if err != nil || stored || got != p {
+		t.Errorf("Record after reopen = %+v, stored %v, %v; want the original payment", got, stored, err)
+	}
+	if _, ok, _ := sink.Lookup(ctx, "e5f6a7b8"); ok {
+		t.Error("torn payment was replayed")
+	}
+	if n := len(sink.Payments()); n != 1 || lines(t, path) != 1 {
+		t.Errorf("%d payments, %d lines; want 1 of each", n, lines(t, path))
+	}
+}
@@ -0,0 +1,317 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+// Fault actions understood by Inject and scenario files
+const (
+	ActionJam        = "jam"         // stall the motor; the watchdog jams the active tx
+	ActionError      = "error"       // raise Azkoyen error Code (1-7)
+	ActionClearError = "clear_error" // clear the active hardware error
+	ActionHopperLow  = "hopper_low"  // assert the hopper low sensor
+	ActionHopperOK   = "hopper_ok"   // release the hopper low sensor
+	ActionReboot     = "reboot"      // power cycle, keeping the flash-persisted tx
+	ActionDrop       = "drop"        // close the connection instead of answering
+	ActionSlow       = "slow"        // delay responses by Delay
+	ActionRSSI       = "rssi"        // set RSSI, or start drifting if Drift > 0
+)
+
+// Error codes reported by the Azkoyen hopper on its error signal line
+var errorTypes = map[int][2]string{
+	1: {"COIN_STUCK", "Coin stuck in exit sensor (>65ms)"},
+	2: {"SENSOR_OFF", "Exit sensor stuck OFF"},
+	3: {"JAM_PERMANENT", "Permanent jam detected"},
+	4: {"MAX_SPAN", "Multiple spans exceeded max time"},
+	5: {"MOTOR_FAULT", "Motor doesn't start"},
+	6: {"SENSOR_FAULT", "Exit sensor disconnected/faulty"},
+	7: {"POWER_FAULT", "Power supply out of range"},
+}
+
+const errorHistorySize = 5
+
+// Duration is a time.Duration that reads and writes as "1.5s" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Fault is one injected event. It fires immediately unless At or
+// AfterTokens delay it; both count from the moment the fault is armed.
+type Fault struct {
+	Action string `json:"action"`
+
+	// Triggers
+	At          Duration `json:"at,omitempty"`
+	AfterTokens int      `json:"after_tokens,omitempty"`
+
+	// Parameters
+	Code  int      `json:"code,omitempty"`  // error
+	Count int      `json:"count,omitempty"` // drop, slow: number of responses (0 = 1)
+	Path  string   `json:"path,omitempty"`  // drop, slow: only matching path prefix
+	Delay Duration `json:"delay,omitempty"` // slow
+	RSSI  int      `json:"rssi,omitempty"`  // rssi
+	Drift int      `json:"drift,omitempty"` // rssi: max dBm change per /health call
+}
+
+// Scenario is a list of faults loaded from a JSON file
+type Scenario struct {
+	Name   string  `json:"name,omitempty"`
+	Faults []Fault `json:"faults"`
+}
+
+// LoadScenario reads a scenario file
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+	for i, f := range sc.Faults {
+		if err := f.validate(); err != nil {
+			return nil, fmt.Errorf("scenario %s fault %d: %w", path, i, err)
+		}
+	}
+	return &sc, nil
+}
+
+func (f Fault) validate() error {
+	switch f.Action {
+	case ActionJam, ActionClearError, ActionHopperLow, ActionHopperOK, ActionReboot, ActionDrop, ActionRSSI:
+	case ActionError:
+		if _, ok := errorTypes[f.Code]; !ok {
+			return fmt.Errorf("error code %d out of range 1-7", f.Code)
+		}
+	case ActionSlow:
+		if f.Delay <= 0 {
+			return fmt.Errorf("slow requires a positive delay")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", f.Action)
+	}
+	return nil
+}
+
+// errorEvent is one entry of the hardware error history
+type errorEvent struct {
+	Code      int
+	Timestamp int64 // millis() since boot, as the firmware stamps it
+	Cleared   bool
+}
+
+// pendingFault is an armed fault waiting for its token trigger
+type pendingFault struct {
+	fault  Fault
+	tokens int // tokens pulsed since arming
+}
+
+// responseFault drops or delays upcoming HTTP responses
+type responseFault struct {
+	action string
+	path   string
+	delay  time.Duration
+	left   int
+}
+
+// Apply arms every fault of the scenario
+func (s *Simulator) Apply(sc *Scenario) error {
+	for _, f := range sc.Faults {
+		if err := s.Inject(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inject arms a fault. Faults without a trigger take effect immediately.
+func (s *Simulator) Inject(f Fault) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case f.At > 0:
+		delay := time.Duration(f.At)
+		f.At = 0
+		s.clock.AfterFunc(delay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if !s.closedLocked() {
+				s.fireLocked(f)
+			}
+		})
+	case f.AfterTokens > 0:
+		s.mu.Lock()
+		s.pending = append(s.pending, &pendingFault{fault: f})
+		s.mu.Unlock()
+	default:
+		s.mu.Lock()
+		s.fireLocked(f)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// fireLocked applies a fault to the device state
+func (s *Simulator) fireLocked(f Fault) {
+	switch f.Action {
+	case ActionJam:
+		s.stalled = true
+	case ActionError:
+		s.raiseErrorLocked(f.Code)
+	case ActionClearError:
+		s.clearErrorLocked()
+	case ActionHopperLow:
+		s.hopperLow = true
+	case ActionHopperOK:
+		s.hopperLow = false
+	case ActionReboot:
+		s.rebootLocked()
+	case ActionDrop, ActionSlow:
+		count := f.Count
+		if count <= 0 {
+			count = 1
+		}
+		s.responseFaults = append(s.responseFaults, &responseFault{
+			action: f.Action,
+			path:   f.Path,
+			delay:  time.Duration(f.Delay),
+			left:   count,
+		})
+	case ActionRSSI:
+		if f.RSSI != 0 {
+			s.rssi = f.RSSI
+		}
+		s.rssiDrift = f.Drift
+	}
+}
+
+// raiseErrorLocked records a hardware error. Codes 3 and above stop the
+// hopper, so an active dispense runs into the jam watchdog.
+func (s *Simulator) raiseErrorLocked(code int) {
+	ev := errorEvent{Code: code, Timestamp: s.uptimeLocked().Milliseconds()}
+	s.errors = append([]errorEvent{ev}, s.errors...)
+	if len(s.errors) > errorHistorySize {
+		s.errors = s.errors[:errorHistorySize]
+	}
+	if code >= 3 {
+		s.stalled = true
+	}
+}
+
+// clearErrorLocked marks the newest active error as cleared (self-healing)
+func (s *Simulator) clearErrorLocked() {
+	for i := range s.errors {
+		if !s.errors[i].Cleared {
+			s.errors[i].Cleared = true
+			return
+		}
+	}
+}
+
+func (s *Simulator) activeErrorLocked() *errorEvent {
+	for i := range s.errors {
+		if !s.errors[i].Cleared {
+			return &s.errors[i]
+		}
+	}
+	return nil
+}
+
+// rebootLocked simulates a power cycle. RAM state (metrics, history, error
+// log) is lost; the flash-persisted active transaction survives. A tx that
+// was dispensing is recovered as error with its partial count, while a tx
+// already in error is cleared, matching DispenseManager::begin.
+func (s *Simulator) rebootLocked() {
+	s.bootTime = s.clock.Now()
+	s.metrics = client.Metrics{}
+	s.history = [len(s.history)]transaction{}
+	s.histPos = 0
+	s.errors = nil
+	s.stalled = false
+	s.pending = nil
+
+	if s.active == nil {
+		return
+	}
+	switch s.active.State {
+	case stateDispensing:
+		s.active.State = stateError
+		s.addHistoryLocked(*s.active)
+	case stateError:
+		s.addHistoryLocked(*s.active)
+		s.active = nil
+	}
+}
+
+// tokenPulsedLocked advances token-triggered faults
+func (s *Simulator) tokenPulsedLocked() {
+	var keep []*pendingFault
+	var fire []Fault
+	for _, p := range s.pending {
+		p.tokens++
+		if p.tokens >= p.fault.AfterTokens {
+			f := p.fault
+			f.AfterTokens = 0
+			fire = append(fire, f)
+		} else {
+			keep = append(keep, p)
+		}
+	}
+	s.pending = keep
+	for _, f := range fire {
+		s.fireLocked(f)
+	}
+}
+
+// takeResponseFault consumes a matching drop/slow fault for path, if any
+func (s *Simulator) takeResponseFault(path string) *responseFault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, rf := range s.responseFaults {
+		if rf.path != "" && !strings.HasPrefix(path, rf.path) {
+			continue
+		}
+		rf.left--
+		if rf.left <= 0 {
+			s.responseFaults = append(s.responseFaults[:i], s.responseFaults[i+1:]...)
+		}
+		taken := *rf
+		return &taken
+	}
+	return nil
+}
+
+// driftRSSILocked applies one random walk step within the ESP8266's range
+func (s *Simulator) driftRSSILocked() {
+	if s.rssiDrift <= 0 {
+		return
+	}
+	s.rssi += rand.Intn(2*s.rssiDrift+1) - s.rssiDrift
+	s.rssi = max(-90, min(-30, s.rssi))
+}
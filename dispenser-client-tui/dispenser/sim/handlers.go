@@ -0,0 +1,195 @@
+package sim
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"token-tui/dispenser/client"
+)
+
+// AdminPrefix is where the fault injection endpoints are mounted
+const AdminPrefix = "/_sim/"
+
+// Handler returns the HTTP API of the simulator. Besides the protocol
+// endpoints it serves the admin API under AdminPrefix:
+//
+//	GET  /_sim/state     current health plus pending fault count
+//	POST /_sim/faults    inject one Fault (JSON body)
+//	POST /_sim/scenario  apply a Scenario (JSON body)
+//	POST /_sim/reboot    power cycle
+func (s *Simulator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("POST /dispense", s.handleDispensePost)
+	mux.HandleFunc("GET /dispense/{tx_id...}", s.handleDispenseGet)
+
+	mux.HandleFunc("GET "+AdminPrefix+"state", s.handleAdminState)
+	mux.HandleFunc("POST "+AdminPrefix+"faults", s.handleAdminFault)
+	mux.HandleFunc("POST "+AdminPrefix+"scenario", s.handleAdminScenario)
+	mux.HandleFunc("POST "+AdminPrefix+"reboot", s.handleAdminReboot)
+
+	return s.injectResponseFaults(mux)
+}
+
+// injectResponseFaults drops or delays protocol responses as scheduled by
+// drop and slow faults. Admin requests are never affected.
+func (s *Simulator) injectResponseFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, AdminPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rf := s.takeResponseFault(r.URL.Path)
+		switch {
+		case rf == nil:
+		case rf.action == ActionSlow:
+			select {
+			case <-s.clock.After(rf.delay):
+			case <-r.Context().Done():
+				return
+			}
+		case rf.action == ActionDrop:
+			// The request is still processed, as when a reply is lost on
+			// the WiFi link after the firmware acted on it.
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Simulator) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.health())
+}
+
+func (s *Simulator) handleDispensePost(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAuth(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, "content-type must be application/json")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	txID, okID := req["tx_id"].(string)
+	qtyNum, okQty := req["quantity"].(float64)
+	if !okID || !okQty || qtyNum != math.Trunc(qtyNum) || qtyNum < 0 || qtyNum > 255 {
+		writeError(w, http.StatusBadRequest, "invalid request format")
+		return
+	}
+	quantity := int(qtyNum)
+	if len(txID) == 0 || len(txID) > client.MaxTxIDLen || quantity == 0 || quantity > MaxTokens {
+		writeError(w, http.StatusBadRequest, "invalid tx_id or quantity")
+		return
+	}
+
+	tx, busy := s.startDispense(txID, quantity)
+	if busy != nil {
+		writeJSON(w, http.StatusConflict, client.ErrorResponse{
+			Error:       "busy",
+			ActiveTxID:  busy.TxID,
+			ActiveState: busy.State,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, tx.response())
+}
+
+func (s *Simulator) handleDispenseGet(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAuth(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	txID := strings.TrimSpace(r.PathValue("tx_id"))
+	if len(txID) == 0 || len(txID) > client.MaxTxIDLen {
+		writeError(w, http.StatusBadRequest, "invalid tx_id")
+		return
+	}
+
+	tx, ok := s.status(txID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, tx.response())
+}
+
+func (s *Simulator) handleAdminState(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	pending := len(s.pending) + len(s.responseFaults)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, struct {
+		Health        client.HealthResponse `json:"health"`
+		PendingFaults int                   `json:"pending_faults"`
+	}{s.health(), pending})
+}
+
+func (s *Simulator) handleAdminFault(w http.ResponseWriter, r *http.Request) {
+	var f Fault
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.Inject(f); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Simulator) handleAdminScenario(w http.ResponseWriter, r *http.Request) {
+	var sc Scenario
+	if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.Apply(&sc); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Simulator) handleAdminReboot(w http.ResponseWriter, r *http.Request) {
+	s.Reboot()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Simulator) checkAuth(r *http.Request) bool {
+	return r.Header.Get("X-API-Key") == s.cfg.APIKey
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, client.ErrorResponse{Error: msg})
+}
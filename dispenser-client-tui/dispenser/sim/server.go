@@ -0,0 +1,38 @@
+package sim
+
+import (
+	"net/http/httptest"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+// Server is a simulator listening on a local httptest server, for use in
+// tests of code built on the client package.
+type Server struct {
+	*httptest.Server
+	Sim *Simulator
+}
+
+// NewServer starts a simulator with cfg on a random local port
+func NewServer(cfg Config) *Server {
+	s := New(cfg)
+	return &Server{
+		Server: httptest.NewServer(s.Handler()),
+		Sim:    s,
+	}
+}
+
+// Close shuts down the HTTP server and the simulated motor
+func (s *Server) Close() {
+	s.Server.Close()
+	s.Sim.Close()
+}
+
+// DispenserClient returns a client pointed at the server with the
+// configured API key, sharing the simulator's clock.
+func (s *Server) DispenserClient() *client.DispenserClient {
+	c := client.NewDispenserClient(s.URL, s.Sim.cfg.APIKey, 3*time.Second)
+	c.Clock = s.Sim.clock
+	return c
+}
@@ -0,0 +1,227 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+// newTestServer starts a simulator with the firmware timing on a fake clock
+func newTestServer(t *testing.T) (*Server, *client.DispenserClient, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := DefaultConfig()
+	cfg.Clock = clk
+	srv := NewServer(cfg)
+	t.Cleanup(srv.Close)
+	return srv, srv.DispenserClient(), clk
+}
+
+// finish advances the fake clock until txID left the dispensing state
+func finish(t *testing.T, c *client.DispenserClient, clk *clock.Fake, txID string) *client.DispenseResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, result := c.Status(context.Background(), txID)
+		if result.Error != nil {
+			t.Fatalf("status %s: %v", txID, result.Error)
+		}
+		if !resp.InProgress() {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s still %s", txID, resp.State)
+		}
+		clk.Advance(100 * time.Millisecond)
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+func TestAuthRequired(t *testing.T) {
+	srv, _, _ := newTestServer(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"", "wrong-key"} {
+		c := client.NewDispenserClient(srv.URL, key, time.Second)
+		if _, result := c.Dispense(ctx, "a1b2c3d4", 1); !errors.Is(result.Error, client.ErrUnauthorized) || result.StatusCode != http.StatusUnauthorized {
+			t.Errorf("POST /dispense with key %q: %d %v, want 401", key, result.StatusCode, result.Error)
+		}
+		if _, result := c.Status(ctx, "a1b2c3d4"); !errors.Is(result.Error, client.ErrUnauthorized) {
+			t.Errorf("GET /dispense with key %q: %d %v, want 401", key, result.StatusCode, result.Error)
+		}
+		if _, result := c.Health(ctx); result.Error != nil {
+			t.Errorf("GET /health with key %q: %v, want no auth", key, result.Error)
+		}
+	}
+}
+
+func TestContentTypeEnforced(t *testing.T) {
+	srv, _, _ := newTestServer(t)
+
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/dispense", strings.NewReader(`{"tx_id":"a1b2c3d4","quantity":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", srv.Sim.cfg.APIKey)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: %d, want 415", contentType, resp.StatusCode)
+		}
+	}
+}
+
+func TestInvalidRequests(t *testing.T) {
+	_, c, _ := newTestServer(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		txID     string
+		quantity int
+	}{
+		{"a1b2c3d4", 0},
+		{"a1b2c3d4", MaxTokens + 1},
+		{"", 1},
+		{strings.Repeat("a", client.MaxTxIDLen+1), 1},
+	} {
+		_, result := c.Dispense(ctx, tc.txID, tc.quantity)
+		if !errors.Is(result.Error, client.ErrInvalidRequest) || result.StatusCode != http.StatusBadRequest {
+			t.Errorf("dispense %q x%d: %d %v, want 400", tc.txID, tc.quantity, result.StatusCode, result.Error)
+		}
+	}
+
+	// 16 characters is the longest valid tx_id
+	if _, result := c.Dispense(ctx, strings.Repeat("a", client.MaxTxIDLen), MaxTokens); result.Error != nil {
+		t.Errorf("dispense with a 16-character tx_id: %v", result.Error)
+	}
+}
+
+func TestDispenseIdempotent(t *testing.T) {
+	_, c, clk := newTestServer(t)
+	ctx := context.Background()
+	const txID = "a1b2c3d4"
+
+	_, result := c.Dispense(ctx, txID, 2)
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	// A retry while dispensing reports the running transaction
+	again, result := c.Dispense(ctx, txID, 2)
+	if result.Error != nil || again.TxID != txID || again.State != "dispensing" {
+		t.Fatalf("re-POST while dispensing: %+v %v", again, result.Error)
+	}
+
+	done := finish(t, c, clk, txID)
+	if done.State != "done" || done.Dispensed != 2 {
+		t.Fatalf("final state %+v", done)
+	}
+	// A retry after completion, even with another quantity, neither starts
+	// a new transaction nor dispenses again
+	again, result = c.Dispense(ctx, txID, 5)
+	if result.Error != nil || *again != *done {
+		t.Fatalf("re-POST after done: %+v %v, want %+v", again, result.Error, done)
+	}
+	h, _ := c.Health(ctx)
+	if h.Metrics.TotalDispenses != 1 || h.Dispenser != "idle" {
+		t.Errorf("after retries: %d dispenses, %s; want 1, idle", h.Metrics.TotalDispenses, h.Dispenser)
+	}
+}
+
+func TestConflictBusyVersusError(t *testing.T) {
+	srv, c, clk := newTestServer(t)
+	ctx := context.Background()
+
+	if _, result := c.Dispense(ctx, "11111111", 3); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	_, result := c.Dispense(ctx, "22222222", 1)
+	var apiErr *client.APIError
+	if !errors.Is(result.Error, client.ErrBusy) || errors.Is(result.Error, client.ErrDispenserFault) ||
+		!errors.As(result.Error, &apiErr) || apiErr.ActiveTxID != "11111111" || apiErr.ActiveState != "dispensing" {
+		t.Fatalf("while dispensing: %d %v, want 409 busy", result.StatusCode, result.Error)
+	}
+
+	if err := srv.Sim.Inject(Fault{Action: ActionJam}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := finish(t, c, clk, "11111111"); resp.State != "error" {
+		t.Fatalf("after jam: %+v", resp)
+	}
+	_, result = c.Dispense(ctx, "22222222", 1)
+	if !errors.Is(result.Error, client.ErrDispenserFault) || errors.Is(result.Error, client.ErrBusy) ||
+		!errors.As(result.Error, &apiErr) || apiErr.ActiveTxID != "11111111" || apiErr.ActiveState != "error" {
+		t.Fatalf("while jammed: %d %v, want 409 error", result.StatusCode, result.Error)
+	}
+
+	// Only a power cycle clears the jam
+	srv.Sim.Reboot()
+	if _, result = c.Dispense(ctx, "22222222", 1); result.Error != nil {
+		t.Fatalf("after reboot: %v", result.Error)
+	}
+}
+
+func TestStatusNotFoundAfterHistoryWraps(t *testing.T) {
+	_, c, clk := newTestServer(t)
+	ctx := context.Background()
+
+	run := func(txID string) {
+		t.Helper()
+		if _, result := c.Dispense(ctx, txID, 1); result.Error != nil {
+			t.Fatalf("dispense %s: %v", txID, result.Error)
+		}
+		finish(t, c, clk, txID)
+	}
+
+	run("00000000")
+	for i := 1; i < client.HistorySize; i++ {
+		run(fmt.Sprintf("%08d", i))
+	}
+	// Seven newer transactions: the first one is still in the ring
+	if resp, result := c.Status(ctx, "00000000"); result.Error != nil || resp.State != "done" {
+		t.Fatalf("after %d newer: %+v %v", client.HistorySize-1, resp, result.Error)
+	}
+
+	run(fmt.Sprintf("%08d", client.HistorySize))
+	if _, result := c.Status(ctx, "00000000"); !errors.Is(result.Error, client.ErrNotFound) || result.StatusCode != http.StatusNotFound {
+		t.Errorf("after %d newer: %d %v, want 404", client.HistorySize, result.StatusCode, result.Error)
+	}
+	// POSTing it again now starts a new transaction
+	resp, result := c.Dispense(ctx, "00000000", 1)
+	if result.Error != nil || resp.State != "dispensing" {
+		t.Errorf("re-POST after eviction: %+v %v, want a new dispense", resp, result.Error)
+	}
+}
+
+func TestErrorTimestampMillis(t *testing.T) {
+	srv, c, clk := newTestServer(t)
+
+	clk.Advance(10*time.Minute + 1234*time.Millisecond)
+	if err := srv.Sim.Inject(Fault{Action: ActionError, Code: 1}); err != nil {
+		t.Fatal(err)
+	}
+	h, result := c.Health(context.Background())
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	// Like the firmware: uptime in seconds, error timestamps in millis()
+	if h.Uptime != 601 || h.Error == nil || h.Error.Timestamp != 601234 {
+		t.Fatalf("uptime %d, error %+v; want 601, timestamp 601234", h.Uptime, h.Error)
+	}
+	if len(h.ErrorHistory) != 1 || h.ErrorHistory[0].Timestamp != 601234 {
+		t.Errorf("error history %+v, want timestamp 601234", h.ErrorHistory)
+	}
+}
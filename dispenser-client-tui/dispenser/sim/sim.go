@@ -0,0 +1,350 @@
+// Package sim is an in-process simulator of the ESP8266 token dispenser. It
+// serves the HTTP API from dispenser-protocol.md with the same validation,
+// idempotency and timing rules as the firmware, so the TUI and client code
+// can run without the physical hopper.
+package sim
+
+import (
+	"sync"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+const (
+	// MaxTokens is the largest quantity accepted per transaction
+	MaxTokens = 20
+
+	stateIdle       = "idle"
+	stateDispensing = "dispensing"
+	stateDone       = "done"
+	stateError      = "error"
+)
+
+// Config describes the simulated device
+type Config struct {
+	APIKey   string
+	Firmware string
+
+	// TokenInterval is the time between coin pulses while the motor runs
+	TokenInterval time.Duration
+	// JamTimeout is how long the watchdog waits for a pulse before jamming
+	JamTimeout time.Duration
+	// HopperTokens is the number of tokens loaded; 0 means unlimited. An
+	// empty hopper stops producing pulses, which ends in a jam.
+	HopperTokens int
+
+	RSSI int
+	IP   string
+	SSID string
+
+	// Clock drives uptime, coin pulses and the jam watchdog; nil means
+	// clock.Real. A clock.Fake lets tests run long dispenses instantly.
+	Clock clock.Clock
+}
+
+// DefaultConfig matches the firmware defaults and hardware timing
+func DefaultConfig() Config {
+	return Config{
+		APIKey:        "change-this-secret-key-here",
+		Firmware:      "1.1.0",
+		TokenInterval: 2500 * time.Millisecond,
+		JamTimeout:    5 * time.Second,
+		RSSI:          -47,
+		IP:            "192.168.4.20",
+		SSID:          "dispenser-sim",
+	}
+}
+
+// transaction mirrors the firmware's HistoryEntry
+type transaction struct {
+	TxID      string
+	Quantity  int
+	Dispensed int
+	State     string
+}
+
+func (t transaction) response() client.DispenseResponse {
+	return client.DispenseResponse{
+		TxID:      t.TxID,
+		State:     t.State,
+		Quantity:  t.Quantity,
+		Dispensed: t.Dispensed,
+	}
+}
+
+// Simulator holds the device state. All exported methods are safe for
+// concurrent use.
+type Simulator struct {
+	cfg   Config
+	clock clock.Clock
+
+	mu       sync.Mutex
+	bootTime time.Time
+	active   *transaction // dispensing or jammed; nil when idle
+	history  [client.HistorySize]transaction
+	histPos  int
+	metrics  client.Metrics
+	hopper   int // tokens left, -1 if unlimited
+
+	// Injected faults, see faults.go
+	stalled        bool // motor runs but no coins drop
+	hopperLow      bool
+	errors         []errorEvent // newest first
+	pending        []*pendingFault
+	responseFaults []*responseFault
+	rssi           int
+	rssiDrift      int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New returns a powered-on simulator in idle state
+func New(cfg Config) *Simulator {
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	s := &Simulator{
+		cfg:      cfg,
+		clock:    cfg.Clock,
+		bootTime: cfg.Clock.Now(),
+		hopper:   -1,
+		rssi:     cfg.RSSI,
+		stop:     make(chan struct{}),
+	}
+	if cfg.HopperTokens > 0 {
+		s.hopper = cfg.HopperTokens
+	}
+	return s
+}
+
+// Close stops any running motor goroutine
+func (s *Simulator) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Refill loads n more tokens into the hopper. It has no effect on an
+// unlimited hopper.
+func (s *Simulator) Refill(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hopper >= 0 {
+		s.hopper += n
+	}
+}
+
+// Reboot power cycles the simulated device, which is how jams are cleared
+func (s *Simulator) Reboot() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rebootLocked()
+}
+
+func (s *Simulator) closedLocked() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Simulator) uptimeLocked() time.Duration {
+	return s.clock.Since(s.bootTime)
+}
+
+// startDispense implements POST /dispense semantics. It returns the
+// transaction to report and, if the request was rejected, the active one.
+func (s *Simulator) startDispense(txID string, quantity int) (tx transaction, busy *transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached, ok := s.lookupLocked(txID); ok {
+		return cached, nil
+	}
+	if s.active != nil {
+		return transaction{}, &transaction{TxID: s.active.TxID, State: s.active.State}
+	}
+
+	s.active = &transaction{TxID: txID, Quantity: quantity, State: stateDispensing}
+	s.metrics.TotalDispenses++
+
+	s.wg.Add(1)
+	go s.runMotor(txID)
+
+	return *s.active, nil
+}
+
+// lookupLocked finds txID in the active slot or the history ring
+func (s *Simulator) lookupLocked(txID string) (transaction, bool) {
+	if s.active != nil && s.active.TxID == txID {
+		return *s.active, true
+	}
+	for _, t := range s.history {
+		if t.TxID == txID {
+			return t, true
+		}
+	}
+	return transaction{}, false
+}
+
+func (s *Simulator) status(txID string) (transaction, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookupLocked(txID)
+}
+
+// runMotor emits coin pulses until the transaction completes or the jam
+// watchdog fires JamTimeout after the last pulse.
+func (s *Simulator) runMotor(txID string) {
+	defer s.wg.Done()
+
+	lastPulse := s.clock.Now()
+	for {
+		s.mu.Lock()
+		canPulse := s.canPulseLocked()
+		s.mu.Unlock()
+
+		wait := s.cfg.TokenInterval
+		if !canPulse {
+			wait = max(0, s.cfg.JamTimeout-s.clock.Since(lastPulse))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-s.clock.After(wait):
+		}
+
+		s.mu.Lock()
+		if s.active == nil || s.active.TxID != txID || s.active.State != stateDispensing {
+			s.mu.Unlock()
+			return
+		}
+		switch {
+		case canPulse && s.canPulseLocked():
+			s.pulseLocked()
+			lastPulse = s.clock.Now()
+		case s.clock.Since(lastPulse) >= s.cfg.JamTimeout:
+			s.jamLocked()
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// canPulseLocked reports whether the hopper can drop another coin before
+// the jam watchdog fires
+func (s *Simulator) canPulseLocked() bool {
+	return s.hopper != 0 && !s.stalled && s.cfg.TokenInterval <= s.cfg.JamTimeout
+}
+
+// pulseLocked counts one token and completes the transaction when done.
+// Completion clears the active hardware error (self-healing).
+func (s *Simulator) pulseLocked() {
+	s.active.Dispensed++
+	if s.hopper > 0 {
+		s.hopper--
+	}
+	s.tokenPulsedLocked()
+	if s.active == nil || s.active.State != stateDispensing || s.active.Dispensed < s.active.Quantity {
+		return
+	}
+
+	s.active.State = stateDone
+	s.addHistoryLocked(*s.active)
+	s.active = nil
+	s.metrics.Successful++
+	s.clearErrorLocked()
+}
+
+// jamLocked moves the active transaction to error; it stays there until
+// Reset, like the firmware until a power cycle.
+func (s *Simulator) jamLocked() {
+	s.active.State = stateError
+	s.addHistoryLocked(*s.active)
+	s.metrics.Jams++
+	s.metrics.Failures++
+	if s.active.Dispensed > 0 {
+		s.metrics.Partial++
+	}
+}
+
+func (s *Simulator) addHistoryLocked(t transaction) {
+	s.history[s.histPos] = t
+	s.histPos = (s.histPos + 1) % len(s.history)
+}
+
+// health builds the GET /health response
+func (s *Simulator) health() client.HealthResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.driftRSSILocked()
+
+	h := client.HealthResponse{
+		Status:    "ok",
+		Uptime:    int(s.uptimeLocked().Seconds()),
+		Firmware:  s.cfg.Firmware,
+		WiFi:      &client.WiFiInfo{RSSI: s.rssi, IP: s.cfg.IP, SSID: s.cfg.SSID},
+		Dispenser: stateIdle,
+		GPIO: &client.GPIOInfo{
+			CoinPulse:   client.PinState{Raw: 1},
+			ErrorSignal: client.PinState{Raw: 1},
+			HopperLow:   client.PinState{Raw: 1},
+		},
+		Metrics:      s.metrics,
+		Error:        &client.ErrorInfo{Active: false},
+		ErrorHistory: []client.ErrorRecord{},
+	}
+
+	if s.active != nil {
+		h.Dispenser = s.active.State
+		if s.active.State == stateDispensing {
+			h.ActiveTx = &client.ActiveTxInfo{
+				TxID:      s.active.TxID,
+				Quantity:  s.active.Quantity,
+				Dispensed: s.active.Dispensed,
+			}
+		} else {
+			h.Status = "error"
+		}
+	}
+	if s.hopper == 0 || s.hopperLow {
+		h.GPIO.HopperLow = client.PinState{Raw: 0, Active: true}
+		if h.Status == "ok" {
+			h.Status = "degraded"
+		}
+	}
+
+	if ev := s.activeErrorLocked(); ev != nil {
+		h.GPIO.ErrorSignal = client.PinState{Raw: 0, Active: true}
+		h.Error = &client.ErrorInfo{
+			Active:      true,
+			Code:        ev.Code,
+			Type:        errorTypes[ev.Code][0],
+			Timestamp:   ev.Timestamp,
+			Description: errorTypes[ev.Code][1],
+		}
+	}
+	for _, ev := range s.errors {
+		h.ErrorHistory = append(h.ErrorHistory, client.ErrorRecord{
+			Code:      ev.Code,
+			Type:      errorTypes[ev.Code][0],
+			Timestamp: ev.Timestamp,
+			Cleared:   ev.Cleared,
+		})
+	}
+
+	return h
+}
@@ -0,0 +1,298 @@
+package soak
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timelineRows bounds the Markdown timeline table
+const timelineRows = 12
+
+// Report summarizes a finished soak
+type Report struct {
+	Started    time.Time     `json:"started"`
+	Ended      time.Time     `json:"ended"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	StopReason string        `json:"stop_reason"`
+	Aborted    bool          `json:"aborted"` // ended by a blocking condition
+	Firmware   string        `json:"firmware,omitempty"`
+
+	Config ReportConfig `json:"config"`
+	Stats  Stats        `json:"stats"`
+
+	JamRate     float64 `json:"jam_rate_pct"`
+	PartialRate float64 `json:"partial_rate_pct"`
+
+	Latency LatencySummary `json:"latency"`
+	RSSI    RSSISummary    `json:"rssi"`
+
+	Pauses   []Pause  `json:"pauses"`
+	Timeline []Sample `json:"timeline"`
+}
+
+// ReportConfig records the settings of the soak
+type ReportConfig struct {
+	Pattern      string        `json:"pattern"`
+	Duration     time.Duration `json:"duration_ns,omitempty"`
+	MaxTokens    int           `json:"max_tokens,omitempty"`
+	Interval     time.Duration `json:"interval_ns"`
+	OnHopperLow  Action        `json:"on_hopper_low"`
+	OnFault      Action        `json:"on_fault"`
+	PauseTimeout time.Duration `json:"pause_timeout_ns,omitempty"`
+}
+
+// LatencySummary describes /health latency in milliseconds
+type LatencySummary struct {
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min_ms"`
+	Mean    float64 `json:"mean_ms"`
+	P95     float64 `json:"p95_ms"`
+	Max     float64 `json:"max_ms"`
+}
+
+// RSSISummary describes the WiFi signal in dBm
+type RSSISummary struct {
+	Samples int     `json:"samples"`
+	Min     int     `json:"min_dbm"`
+	Mean    float64 `json:"mean_dbm"`
+	Max     int     `json:"max_dbm"`
+}
+
+func (r *Runner) report(reason string, aborted bool) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.snapshotLocked()
+	rep := &Report{
+		Started:    stats.Started,
+		Ended:      r.clk.Now(),
+		Elapsed:    stats.Elapsed,
+		StopReason: reason,
+		Aborted:    aborted,
+		Firmware:   r.firmware,
+		Config: ReportConfig{
+			Pattern:      r.cfg.PatternString(),
+			Duration:     r.cfg.Duration,
+			MaxTokens:    r.cfg.MaxTokens,
+			Interval:     r.cfg.Interval,
+			OnHopperLow:  r.cfg.OnHopperLow,
+			OnFault:      r.cfg.OnFault,
+			PauseTimeout: r.cfg.PauseTimeout,
+		},
+		Stats:       stats,
+		JamRate:     stats.JamRate(),
+		PartialRate: stats.PartialRate(),
+		Pauses:      append([]Pause{}, r.pauses...),
+		Timeline:    append([]Sample{}, r.samples...),
+	}
+	rep.Latency, rep.RSSI = summarize(r.samples)
+	return rep
+}
+
+func summarize(samples []Sample) (LatencySummary, RSSISummary) {
+	var lat LatencySummary
+	var rssi RSSISummary
+	var latencies []float64
+	var rssiSum int
+	for _, s := range samples {
+		if !s.Reachable {
+			continue
+		}
+		latencies = append(latencies, s.LatencyMS)
+		if s.RSSI == 0 {
+			continue
+		}
+		if rssi.Samples == 0 || s.RSSI < rssi.Min {
+			rssi.Min = s.RSSI
+		}
+		if rssi.Samples == 0 || s.RSSI > rssi.Max {
+			rssi.Max = s.RSSI
+		}
+		rssiSum += s.RSSI
+		rssi.Samples++
+	}
+	if rssi.Samples > 0 {
+		rssi.Mean = float64(rssiSum) / float64(rssi.Samples)
+	}
+
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		sum := 0.0
+		for _, v := range latencies {
+			sum += v
+		}
+		lat = LatencySummary{
+			Samples: len(latencies),
+			Min:     latencies[0],
+			Mean:    sum / float64(len(latencies)),
+			P95:     latencies[(95*len(latencies)+99)/100-1],
+			Max:     latencies[len(latencies)-1],
+		}
+	}
+	return lat, rssi
+}
+
+// WriteJSON writes the full report including the timeline
+func (rep *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// WriteMarkdown writes a human readable summary
+func (rep *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	s := rep.Stats
+
+	fmt.Fprintf(&b, "# Soak Report\n\n")
+	fmt.Fprintf(&b, "| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Started | %s |\n", rep.Started.Format(time.RFC3339))
+	fmt.Fprintf(&b, "| Ended | %s |\n", rep.Ended.Format(time.RFC3339))
+	fmt.Fprintf(&b, "| Elapsed | %s |\n", rep.Elapsed.Truncate(time.Second))
+	fmt.Fprintf(&b, "| Result | %s |\n", rep.StopReason)
+	if rep.Firmware != "" {
+		fmt.Fprintf(&b, "| Firmware | %s |\n", rep.Firmware)
+	}
+	fmt.Fprintf(&b, "| Pattern | %s every %s |\n", rep.Config.Pattern, rep.Config.Interval)
+	fmt.Fprintf(&b, "| On hopper low / fault | %s / %s |\n", rep.Config.OnHopperLow, rep.Config.OnFault)
+
+	fmt.Fprintf(&b, "\n## Dispenses\n\n")
+	fmt.Fprintf(&b, "| Metric | Value |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Dispenses | %d |\n", s.Dispenses)
+	fmt.Fprintf(&b, "| Tokens dispensed | %d of %d requested |\n", s.TokensDispensed, s.TokensRequested)
+	fmt.Fprintf(&b, "| Done | %d |\n", s.Done)
+	fmt.Fprintf(&b, "| Partial | %d (%.2f%%) |\n", s.Partial, rep.PartialRate)
+	fmt.Fprintf(&b, "| Failed | %d |\n", s.Failed)
+	fmt.Fprintf(&b, "| Jam rate | %.2f%% |\n", rep.JamRate)
+	fmt.Fprintf(&b, "| Unknown outcome | %d |\n", s.Unknown)
+	fmt.Fprintf(&b, "| Rejected | %d |\n", s.Rejected)
+	fmt.Fprintf(&b, "| Reboots | %d |\n", s.Reboots)
+	fmt.Fprintf(&b, "| Health check failures | %d |\n", s.HealthFailures)
+
+	fmt.Fprintf(&b, "\n## Error Codes\n\n")
+	if len(s.ErrorCodes) == 0 {
+		fmt.Fprintf(&b, "None.\n")
+	} else {
+		fmt.Fprintf(&b, "| Code | Type | Count |\n|---|---|---|\n")
+		for _, ec := range s.ErrorCodes {
+			fmt.Fprintf(&b, "| %d | %s | %d |\n", ec.Code, ec.Type, ec.Count)
+		}
+	}
+
+	fmt.Fprintf(&b, "\n## Link\n\n")
+	fmt.Fprintf(&b, "| Metric | Min | Mean | P95 | Max |\n|---|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| Latency (ms) | %.0f | %.0f | %.0f | %.0f |\n",
+		rep.Latency.Min, rep.Latency.Mean, rep.Latency.P95, rep.Latency.Max)
+	fmt.Fprintf(&b, "| RSSI (dBm) | %d | %.0f | | %d |\n", rep.RSSI.Min, rep.RSSI.Mean, rep.RSSI.Max)
+
+	if len(rep.Pauses) > 0 {
+		fmt.Fprintf(&b, "\n## Pauses\n\n")
+		fmt.Fprintf(&b, "| Start | Duration | Reason |\n|---|---|---|\n")
+		for _, p := range rep.Pauses {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", p.Start.Format("15:04:05"),
+				p.End.Sub(p.Start).Truncate(time.Second), p.Reason)
+		}
+	}
+
+	if rows := rep.timelineBuckets(); len(rows) > 0 {
+		fmt.Fprintf(&b, "\n## Timeline\n\n")
+		fmt.Fprintf(&b, "| From | Dispenses | Tokens | Jams | Partial | Latency (ms) | RSSI (dBm) |\n")
+		fmt.Fprintf(&b, "|---|---|---|---|---|---|---|\n")
+		for _, row := range rows {
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %d | %s | %s |\n", row.from.Format("15:04:05"),
+				row.dispenses, row.tokens, row.jams, row.partial, formatMean(row.latency), formatMean(row.rssi))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatMean prints an average, or "-" for a bucket without samples
+func formatMean(v float64) string {
+	if v == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
+type timelineRow struct {
+	from                             time.Time
+	dispenses, tokens, jams, partial int
+	latency, rssi                    float64
+}
+
+// timelineBuckets splits the samples into at most timelineRows periods of
+// equal length, with the activity and average link quality in each
+func (rep *Report) timelineBuckets() []timelineRow {
+	samples := rep.Timeline
+	if len(samples) < 2 {
+		return nil
+	}
+	start := samples[0].Time
+	span := samples[len(samples)-1].Time.Sub(start)
+	width := span/timelineRows + 1
+
+	var rows []timelineRow
+	var prev Sample // cumulative totals at the end of the previous bucket
+	for i := 0; i < len(samples); {
+		bucketEnd := start.Add(width * time.Duration(len(rows)+1))
+		row := timelineRow{from: start.Add(width * time.Duration(len(rows)))}
+		var latN, rssiN int
+		last := prev
+		for ; i < len(samples) && samples[i].Time.Before(bucketEnd); i++ {
+			s := samples[i]
+			last = s
+			if s.Reachable {
+				row.latency += s.LatencyMS
+				latN++
+			}
+			if s.RSSI != 0 {
+				row.rssi += float64(s.RSSI)
+				rssiN++
+			}
+		}
+		row.dispenses = last.Dispenses - prev.Dispenses
+		row.tokens = last.TokensDispensed - prev.TokensDispensed
+		row.jams = last.Jams - prev.Jams
+		row.partial = last.Partial - prev.Partial
+		if latN > 0 {
+			row.latency /= float64(latN)
+		}
+		if rssiN > 0 {
+			row.rssi /= float64(rssiN)
+		}
+		rows = append(rows, row)
+		prev = last
+	}
+	return rows
+}
+
+// WriteFiles writes base.md and base.json and returns their paths
+func (rep *Report) WriteFiles(base string) (mdPath, jsonPath string, err error) {
+	mdPath, jsonPath = base+".md", base+".json"
+	if err := writeFile(mdPath, rep.WriteMarkdown); err != nil {
+		return "", "", err
+	}
+	if err := writeFile(jsonPath, rep.WriteJSON); err != nil {
+		return "", "", err
+	}
+	return mdPath, jsonPath, nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,554 @@
+// Package soak runs a long dispense sequence against a dispenser to build
+// confidence in a hopper before it is deployed. A Runner dispenses a
+// repeating pattern of quantities for a duration or token count, tracks
+// jams, partial dispenses, Azkoyen error codes, latency and RSSI over time,
+// and summarizes the run in a Report.
+package soak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/timebase"
+)
+
+// Action is what the runner does when a condition blocks dispensing
+type Action string
+
+const (
+	ActionPause Action = "pause" // wait until the condition clears
+	ActionAbort Action = "abort" // end the soak
+)
+
+// ParseAction parses "pause" or "abort"
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(strings.TrimSpace(s))); a {
+	case ActionPause, ActionAbort:
+		return a, nil
+	}
+	return "", fmt.Errorf("invalid action %q (want pause or abort)", s)
+}
+
+// Config describes a soak run
+type Config struct {
+	// Pattern is the sequence of quantities to dispense, repeated
+	Pattern []int
+	// Duration ends the soak after this much time; 0 means no limit
+	Duration time.Duration
+	// MaxTokens ends the soak once this many tokens were dispensed; 0
+	// means no limit. At least one of Duration and MaxTokens must be set.
+	MaxTokens int
+	// Interval is the pause between two dispenses
+	Interval time.Duration
+
+	// OnHopperLow applies while the hopper low sensor is asserted
+	OnHopperLow Action
+	// OnFault applies while the dispenser is in error state (jam or
+	// hardware fault), which only a power cycle clears
+	OnFault Action
+	// PauseTimeout aborts a pause that lasts longer; 0 waits forever
+	PauseTimeout time.Duration
+	// CheckInterval is the health poll rate while paused or unreachable
+	CheckInterval time.Duration
+
+	// TxPrefix is an optional hex terminal prefix for generated tx_ids
+	TxPrefix string
+}
+
+// DefaultConfig is a one hour soak of alternating 1 and 3 token dispenses
+func DefaultConfig() Config {
+	return Config{
+		Pattern:       []int{1, 3},
+		Duration:      time.Hour,
+		Interval:      2 * time.Second,
+		OnHopperLow:   ActionPause,
+		OnFault:       ActionAbort,
+		PauseTimeout:  30 * time.Minute,
+		CheckInterval: 5 * time.Second,
+	}
+}
+
+// ParsePattern parses a comma separated list of quantities like "1,3,5"
+func ParsePattern(s string) ([]int, error) {
+	var pattern []int
+	for _, f := range strings.Split(s, ",") {
+		qty, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", s, err)
+		}
+		pattern = append(pattern, qty)
+	}
+	return pattern, nil
+}
+
+// Validate checks the pattern and limits
+func (c Config) Validate() error {
+	if len(c.Pattern) == 0 {
+		return errors.New("empty pattern")
+	}
+	for _, qty := range c.Pattern {
+		if qty < 1 || qty > 20 {
+			return fmt.Errorf("quantity %d out of range 1-20", qty)
+		}
+	}
+	if c.Duration <= 0 && c.MaxTokens <= 0 {
+		return errors.New("need a duration or token limit")
+	}
+	if c.OnHopperLow != ActionPause && c.OnHopperLow != ActionAbort {
+		return fmt.Errorf("invalid hopper low action %q", c.OnHopperLow)
+	}
+	if c.OnFault != ActionPause && c.OnFault != ActionAbort {
+		return fmt.Errorf("invalid fault action %q", c.OnFault)
+	}
+	return nil
+}
+
+// PatternString formats the pattern like ParsePattern expects it
+func (c Config) PatternString() string {
+	parts := make([]string, len(c.Pattern))
+	for i, qty := range c.Pattern {
+		parts[i] = strconv.Itoa(qty)
+	}
+	return strings.Join(parts, ",")
+}
+
+// EventKind classifies runner events
+type EventKind string
+
+const (
+	EventDispense EventKind = "dispense" // a transaction finished
+	EventPause    EventKind = "pause"    // dispensing paused
+	EventResume   EventKind = "resume"   // dispensing resumed
+	EventReboot   EventKind = "reboot"   // dispenser uptime went backwards
+	EventError    EventKind = "error"    // new error_history entry or request failure
+	EventDone     EventKind = "done"     // the soak ended
+)
+
+// Event is reported to the caller of Run as the soak progresses
+type Event struct {
+	Time    time.Time              `json:"time"`
+	Kind    EventKind              `json:"kind"`
+	Message string                 `json:"message"`
+	Result  *client.DispenseResult `json:"result,omitempty"`
+	Stats   Stats                  `json:"stats"`
+}
+
+// Stats are the running totals of a soak
+type Stats struct {
+	Started time.Time     `json:"started"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+
+	Dispenses       int `json:"dispenses"`
+	TokensRequested int `json:"tokens_requested"`
+	TokensDispensed int `json:"tokens_dispensed"`
+	Done            int `json:"done"`
+	Partial         int `json:"partial"`
+	Failed          int `json:"failed"`
+	Unknown         int `json:"unknown"`  // outcome never observed
+	Rejected        int `json:"rejected"` // POST refused, e.g. busy or in error
+	HealthFailures  int `json:"health_failures"`
+	Reboots         int `json:"reboots"`
+
+	ErrorCodes []ErrorCount `json:"error_codes"`
+
+	LastLatency time.Duration `json:"last_latency_ns"`
+	LastRSSI    int           `json:"last_rssi"`
+
+	Paused      bool   `json:"paused"`
+	PauseReason string `json:"pause_reason,omitempty"`
+}
+
+// JamRate is the percentage of dispenses that ended in error state
+func (s Stats) JamRate() float64 {
+	return percent(s.Partial+s.Failed, s.Dispenses)
+}
+
+// PartialRate is the percentage of dispenses that stopped part way
+func (s Stats) PartialRate() float64 {
+	return percent(s.Partial, s.Dispenses)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// ErrorCount is how often an Azkoyen error code was raised
+type ErrorCount struct {
+	Code  int    `json:"code"`
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// Sample is one health observation with the cumulative totals at that time
+type Sample struct {
+	Time            time.Time `json:"time"`
+	Dispenses       int       `json:"dispenses"`
+	TokensDispensed int       `json:"tokens_dispensed"`
+	Jams            int       `json:"jams"`
+	Partial         int       `json:"partial"`
+	LatencyMS       float64   `json:"latency_ms"`
+	RSSI            int       `json:"rssi"`
+	Reachable       bool      `json:"reachable"`
+}
+
+// Pause is a period in which dispensing was blocked
+type Pause struct {
+	Reason string    `json:"reason"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+type errorKey struct {
+	code      int
+	timestamp int64
+}
+
+// Runner executes one soak. Use New, then Run once.
+type Runner struct {
+	c     *client.DispenserClient
+	cfg   Config
+	clk   clock.Clock
+	txIDs *client.TxIDGenerator
+
+	mu         sync.Mutex
+	stats      Stats
+	errorCount map[int]*ErrorCount
+	seenErrors map[errorKey]bool
+	samples    []Sample
+	pauses     []Pause
+	lastHealth *client.HealthResponse
+	firmware   string
+	timebase   timebase.TimeBase
+}
+
+// New validates cfg and prepares a runner for c
+func New(c *client.DispenserClient, cfg Config) (*Runner, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DefaultConfig().CheckInterval
+	}
+	txIDs, err := client.NewTxIDGenerator(client.TxIDOptions{Prefix: cfg.TxPrefix})
+	if err != nil {
+		return nil, err
+	}
+
+	clk := c.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	return &Runner{
+		c:          c,
+		cfg:        cfg,
+		clk:        clk,
+		txIDs:      txIDs,
+		errorCount: make(map[int]*ErrorCount),
+		seenErrors: make(map[errorKey]bool),
+	}, nil
+}
+
+// Stats returns a snapshot of the running totals
+func (r *Runner) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshotLocked()
+}
+
+// Run dispenses until a limit is reached, a blocking condition aborts the
+// soak, or ctx ends. onEvent, if set, is called synchronously for every
+// event. The report is returned in every case; the error is non-nil only
+// when the soak could not run at all.
+func (r *Runner) Run(ctx context.Context, onEvent func(Event)) (*Report, error) {
+	emit := func(kind EventKind, msg string, res *client.DispenseResult) {
+		if onEvent != nil {
+			onEvent(Event{Time: r.clk.Now(), Kind: kind, Message: msg, Result: res, Stats: r.Stats()})
+		}
+	}
+
+	r.mu.Lock()
+	r.stats.Started = r.clk.Now()
+	r.mu.Unlock()
+
+	// The first health check takes the error history from before the soak
+	r.checkHealth(ctx, nil)
+
+	reason, aborted := r.loop(ctx, emit)
+	if ctx.Err() == nil {
+		// Final sample, so the timeline covers the last dispense
+		r.checkHealth(ctx, nil)
+	}
+
+	emit(EventDone, reason, nil)
+	return r.report(reason, aborted), nil
+}
+
+// loop is the body of Run; it returns why the soak ended
+func (r *Runner) loop(ctx context.Context, emit func(EventKind, string, *client.DispenseResult)) (reason string, aborted bool) {
+	for i := 0; ; {
+		if reason := r.limitReached(); reason != "" {
+			return reason, false
+		}
+		if ctx.Err() != nil {
+			return "interrupted", false
+		}
+
+		h, ok := r.checkHealth(ctx, emit)
+		if !ok {
+			if err := r.sleep(ctx, r.cfg.CheckInterval); err != nil {
+				return "interrupted", false
+			}
+			continue
+		}
+
+		if cond, action := r.blocking(h); cond != "" {
+			if action == ActionAbort {
+				return "aborted: " + cond, true
+			}
+			if reason, stop := r.pause(ctx, cond, emit); stop {
+				return reason, reason != "interrupted"
+			}
+			continue
+		}
+
+		qty := r.cfg.Pattern[i%len(r.cfg.Pattern)]
+		if r.cfg.MaxTokens > 0 {
+			qty = min(qty, r.cfg.MaxTokens-r.Stats().TokensDispensed)
+		}
+		i++
+
+		id, err := r.txIDs.Next()
+		if err != nil {
+			return err.Error(), true
+		}
+		res, err := r.c.DispenseAndWait(ctx, id.String(), qty, client.WaitOptions{})
+		if ctx.Err() != nil && (res == nil || res.State == "") {
+			return "interrupted", false
+		}
+		r.recordDispense(res, err, emit)
+
+		if err := r.sleep(ctx, r.cfg.Interval); err != nil {
+			return "interrupted", false
+		}
+	}
+}
+
+// limitReached returns a reason once the duration or token limit is hit
+func (r *Runner) limitReached() string {
+	s := r.Stats()
+	if r.cfg.Duration > 0 && s.Elapsed >= r.cfg.Duration {
+		return fmt.Sprintf("duration of %s reached", r.cfg.Duration)
+	}
+	if r.cfg.MaxTokens > 0 && s.TokensDispensed >= r.cfg.MaxTokens {
+		return fmt.Sprintf("%d tokens dispensed", s.TokensDispensed)
+	}
+	return ""
+}
+
+// blocking returns the condition that prevents dispensing, if any
+func (r *Runner) blocking(h *client.HealthResponse) (string, Action) {
+	switch {
+	case h.Dispenser == "error":
+		return "dispenser in error state", r.cfg.OnFault
+	case h.HopperLow():
+		return "hopper low", r.cfg.OnHopperLow
+	}
+	return "", ""
+}
+
+// pause polls health until the condition clears. It reports stop when the
+// soak should end instead of resuming.
+func (r *Runner) pause(ctx context.Context, cond string, emit func(EventKind, string, *client.DispenseResult)) (reason string, stop bool) {
+	start := r.clk.Now()
+	r.mu.Lock()
+	r.stats.Paused = true
+	r.stats.PauseReason = cond
+	r.mu.Unlock()
+	emit(EventPause, "paused: "+cond, nil)
+
+	defer func() {
+		r.mu.Lock()
+		r.stats.Paused = false
+		r.stats.PauseReason = ""
+		r.pauses = append(r.pauses, Pause{Reason: cond, Start: start, End: r.clk.Now()})
+		r.mu.Unlock()
+	}()
+
+	for {
+		wait := r.cfg.CheckInterval
+		if r.cfg.PauseTimeout > 0 {
+			wait = min(wait, max(0, r.cfg.PauseTimeout-r.clk.Since(start)))
+		}
+		if err := r.sleep(ctx, wait); err != nil {
+			return "interrupted", true
+		}
+		if reason := r.limitReached(); reason != "" {
+			return reason, true
+		}
+		if r.cfg.PauseTimeout > 0 && r.clk.Since(start) >= r.cfg.PauseTimeout {
+			return fmt.Sprintf("aborted: %s for more than %s", cond, r.cfg.PauseTimeout), true
+		}
+
+		h, ok := r.checkHealth(ctx, emit)
+		if !ok {
+			continue
+		}
+		if now, _ := r.blocking(h); now == "" {
+			emit(EventResume, fmt.Sprintf("resumed after %s", r.clk.Since(start).Truncate(time.Second)), nil)
+			return "", false
+		} else if now != cond {
+			// A different condition applies now, let the caller decide
+			return "", false
+		}
+	}
+}
+
+// checkHealth fetches /health and records latency, RSSI, reboots and new
+// error_history entries. The history of the first response is from before
+// the soak and only marked as seen.
+func (r *Runner) checkHealth(ctx context.Context, emit func(EventKind, string, *client.DispenseResult)) (*client.HealthResponse, bool) {
+	h, result := r.c.Health(ctx)
+
+	r.mu.Lock()
+	r.stats.Elapsed = r.clk.Since(r.stats.Started)
+	sample := Sample{
+		Time:            r.clk.Now(),
+		Dispenses:       r.stats.Dispenses,
+		TokensDispensed: r.stats.TokensDispensed,
+		Jams:            r.stats.Partial + r.stats.Failed,
+		Partial:         r.stats.Partial,
+		LatencyMS:       float64(result.Latency.Microseconds()) / 1000,
+	}
+	if result.Error != nil {
+		r.stats.HealthFailures++
+		r.samples = append(r.samples, sample)
+		r.mu.Unlock()
+		if emit != nil && ctx.Err() == nil {
+			emit(EventError, "health: "+result.Error.Error(), nil)
+		}
+		return nil, false
+	}
+
+	sample.Reachable = true
+	r.timebase.Observe(h, sample.Time, result.Latency)
+	r.stats.LastLatency = result.Latency
+	if h.WiFi != nil {
+		sample.RSSI = h.WiFi.RSSI
+		r.stats.LastRSSI = h.WiFi.RSSI
+	}
+	r.samples = append(r.samples, sample)
+	if r.firmware == "" {
+		r.firmware = h.Firmware
+	}
+
+	var events []string
+	baseline := r.lastHealth == nil
+	rebooted := client.Rebooted(r.lastHealth, h)
+	if rebooted {
+		r.stats.Reboots++
+		// Error timestamps restart with uptime, forget the old ones
+		r.seenErrors = make(map[errorKey]bool)
+	}
+	r.lastHealth = h
+
+	// error_history is newest first; report in the order they happened
+	for i := len(h.ErrorHistory) - 1; i >= 0; i-- {
+		e := h.ErrorHistory[i]
+		key := errorKey{e.Code, e.Timestamp}
+		if r.seenErrors[key] {
+			continue
+		}
+		r.seenErrors[key] = true
+		if baseline {
+			continue
+		}
+		ec := r.errorCount[e.Code]
+		if ec == nil {
+			ec = &ErrorCount{Code: e.Code, Type: e.Type}
+			r.errorCount[e.Code] = ec
+		}
+		ec.Count++
+		events = append(events, fmt.Sprintf("error code %d %s at %s", e.Code, e.Type,
+			r.timebase.Format(e.Timestamp, "2006-01-02 15:04:05")))
+	}
+	r.mu.Unlock()
+
+	if emit != nil {
+		if rebooted {
+			emit(EventReboot, fmt.Sprintf("dispenser rebooted (uptime %ds)", h.Uptime), nil)
+		}
+		for _, msg := range events {
+			emit(EventError, msg, nil)
+		}
+	}
+	return h, true
+}
+
+func (r *Runner) recordDispense(res *client.DispenseResult, err error, emit func(EventKind, string, *client.DispenseResult)) {
+	var apiErr *client.APIError
+	rejected := err != nil && errors.As(err, &apiErr)
+
+	r.mu.Lock()
+	r.stats.Elapsed = r.clk.Since(r.stats.Started)
+	if rejected {
+		r.stats.Rejected++
+	} else {
+		r.stats.Dispenses++
+		r.stats.TokensRequested += res.Quantity
+		r.stats.TokensDispensed += res.Dispensed
+		switch {
+		case err != nil:
+			r.stats.Unknown++
+		case res.Outcome == client.OutcomeDone:
+			r.stats.Done++
+		case res.Outcome == client.OutcomePartial:
+			r.stats.Partial++
+		default:
+			r.stats.Failed++
+		}
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		emit(EventError, fmt.Sprintf("tx %s: %v", res.TxID, err), res)
+		return
+	}
+	emit(EventDispense, fmt.Sprintf("tx %s: %s %d/%d in %s", res.TxID, res.Outcome,
+		res.Dispensed, res.Quantity, res.Duration.Truncate(time.Millisecond)), res)
+}
+
+func (r *Runner) snapshotLocked() Stats {
+	s := r.stats
+	if !s.Started.IsZero() && s.Elapsed < r.clk.Since(s.Started) {
+		s.Elapsed = r.clk.Since(s.Started)
+	}
+	s.ErrorCodes = make([]ErrorCount, 0, len(r.errorCount))
+	for _, ec := range r.errorCount {
+		s.ErrorCodes = append(s.ErrorCodes, *ec)
+	}
+	sort.Slice(s.ErrorCodes, func(i, j int) bool { return s.ErrorCodes[i].Code < s.ErrorCodes[j].Code })
+	return s
+}
+
+func (r *Runner) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.clk.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,247 @@
+package soak
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/sim"
+)
+
+// newTestRunner returns a runner for a simulator on a fake clock
+func newTestRunner(t *testing.T, cfg Config) (*Runner, *sim.Server, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	scfg := sim.DefaultConfig()
+	scfg.Clock = clk
+	srv := sim.NewServer(scfg)
+	t.Cleanup(srv.Close)
+	r, err := New(srv.DispenserClient(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, srv, clk
+}
+
+// run runs the soak to its end while advancing the fake clock. onEvent is
+// called before the event is collected.
+func run(t *testing.T, r *Runner, clk *clock.Fake, onEvent func(Event)) (*Report, []Event) {
+	t.Helper()
+	var events []Event
+	type result struct {
+		rep *Report
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rep, err := r.Run(context.Background(), func(ev Event) {
+			if onEvent != nil {
+				onEvent(ev)
+			}
+			events = append(events, ev)
+		})
+		done <- result{rep, err}
+	}()
+
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case res := <-done:
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if n := len(events); n == 0 || events[n-1].Kind != EventDone {
+				t.Errorf("events do not end with done: %v", kinds(events))
+			}
+			return res.rep, events
+		case <-deadline:
+			t.Fatalf("soak did not end: %+v", r.Stats())
+		default:
+			clk.Advance(100 * time.Millisecond)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+}
+
+// kinds lists the event kinds in order
+func kinds(events []Event) string {
+	var out []string
+	for _, ev := range events {
+		out = append(out, string(ev.Kind))
+	}
+	return strings.Join(out, ",")
+}
+
+// only returns the events of kind
+func only(events []Event, kind EventKind) []Event {
+	var out []Event
+	for _, ev := range events {
+		if ev.Kind == kind {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Duration = 0
+	cfg.Interval = time.Second
+	return cfg
+}
+
+func TestRunTokenLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxTokens = 7
+	r, _, clk := newTestRunner(t, cfg)
+	rep, events := run(t, r, clk, nil)
+
+	// 1, 3, 1 and the last dispense cut to the 2 tokens left
+	var quantities []string
+	for _, ev := range only(events, EventDispense) {
+		quantities = append(quantities, strconv.Itoa(ev.Result.Quantity))
+	}
+	if got := strings.Join(quantities, ","); got != "1,3,1,2" {
+		t.Errorf("dispensed %s, want 1,3,1,2", got)
+	}
+	st := rep.Stats
+	if st.Dispenses != 4 || st.Done != 4 || st.TokensDispensed != 7 || st.TokensRequested != 7 {
+		t.Errorf("stats %+v, want 4 dispenses of 7 tokens", st)
+	}
+	if rep.StopReason != "7 tokens dispensed" || rep.Aborted {
+		t.Errorf("stopped with %q, aborted %v", rep.StopReason, rep.Aborted)
+	}
+	if rep.Config.Pattern != "1,3" || rep.Firmware == "" || len(rep.Timeline) < 5 {
+		t.Errorf("report config %+v, firmware %q, %d samples", rep.Config, rep.Firmware, len(rep.Timeline))
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	cfg := testConfig()
+	cfg.Duration = 30 * time.Second
+	r, _, clk := newTestRunner(t, cfg)
+	rep, _ := run(t, r, clk, nil)
+
+	if rep.StopReason != "duration of 30s reached" || rep.Aborted {
+		t.Errorf("stopped with %q, aborted %v", rep.StopReason, rep.Aborted)
+	}
+	// Each round is a token or three at 2.5s each plus the interval
+	if rep.Elapsed < 30*time.Second || rep.Elapsed > 45*time.Second || rep.Stats.Dispenses < 3 {
+		t.Errorf("ran %s with %d dispenses", rep.Elapsed, rep.Stats.Dispenses)
+	}
+}
+
+func TestFaultAborts(t *testing.T) {
+	cfg := testConfig()
+	cfg.Pattern = []int{3}
+	cfg.MaxTokens = 30
+	r, srv, clk := newTestRunner(t, cfg)
+	if err := srv.Sim.Inject(sim.Fault{Action: sim.ActionJam, AfterTokens: 4}); err != nil {
+		t.Fatal(err)
+	}
+	rep, events := run(t, r, clk, nil)
+
+	if rep.StopReason != "aborted: dispenser in error state" || !rep.Aborted {
+		t.Errorf("stopped with %q, aborted %v", rep.StopReason, rep.Aborted)
+	}
+	st := rep.Stats
+	if st.Dispenses != 2 || st.Done != 1 || st.Partial != 1 || st.TokensDispensed != 4 || rep.JamRate != 50 {
+		t.Errorf("stats %+v, jam rate %g; want the second dispense jammed after 1 token", st, rep.JamRate)
+	}
+	if len(only(events, EventPause)) != 0 {
+		t.Errorf("paused on a fault set to abort: %s", kinds(events))
+	}
+}
+
+func TestHopperLowPauses(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxTokens = 2
+	cfg.Pattern = []int{1}
+	r, srv, clk := newTestRunner(t, cfg)
+	srv.Sim.Inject(sim.Fault{Action: sim.ActionHopperLow})
+	srv.Sim.Inject(sim.Fault{Action: sim.ActionHopperOK, At: sim.Duration(20 * time.Second)})
+	rep, events := run(t, r, clk, nil)
+
+	if got := kinds(events); !strings.HasPrefix(got, "pause,resume,dispense,dispense,done") {
+		t.Errorf("events %s, want a pause until the hopper is refilled", got)
+	}
+	if len(rep.Pauses) != 1 || rep.Pauses[0].Reason != "hopper low" {
+		t.Fatalf("pauses %+v", rep.Pauses)
+	}
+	if d := rep.Pauses[0].End.Sub(rep.Pauses[0].Start); d < 20*time.Second || d > 20*time.Second+cfg.CheckInterval {
+		t.Errorf("paused for %s, want about 20s", d)
+	}
+	if rep.StopReason != "2 tokens dispensed" || rep.Aborted || rep.Stats.Paused {
+		t.Errorf("stopped with %q, aborted %v, paused %v", rep.StopReason, rep.Aborted, rep.Stats.Paused)
+	}
+}
+
+func TestPauseTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxTokens = 2
+	cfg.PauseTimeout = 30 * time.Second
+	r, srv, clk := newTestRunner(t, cfg)
+	srv.Sim.Inject(sim.Fault{Action: sim.ActionHopperLow})
+	rep, _ := run(t, r, clk, nil)
+
+	if rep.StopReason != "aborted: hopper low for more than 30s" || !rep.Aborted {
+		t.Errorf("stopped with %q, aborted %v", rep.StopReason, rep.Aborted)
+	}
+	if rep.Stats.Dispenses != 0 || len(rep.Pauses) != 1 {
+		t.Errorf("%d dispenses, pauses %+v", rep.Stats.Dispenses, rep.Pauses)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	cfg := testConfig()
+	cfg.Pattern = []int{1}
+	cfg.MaxTokens = 4
+	r, srv, clk := newTestRunner(t, cfg)
+
+	// An error from before the soak is not attributed to it
+	clk.Advance(10 * time.Minute)
+	srv.Sim.Inject(sim.Fault{Action: sim.ActionError, Code: 2})
+	srv.Sim.Inject(sim.Fault{Action: sim.ActionClearError})
+
+	dispensed := 0
+	rep, events := run(t, r, clk, func(ev Event) {
+		if ev.Kind != EventDispense {
+			return
+		}
+		// Between dispenses: an error, a power cycle, a lost response
+		switch dispensed++; dispensed {
+		case 1:
+			srv.Sim.Inject(sim.Fault{Action: sim.ActionError, Code: 1})
+			srv.Sim.Inject(sim.Fault{Action: sim.ActionClearError})
+		case 2:
+			srv.Sim.Reboot()
+		case 3:
+			// Twice, as the transport retries a GET once on a reused
+			// connection
+			srv.Sim.Inject(sim.Fault{Action: sim.ActionDrop, Path: "/health", Count: 2})
+		}
+	})
+
+	st := rep.Stats
+	if len(st.ErrorCodes) != 1 || st.ErrorCodes[0] != (ErrorCount{Code: 1, Type: "COIN_STUCK", Count: 1}) {
+		t.Errorf("error codes %+v, want COIN_STUCK once", st.ErrorCodes)
+	}
+	if st.Reboots != 1 || len(only(events, EventReboot)) != 1 {
+		t.Errorf("%d reboots, events %s", st.Reboots, kinds(events))
+	}
+	if st.HealthFailures != 1 || st.Done != 4 {
+		t.Errorf("stats %+v, want 1 health failure and 4 dispenses", st)
+	}
+	var unreachable int
+	for _, s := range rep.Timeline {
+		if !s.Reachable {
+			unreachable++
+		}
+	}
+	if unreachable != 1 {
+		t.Errorf("%d unreachable samples, want 1", unreachable)
+	}
+}
@@ -0,0 +1,181 @@
+// Package timebase maps firmware timestamps to wall-clock time. The
+// dispenser has no real-time clock: /health reports its uptime in whole
+// seconds since boot, and error timestamps are millis() since boot. A
+// TimeBase brackets the boot instant from successive /health samples and
+// the latency of each request, notices reboots, measures the drift of the
+// dispenser's crystal once enough time has passed, and converts firmware
+// timestamps to wall-clock times with an uncertainty bound.
+package timebase
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+const (
+	// rebootSlack is how far the boot instant may move later before a
+	// reboot the uptime did not show is assumed, i.e. the dispenser ran
+	// longer than before between two samples
+	rebootSlack = 10 * time.Second
+	// crystalTolerance bounds the rate error of the dispenser's clock
+	// until it is measured
+	crystalTolerance = 100e-6
+)
+
+// Estimate is the current knowledge of the dispenser's boot
+type Estimate struct {
+	// Boot is the most likely boot instant, within ± Uncertainty
+	Boot        time.Time
+	Uncertainty time.Duration
+	// Drift is the measured rate error of the dispenser's clock, positive
+	// when it runs fast; 0 until DriftKnown
+	Drift      float64
+	DriftKnown bool
+	// Samples since the last reboot, and reboots seen
+	Samples int
+	Reboots int
+}
+
+// TimeBase estimates the dispenser's boot instant. The zero value is
+// ready to use; it is safe for concurrent use.
+type TimeBase struct {
+	mu      sync.Mutex
+	lo, hi  time.Time // boot instant bracket
+	first   sample    // first sample of this boot, for drift
+	last    sample
+	samples int
+	reboots int
+}
+
+type sample struct {
+	health *client.HealthResponse
+	uptime int
+	at     time.Time // midpoint of the request
+}
+
+// Observe adds a /health sample: the response, when it arrived and how long
+// the request took. It reports whether the sample shows a reboot, after
+// which the estimate starts over.
+func (tb *TimeBase) Observe(h *client.HealthResponse, received time.Time, latency time.Duration) (rebooted bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	uptime := h.Uptime
+
+	// The firmware read its uptime somewhere between sending and
+	// receiving, and truncated it to whole seconds
+	sent := received.Add(-latency)
+	slo := sent.Add(-time.Duration(uptime+1) * time.Second)
+	shi := received.Add(-time.Duration(uptime) * time.Second)
+	s := sample{health: h, uptime: uptime, at: sent.Add(latency / 2)}
+
+	if tb.samples > 0 && (client.Rebooted(tb.last.health, h) || slo.Sub(tb.hi) > rebootSlack) {
+		tb.reboots++
+		tb.samples = 0
+		rebooted = true
+	}
+	if tb.samples == 0 {
+		tb.lo, tb.hi = slo, shi
+		tb.first, tb.last = s, s
+		tb.samples = 1
+		return rebooted
+	}
+
+	lo, hi := tb.lo, tb.hi
+	if slo.After(lo) {
+		lo = slo
+	}
+	if shi.Before(hi) {
+		hi = shi
+	}
+	if lo.After(hi) {
+		// Drift, or a step of the local clock, moved the boot out of the
+		// bracket: start over from this sample
+		lo, hi = slo, shi
+	}
+	tb.lo, tb.hi = lo, hi
+	tb.last = s
+	tb.samples++
+	return false
+}
+
+// drift returns the measured rate error and its uncertainty. Uptimes are
+// whole seconds, so the measurement is only better than the crystal
+// tolerance after several hours.
+func (tb *TimeBase) drift() (rate, uncertainty float64, known bool) {
+	span := tb.last.at.Sub(tb.first.at).Seconds()
+	if span <= 0 {
+		return 0, crystalTolerance, false
+	}
+	uncertainty = 2 / span
+	if uncertainty >= crystalTolerance {
+		return 0, crystalTolerance, false
+	}
+	return float64(tb.last.uptime-tb.first.uptime)/span - 1, uncertainty, true
+}
+
+// Estimate returns the current boot estimate; ok is false before the first
+// sample
+func (tb *TimeBase) Estimate() (est Estimate, ok bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.samples == 0 {
+		return Estimate{Reboots: tb.reboots}, false
+	}
+	half := tb.hi.Sub(tb.lo) / 2
+	rate, _, known := tb.drift()
+	return Estimate{
+		Boot:        tb.lo.Add(half),
+		Uncertainty: half,
+		Drift:       rate,
+		DriftKnown:  known,
+		Samples:     tb.samples,
+		Reboots:     tb.reboots,
+	}, true
+}
+
+// Time converts a firmware timestamp in milliseconds since the current
+// boot, as in error and error_history, to wall-clock time, within
+// ± uncertainty. The further the timestamp is from the newest sample, the
+// more the dispenser's clock drift adds to the uncertainty. ok is false
+// before the first sample.
+func (tb *TimeBase) Time(millis int64) (t time.Time, uncertainty time.Duration, ok bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.samples == 0 {
+		return time.Time{}, 0, false
+	}
+
+	half := tb.hi.Sub(tb.lo) / 2
+	rate, rateErr, _ := tb.drift()
+	// Dispenser seconds between the timestamp and the newest sample, of
+	// which a fast clock counted rate too many
+	dist := float64(tb.last.uptime) - float64(millis)/1000
+	t = tb.lo.Add(half).
+		Add(time.Duration(millis) * time.Millisecond).
+		Add(seconds(dist * rate))
+	return t, half + seconds(math.Abs(dist)*rateErr), true
+}
+
+// Format renders a firmware timestamp in milliseconds since boot as
+// wall-clock time in layout, followed by "±Ns" when the uncertainty reaches
+// a second. Before the first sample it falls back to the uptime.
+func (tb *TimeBase) Format(millis int64, layout string) string {
+	t, unc, ok := tb.Time(millis)
+	if !ok {
+		return fmt.Sprintf("uptime %ds", millis/1000)
+	}
+	s := t.Local().Format(layout)
+	if unc >= time.Second {
+		s += fmt.Sprintf(" ±%s", unc.Round(time.Second))
+	}
+	return s
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,154 @@
+package timebase
+
+import (
+	"testing"
+	"time"
+
+	"token-tui/dispenser/client"
+)
+
+var boot = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// dispenser models the firmware's clock: it booted at boot and its crystal
+// runs fast by rate
+type dispenser struct {
+	boot time.Time
+	rate float64
+}
+
+// uptime is what /health reports at t, truncated to whole seconds
+func (d dispenser) uptime(t time.Time) int {
+	return int(t.Sub(d.boot).Seconds() * (1 + d.rate))
+}
+
+// millis is the error timestamp the firmware stamps at t
+func (d dispenser) millis(t time.Time) int64 {
+	return int64(t.Sub(d.boot).Seconds() * (1 + d.rate) * 1000)
+}
+
+// observe polls /health at sent and gets the answer after latency; the
+// firmware reads its uptime halfway through
+func (d dispenser) observe(tb *TimeBase, sent time.Time, latency time.Duration) bool {
+	h := &client.HealthResponse{Uptime: d.uptime(sent.Add(latency / 2))}
+	return tb.Observe(h, sent.Add(latency), latency)
+}
+
+// within fails unless got is want ± tolerance
+func within(t *testing.T, what string, got, want time.Time, tolerance time.Duration) {
+	t.Helper()
+	if d := got.Sub(want).Abs(); d > tolerance {
+		t.Errorf("%s = %s, want %s ± %s (off by %s)", what, got.Format(time.StampMilli),
+			want.Format(time.StampMilli), tolerance, d)
+	}
+}
+
+func TestBracketNarrowsBoot(t *testing.T) {
+	var tb TimeBase
+	d := dispenser{boot: boot}
+
+	if _, ok := tb.Estimate(); ok {
+		t.Fatal("estimate before the first sample")
+	}
+	if got := tb.Format(82150, "15:04:05"); got != "uptime 82s" {
+		t.Errorf("Format before the first sample = %q, want uptime 82s", got)
+	}
+
+	d.observe(&tb, boot.Add(100*time.Second+300*time.Millisecond), 200*time.Millisecond)
+	first, _ := tb.Estimate()
+	within(t, "boot after one sample", first.Boot, boot, first.Uncertainty)
+	if first.Uncertainty > time.Second {
+		t.Errorf("uncertainty after one sample = %s, want at most 1s", first.Uncertainty)
+	}
+
+	// Samples at other fractions of a second cut the bracket down
+	for _, at := range []time.Duration{
+		200*time.Second + 800*time.Millisecond,
+		301*time.Second + 100*time.Millisecond,
+		402*time.Second + 550*time.Millisecond,
+	} {
+		if d.observe(&tb, boot.Add(at), 100*time.Millisecond) {
+			t.Fatalf("reboot reported at %s", at)
+		}
+	}
+	est, _ := tb.Estimate()
+	within(t, "boot", est.Boot, boot, est.Uncertainty)
+	if est.Uncertainty >= first.Uncertainty || est.Samples != 4 {
+		t.Errorf("after 4 samples: ± %s, %d samples; want less than ± %s", est.Uncertainty, est.Samples, first.Uncertainty)
+	}
+
+	// Error timestamps are milliseconds
+	errAt := boot.Add(82150 * time.Millisecond)
+	got, unc, ok := tb.Time(d.millis(errAt))
+	if !ok {
+		t.Fatal("Time not ok after samples")
+	}
+	within(t, "error time", got, errAt, unc)
+}
+
+func TestReboot(t *testing.T) {
+	var tb TimeBase
+	d := dispenser{boot: boot}
+	d.observe(&tb, boot.Add(10*time.Minute), 50*time.Millisecond)
+	d.observe(&tb, boot.Add(11*time.Minute), 50*time.Millisecond)
+
+	// Power cycled shortly before the next poll: the uptime went down
+	d.boot = boot.Add(11*time.Minute + 30*time.Second)
+	if !d.observe(&tb, boot.Add(12*time.Minute), 50*time.Millisecond) {
+		t.Fatal("lower uptime not reported as a reboot")
+	}
+	est, _ := tb.Estimate()
+	if est.Reboots != 1 || est.Samples != 1 {
+		t.Errorf("after the reboot: %d reboots, %d samples; want 1, 1", est.Reboots, est.Samples)
+	}
+	within(t, "boot after the reboot", est.Boot, d.boot, est.Uncertainty)
+
+	// Power cycled while nobody polled for longer than the last uptime:
+	// the uptime is higher, but the boot moved
+	last := boot.Add(12 * time.Minute)
+	d.boot = last.Add(5 * time.Minute)
+	if !d.observe(&tb, d.boot.Add(time.Hour), 50*time.Millisecond) {
+		t.Fatal("boot that moved later not reported as a reboot")
+	}
+	est, _ = tb.Estimate()
+	if est.Reboots != 2 {
+		t.Errorf("reboots = %d, want 2", est.Reboots)
+	}
+	within(t, "boot after the second reboot", est.Boot, d.boot, est.Uncertainty)
+}
+
+func TestDrift(t *testing.T) {
+	var tb TimeBase
+	// 50 ppm fast, within the crystal tolerance; gains 4.3s a day
+	d := dispenser{boot: boot, rate: 50e-6}
+
+	poll := func(from, to time.Duration) {
+		for at := from; at <= to; at += 10*time.Minute + 137*time.Millisecond {
+			if d.observe(&tb, boot.Add(at), 80*time.Millisecond) {
+				t.Fatalf("reboot reported at %s", at)
+			}
+		}
+	}
+
+	poll(time.Minute, time.Hour)
+	if est, _ := tb.Estimate(); est.DriftKnown {
+		t.Fatalf("drift %g known after an hour, want unknown", est.Drift)
+	}
+
+	poll(time.Hour+time.Minute, 24*time.Hour)
+	est, _ := tb.Estimate()
+	if !est.DriftKnown {
+		t.Fatal("drift unknown after a day")
+	}
+	if est.Drift < 30e-6 || est.Drift > 70e-6 {
+		t.Errorf("drift = %g, want about 50e-6", est.Drift)
+	}
+
+	// An error from early in the day: without correcting for the drift
+	// it would land seconds off
+	errAt := boot.Add(2 * time.Hour)
+	got, unc, _ := tb.Time(d.millis(errAt))
+	within(t, "error time", got, errAt, unc)
+	if unc > 3*time.Second {
+		t.Errorf("uncertainty = %s, want a few seconds at most", unc)
+	}
+}
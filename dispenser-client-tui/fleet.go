@@ -0,0 +1,515 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/lifetime"
+	"token-tui/dispenser/soak"
+)
+
+// fleetDevice is one dispenser of a fleet, from --dispenser NAME=URL or a
+// [[dispenser]] of the --fleet file
+type fleetDevice struct {
+	Name     string `toml:"name"`
+	Endpoint string `toml:"endpoint"`
+	// APIKey defaults to --api-key or TOKEN_DISPENSER_API_KEY
+	APIKey   string `toml:"api_key"`
+	Journal  string `toml:"journal"`
+	Lifetime string `toml:"lifetime"`
+	Relay    string `toml:"relay"`
+}
+
+type fleetFile struct {
+	Dispensers []fleetDevice `toml:"dispenser"`
+}
+
+// dispenserFlags collects repeated --dispenser NAME=URL flags
+type dispenserFlags []fleetDevice
+
+func (d *dispenserFlags) String() string {
+	var parts []string
+	for _, dev := range *d {
+		parts = append(parts, dev.Name+"="+dev.Endpoint)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (d *dispenserFlags) Set(v string) error {
+	name, endpoint, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want NAME=URL")
+	}
+	*d = append(*d, fleetDevice{Name: name, Endpoint: endpoint})
+	return nil
+}
+
+// loadFleet returns the dispensers of the fleet file, if any, followed by
+// those given with --dispenser
+func loadFleet(path string, flags dispenserFlags) ([]fleetDevice, error) {
+	var devices []fleetDevice
+	if path != "" {
+		var f fleetFile
+		if _, err := toml.DecodeFile(path, &f); err != nil {
+			return nil, fmt.Errorf("fleet %s: %w", path, err)
+		}
+		devices = f.Dispensers
+	}
+	devices = append(devices, flags...)
+
+	names := make(map[string]bool)
+	for i, dev := range devices {
+		u, err := url.Parse(dev.Endpoint)
+		switch {
+		case dev.Name == "":
+			return nil, fmt.Errorf("dispenser %d: name is empty", i+1)
+		case names[dev.Name]:
+			return nil, fmt.Errorf("dispenser %s: duplicate name", dev.Name)
+		case err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https"):
+			return nil, fmt.Errorf("dispenser %s: endpoint %q is not an http(s) URL", dev.Name, dev.Endpoint)
+		}
+		names[dev.Name] = true
+	}
+	return devices, nil
+}
+
+// runFleet runs the TUI with a fleet tab over several dispensers. Journal,
+// lifetime file and relay are per dispenser; the other connection flags
+// are shared.
+func runFleet(conn *connFlags, devices []fleetDevice, txPrefix string, logSize int, soakCfg soak.Config, soakReport string) int {
+	if err := conn.resolve(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	models := make([]Model, 0, len(devices))
+	for _, dev := range devices {
+		apiKey := dev.APIKey
+		if apiKey == "" {
+			apiKey = conn.apiKey
+		}
+		c, err := conn.clientFor(dev.Endpoint, apiKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", dev.Name, err)
+			return 2
+		}
+		if dev.Journal != "" {
+			j, err := client.OpenJournal(dev.Journal)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", dev.Name, err)
+				return 1
+			}
+			defer j.Close()
+			c.Journal = j
+		}
+		txIDs, err := client.NewTxIDGenerator(client.TxIDOptions{Prefix: txPrefix, Sequence: txPrefix != ""})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+
+		model := NewModel(c, txIDs, clock.Real)
+		model.name = dev.Name
+		model.fleet = true
+		model.relayURL = dev.Relay
+		model.healthEvery = conn.healthInterval
+		model.pollEvery = conn.pollInterval
+		model.expectedFirmware = conn.expectedFirmware
+		model.logSize = logSize
+		if dev.Lifetime != "" {
+			tracker, err := lifetime.Open(dev.Lifetime)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", dev.Name, err)
+				return 1
+			}
+			model.lifetime = tracker
+		}
+		model.soak.Config = soakCfg
+		if soakReport != "" {
+			model.soak.ReportBase = soakReport + "-" + dev.Name
+		}
+		models = append(models, model)
+	}
+
+	p := tea.NewProgram(
+		newFleetModel(models),
+		tea.WithAltScreen(),
+		tea.WithMouseCellMotion(),
+	)
+	if _, err := p.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// fleetModel shows an overview of several dispensers and drills into the
+// usual tabs of one of them. Each dispenser is a Model of its own that
+// polls concurrently; their messages are routed back as deviceMsg.
+type fleetModel struct {
+	devices  []Model
+	selected int
+	active   int // index of the open dispenser, -1 on the fleet tab
+
+	width    int
+	height   int
+	quitting bool
+}
+
+// deviceMsg is a message of one dispenser's Model
+type deviceMsg struct {
+	index int
+	msg   tea.Msg
+}
+
+func newFleetModel(devices []Model) fleetModel {
+	return fleetModel{devices: devices, active: -1}
+}
+
+// wrap tags the messages of a dispenser's command with its index. Batches
+// are unpacked so that bubbletea still runs their commands concurrently.
+func wrap(index int, cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		switch msg := cmd().(type) {
+		case nil:
+			return nil
+		case tea.BatchMsg:
+			cmds := make([]tea.Cmd, len(msg))
+			for i, c := range msg {
+				cmds[i] = wrap(index, c)
+			}
+			return tea.BatchMsg(cmds)
+		default:
+			return deviceMsg{index: index, msg: msg}
+		}
+	}
+}
+
+// asModel unwraps the result of Model.Update, which is a Model or, from
+// the key handlers, a *Model
+func asModel(tm tea.Model) Model {
+	if p, ok := tm.(*Model); ok {
+		return *p
+	}
+	return tm.(Model)
+}
+
+func (f fleetModel) Init() tea.Cmd {
+	cmds := make([]tea.Cmd, len(f.devices))
+	for i, m := range f.devices {
+		cmds[i] = wrap(i, m.Init())
+	}
+	return tea.Batch(cmds...)
+}
+
+func (f fleetModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		f.width = msg.Width
+		f.height = msg.Height
+		for i := range f.devices {
+			f.update(i, msg)
+		}
+		return f, nil
+
+	case tea.KeyMsg:
+		return f.handleKey(msg)
+
+	case deviceMsg:
+		if _, ok := msg.msg.(tea.QuitMsg); ok {
+			// A dispenser finished its soak report after q
+			return f, f.quitWhenDone()
+		}
+		return f, f.update(msg.index, msg.msg)
+	}
+	return f, nil
+}
+
+// update passes msg to one dispenser
+func (f *fleetModel) update(index int, msg tea.Msg) tea.Cmd {
+	next, cmd := f.devices[index].Update(msg)
+	f.devices[index] = asModel(next)
+	return wrap(index, cmd)
+}
+
+// quitWhenDone quits once no dispenser is still writing a soak report
+func (f *fleetModel) quitWhenDone() tea.Cmd {
+	for _, m := range f.devices {
+		if m.soak.Running {
+			return nil
+		}
+	}
+	return tea.Quit
+}
+
+func (f fleetModel) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := msg.String()
+
+	if f.active >= 0 && f.devices[f.active].capturingKeys() && key != "ctrl+c" {
+		return f, f.update(f.active, msg)
+	}
+
+	switch key {
+	case "q", "ctrl+c":
+		f.quitting = true
+		for i := range f.devices {
+			m := &f.devices[i]
+			m.quitting = true
+			if m.soak.Running {
+				// Quit once the soak has written its report
+				m.soak.Stopping = true
+				m.soak.cancel()
+			}
+		}
+		return f, f.quitWhenDone()
+	case "0":
+		f.active = -1
+		return f, nil
+	}
+
+	if f.active >= 0 {
+		return f, f.update(f.active, msg)
+	}
+
+	switch key {
+	case "up", "k":
+		if f.selected > 0 {
+			f.selected--
+		}
+	case "down", "j":
+		if f.selected < len(f.devices)-1 {
+			f.selected++
+		}
+	case "enter":
+		f.active = f.selected
+	case "1", "2", "3", "4", "5":
+		// Open the selected dispenser on that tab
+		f.active = f.selected
+		return f, f.update(f.active, msg)
+	case "r", "R":
+		cmds := make([]tea.Cmd, len(f.devices))
+		for i, m := range f.devices {
+			cmds[i] = wrap(i, m.fetchHealth())
+		}
+		return f, tea.Batch(cmds...)
+	}
+	return f, nil
+}
+
+// --- Fleet View ---
+
+func (f fleetModel) View() string {
+	if f.active >= 0 {
+		return f.devices[f.active].View()
+	}
+	if f.quitting && f.quitWhenDone() != nil {
+		return ""
+	}
+
+	w := f.width
+	if w < 40 {
+		w = 80
+	}
+
+	var b strings.Builder
+	b.WriteString(f.renderTitleBar(w))
+	b.WriteString("\n")
+	b.WriteString(f.renderTabBar(w))
+	b.WriteString("\n")
+	b.WriteString(f.renderTable(w))
+	b.WriteString("\n")
+	b.WriteString(f.renderFooter(w))
+	// The title, tab and footer lines are cut on narrow terminals
+	return lipgloss.NewStyle().MaxWidth(w).Render(b.String())
+}
+
+func (f fleetModel) renderTitleBar(w int) string {
+	title := titleStyle.Render(" 🪙 Token Dispenser TUI ")
+
+	connected := 0
+	for _, m := range f.devices {
+		if m.connected {
+			connected++
+		}
+	}
+	style := statusOK
+	if connected < len(f.devices) {
+		style = statusWarning
+	}
+	if connected == 0 {
+		style = statusError
+	}
+	rightSide := style.Render(fmt.Sprintf("● %d/%d connected", connected, len(f.devices)))
+
+	gap := w - lipgloss.Width(title) - lipgloss.Width(rightSide) - 1
+	if gap < 1 {
+		gap = 1
+	}
+	return title + strings.Repeat(" ", gap) + rightSide
+}
+
+func (f fleetModel) renderTabBar(w int) string {
+	tab := lipgloss.NewStyle().
+		Background(colorPrimary).
+		Foreground(colorText).
+		Bold(true).
+		Render(" 0:Fleet ")
+	hint := statusMuted.Render(fmt.Sprintf(" %d dispensers, ⏎ or 1-5 opens the selected one", len(f.devices)))
+	sep := statusMuted.Render(strings.Repeat("─", w))
+	return tab + hint + "\n" + sep
+}
+
+// fleetColumns are the table columns and their widths; the last one takes
+// the remaining width
+var fleetColumns = []struct {
+	title string
+	width int
+}{
+	{"NAME", 16},
+	{"STATUS", 12},
+	{"STATE", 15},
+	{"RSSI", 14},
+	{"UPTIME", 9},
+	{"FIRMWARE", 10},
+	{"SUCCESS", 9},
+	{"LATENCY", 9},
+	{"ERROR", 0},
+}
+
+// fleetLayout returns the indexes and widths of the columns that fit in w
+// with the selection marker and a spare column. Columns that do not fit
+// are left out from the right; the error column gets what remains, if
+// anything.
+func fleetLayout(w int) (cols, widths []int) {
+	avail := w - 3
+	used := 0
+	last := len(fleetColumns) - 1
+	for i, col := range fleetColumns[:last] {
+		sep := min(i, 1)
+		if used+sep+col.width > avail {
+			break
+		}
+		used += sep + col.width
+		cols, widths = append(cols, i), append(widths, col.width)
+	}
+	if rest := avail - used - min(len(cols), 1); rest > 0 {
+		cols, widths = append(cols, last), append(widths, rest)
+	}
+	return cols, widths
+}
+
+func (f fleetModel) renderTable(w int) string {
+	var lines []string
+	cols, widths := fleetLayout(w)
+
+	colHeader := lipgloss.NewStyle().Foreground(colorDim).Bold(true)
+	var header []string
+	for i, j := range cols {
+		header = append(header, padCell(colHeader.Render(truncate(fleetColumns[j].title, widths[i])), widths[i]))
+	}
+	lines = append(lines, "  "+strings.Join(header, " "))
+
+	last := len(fleetColumns) - 1
+	for i, m := range f.devices {
+		cells := m.fleetRow()
+		var row []string
+		for k, j := range cols {
+			if j == last {
+				cells[j] = truncate(cells[j], widths[k])
+			}
+			row = append(row, padCell(cells[j], widths[k]))
+		}
+		marker := "  "
+		if i == f.selected {
+			marker = statusSecondary.Render("▶ ")
+		}
+		lines = append(lines, marker+strings.Join(row, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fleetRow renders the dispenser's cells of the fleet table. The error
+// column is plain text so that it can be truncated.
+func (m Model) fleetRow() []string {
+	name := valueBold.Render(truncate(m.name, 16))
+	latency := statusMuted.Render("─")
+	if n := len(m.latencySamples); n > 0 {
+		latency = fmt.Sprintf("%.0fms", m.latencySamples[n-1])
+	}
+
+	if m.health == nil {
+		status, errText := statusMuted.Render("… connecting"), ""
+		if m.healthErr != nil {
+			status, errText = statusError.Render("● DOWN"), m.healthErr.Error()
+		}
+		return []string{name, status, "", "", "", "", "", latency, errText}
+	}
+
+	h := m.health
+	status := renderStatusBadge(h.Status)
+	if !m.connected {
+		status = statusError.Render("● DOWN")
+	}
+	state := renderDispenserState(h.Dispenser)
+	if h.ActiveTx != nil {
+		state = dispensingStyle.Render(fmt.Sprintf("⟳ %d/%d", h.ActiveTx.Dispensed, h.ActiveTx.Quantity))
+	}
+	rssi := statusMuted.Render("─")
+	if h.WiFi != nil {
+		rssi = renderWiFiSignal(h.WiFi.RSSI)
+	}
+	life := m.lifetime.Lifetime()
+	success := statusMuted.Render("─")
+	if life.TotalDispenses > 0 {
+		success = rateStyle(life).Render(fmt.Sprintf("%.1f%%", life.SuccessRate()))
+	}
+	errText := ""
+	switch {
+	case !m.connected && m.healthErr != nil:
+		errText = m.healthErr.Error()
+	case h.Error != nil && h.Error.Active:
+		errText = fmt.Sprintf("%s (code %d) since %s", h.Error.Type, h.Error.Code,
+			m.timebase.Format(h.Error.Timestamp, "15:04:05"))
+	}
+	firmware := truncate(h.Firmware, 10)
+	if m.firmwareMismatch() {
+		firmware = statusWarning.Render(truncate(h.Firmware, 8) + " ⚠")
+	}
+	return []string{name, status, state, rssi, formatDuration(h.Uptime), firmware, success, latency, errText}
+}
+
+// padCell pads a styled cell to width visible columns
+func padCell(s string, width int) string {
+	if gap := width - lipgloss.Width(s); gap > 0 {
+		return s + strings.Repeat(" ", gap)
+	}
+	return s
+}
+
+func (f fleetModel) renderFooter(w int) string {
+	pairs := []struct{ key, desc string }{
+		{"↑↓", "select"},
+		{"⏎", "open"},
+		{"1-5", "open tab"},
+		{"0", "fleet"},
+		{"r", "refresh all"},
+		{"q", "quit"},
+	}
+	var parts []string
+	for _, p := range pairs {
+		parts = append(parts, keyStyle.Render(p.key)+" "+descStyle.Render(p.desc))
+	}
+	help := strings.Join(parts, statusMuted.Render(" │ "))
+	sep := statusMuted.Render(strings.Repeat("─", w))
+	return sep + "\n" + help
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+)
+
+// newFleetTestModel returns a named model for a dispenser that is not there
+func newFleetTestModel(t *testing.T, name string) Model {
+	t.Helper()
+	c := client.NewDispenserClient("http://127.0.0.1:1", "", time.Second)
+	txIDs, err := client.NewTxIDGenerator(client.TxIDOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewModel(c, txIDs, clock.Real)
+	m.name = name
+	return m
+}
+
+func TestFleetTableNarrowWidths(t *testing.T) {
+	const downErr = "dial tcp 192.168.4.20:80: connect: no route to host"
+	down := newFleetTestModel(t, "sauna-1")
+	down.healthErr = errors.New(downErr)
+
+	jammed := newFleetTestModel(t, "sauna-2")
+	jammed.connected = true
+	jammed.health = &client.HealthResponse{
+		Status:    "error",
+		Dispenser: "error",
+		Firmware:  "1.1.0-rc.1+build.42",
+		Error:     &client.ErrorInfo{Active: true, Type: "JAM", Code: 1, Timestamp: 42000},
+	}
+	jammed.expectedFirmware = "1.1.0"
+
+	f := newFleetModel([]Model{down, jammed})
+	for _, w := range []int{40, 60, 80, 100, 105, 106, 120, 200} {
+		f.width, f.height = w, 30
+		table := f.renderTable(w)
+		for _, line := range strings.Split(table, "\n") {
+			if lw := lipgloss.Width(line); lw > w {
+				t.Errorf("width %d: %d columns wide: %q", w, lw, line)
+			}
+		}
+
+		// The error column takes the rest of the line, cut to fit
+		cols, widths := fleetLayout(w)
+		if cols[len(cols)-1] != len(fleetColumns)-1 {
+			if strings.Contains(table, "dial") {
+				t.Errorf("width %d: error shown without room for it:\n%s", w, table)
+			}
+		} else if n := widths[len(widths)-1]; n < len(downErr) {
+			if want := truncate(downErr, n); !strings.Contains(table, want) || strings.Contains(table, downErr) {
+				t.Errorf("width %d: error not truncated to %q:\n%s", w, want, table)
+			}
+		} else if !strings.Contains(table, downErr) {
+			t.Errorf("width %d: error not shown in full:\n%s", w, table)
+		}
+	}
+
+	// Columns that do not fit are left out from the right
+	for _, tc := range []struct {
+		w       int
+		shown   string
+		leftOut string
+	}{
+		{80, "UPTIME", "FIRMWARE"},
+		{106, "LATENCY", ""},
+	} {
+		header, _, _ := strings.Cut(f.renderTable(tc.w), "\n")
+		if !strings.Contains(header, tc.shown) || tc.leftOut != "" && strings.Contains(header, tc.leftOut) {
+			t.Errorf("width %d: header %q, want %s shown and %s left out", tc.w, header, tc.shown, tc.leftOut)
+		}
+	}
+
+	// The whole view fits, falling back to 80 columns below 40
+	for _, w := range []int{0, 20, 40, 60, 80, 120} {
+		f.width = w
+		limit := w
+		if w < 40 {
+			limit = 80
+		}
+		for _, line := range strings.Split(f.View(), "\n") {
+			if lw := lipgloss.Width(line); lw > limit {
+				t.Errorf("view at width %d: %d columns wide: %q", w, lw, line)
+			}
+		}
+	}
+}
@@ -3,9 +3,9 @@ module token-tui
 go 1.22
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/charmbracelet/bubbletea v1.2.4
 	github.com/charmbracelet/lipgloss v1.0.0
-	github.com/google/uuid v1.6.0
 )
 
 require (
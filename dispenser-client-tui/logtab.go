@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// LogView is the state of the Log tab
+type LogView struct {
+	Filter   LogFilter
+	Selected int  // index into the filtered entries
+	Follow   bool // keep the newest entry selected
+	Detail   bool // show the selected entry with its bodies
+
+	Searching bool // typing the search query
+
+	Notice    string // result of the last export
+	NoticeErr bool
+}
+
+// LogFilter narrows the Log tab and its export; the zero value lets every
+// entry through
+type LogFilter struct {
+	Query      string // case-insensitive substring of path or detail
+	Method     string
+	Status     string // "2xx" … "5xx", or "-" for entries without an HTTP status
+	ErrorsOnly bool
+	Endpoint   string // see logEndpoint
+}
+
+func (f LogFilter) active() bool {
+	return f != LogFilter{}
+}
+
+func (f LogFilter) match(e LogEntry) bool {
+	switch {
+	case f.ErrorsOnly && !e.IsError:
+		return false
+	case f.Method != "" && e.Method != f.Method:
+		return false
+	case f.Status != "" && statusClass(e.StatusCode) != f.Status:
+		return false
+	case f.Endpoint != "" && logEndpoint(e.Path) != f.Endpoint:
+		return false
+	case f.Query != "":
+		q := strings.ToLower(f.Query)
+		return strings.Contains(strings.ToLower(e.Path), q) ||
+			strings.Contains(strings.ToLower(e.Detail), q)
+	}
+	return true
+}
+
+// String summarizes the filter for the tab header
+func (f LogFilter) String() string {
+	var parts []string
+	if f.Query != "" {
+		parts = append(parts, "/"+f.Query)
+	}
+	if f.Method != "" {
+		parts = append(parts, "method="+f.Method)
+	}
+	if f.Status != "" {
+		parts = append(parts, "status="+f.Status)
+	}
+	if f.Endpoint != "" {
+		parts = append(parts, "endpoint="+f.Endpoint)
+	}
+	if f.ErrorsOnly {
+		parts = append(parts, "errors only")
+	}
+	return strings.Join(parts, "  ")
+}
+
+func statusClass(code int) string {
+	if code == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+// logEndpoint groups log paths by API endpoint, all transactions under
+// /dispense/{tx_id}
+func logEndpoint(path string) string {
+	if strings.HasPrefix(path, "/dispense/") {
+		return "/dispense/{tx_id}"
+	}
+	return path
+}
+
+// appendLog adds an entry and drops the oldest beyond logSize. A selection
+// that does not follow the newest entry stays on its entry.
+func (m *Model) appendLog(e LogEntry) {
+	m.log = append(m.log, e)
+	drop := len(m.log) - m.logSize
+	if drop <= 0 {
+		return
+	}
+	for _, old := range m.log[:drop] {
+		if !m.logView.Follow && m.logView.Selected > 0 && m.logView.Filter.match(old) {
+			m.logView.Selected--
+		}
+	}
+	m.log = m.log[drop:]
+}
+
+// filteredLog returns a copy of the entries the filter lets through,
+// oldest first
+func (m Model) filteredLog() []LogEntry {
+	entries := make([]LogEntry, 0, len(m.log))
+	for _, e := range m.log {
+		if m.logView.Filter.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// logSelected returns the index of the selected entry, -1 if there is none
+func (m Model) logSelected(entries []LogEntry) int {
+	switch {
+	case len(entries) == 0:
+		return -1
+	case m.logView.Follow, m.logView.Selected >= len(entries):
+		return len(entries) - 1
+	}
+	return max(0, m.logView.Selected)
+}
+
+// logValues returns the distinct values of key in the log, sorted, for
+// the filters to cycle through
+func (m Model) logValues(key func(LogEntry) string) []string {
+	seen := make(map[string]bool)
+	var values []string
+	for _, e := range m.log {
+		if v := key(e); !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// cycle returns the value after cur, and "" (no filter) after the last
+func cycle(values []string, cur string) string {
+	if cur == "" {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+	for i, v := range values {
+		if v == cur && i+1 < len(values) {
+			return values[i+1]
+		}
+	}
+	return ""
+}
+
+// capturingKeys reports whether the model takes keys the global and fleet
+// bindings would otherwise handle
+func (m Model) capturingKeys() bool {
+	return m.mode == viewLog && m.logView.Searching
+}
+
+func (m *Model) handleLogKeys(key string) (tea.Model, tea.Cmd) {
+	v := &m.logView
+	entries := m.filteredLog()
+	sel := m.logSelected(entries)
+
+	switch key {
+	case "up", "k":
+		if sel > 0 {
+			v.Selected, v.Follow = sel-1, false
+		}
+	case "down", "j":
+		if sel < len(entries)-1 {
+			v.Selected = sel + 1
+		}
+		// Following again at the bottom, unless the detail pane should
+		// stay on its entry
+		v.Follow = !v.Detail && v.Selected >= len(entries)-1
+	case "g":
+		v.Selected, v.Follow = 0, false
+	case "G":
+		v.Selected, v.Follow = max(0, len(entries)-1), !v.Detail
+	case "enter":
+		if sel >= 0 {
+			v.Detail = !v.Detail
+			v.Selected, v.Follow = sel, !v.Detail && sel == len(entries)-1
+		}
+	case "esc":
+		if v.Detail {
+			v.Detail = false
+		} else {
+			v.Filter = LogFilter{}
+			v.Follow = true
+		}
+	case "/":
+		v.Searching, v.Detail = true, false
+	case "m", "M":
+		v.Filter.Method = cycle(m.logValues(func(e LogEntry) string { return e.Method }), v.Filter.Method)
+		v.Follow, v.Detail = true, false
+	case "s", "S":
+		v.Filter.Status = cycle(m.logValues(func(e LogEntry) string { return statusClass(e.StatusCode) }), v.Filter.Status)
+		v.Follow, v.Detail = true, false
+	case "p", "P":
+		v.Filter.Endpoint = cycle(m.logValues(func(e LogEntry) string { return logEndpoint(e.Path) }), v.Filter.Endpoint)
+		v.Follow, v.Detail = true, false
+	case "e", "E":
+		v.Filter.ErrorsOnly = !v.Filter.ErrorsOnly
+		v.Follow, v.Detail = true, false
+	case "c", "C":
+		m.log = m.log[:0]
+		v.Selected, v.Follow, v.Detail = 0, true, false
+	case "x":
+		return m, m.exportLog("jsonl")
+	case "X":
+		return m, m.exportLog("csv")
+	}
+	return m, nil
+}
+
+// handleLogSearchKey edits the search query; the log filters as it is typed
+func (m *Model) handleLogSearchKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	v := &m.logView
+	switch msg.Type {
+	case tea.KeyEnter:
+		v.Searching = false
+	case tea.KeyEsc:
+		v.Searching = false
+		v.Filter.Query = ""
+	case tea.KeyBackspace:
+		if q := []rune(v.Filter.Query); len(q) > 0 {
+			v.Filter.Query = string(q[:len(q)-1])
+		}
+	case tea.KeySpace:
+		v.Filter.Query += " "
+	case tea.KeyRunes:
+		v.Filter.Query += string(msg.Runes)
+	}
+	v.Follow = true
+	return m, nil
+}
+
+// --- Export ---
+
+type logExportedMsg struct {
+	path    string
+	entries int
+	err     error
+}
+
+// exportLog writes the filtered log to a timestamped file in the working
+// directory, as JSON Lines or CSV
+func (m Model) exportLog(format string) tea.Cmd {
+	entries := m.filteredLog()
+	base := "token-tui-log"
+	if m.name != "" {
+		base += "-" + m.name
+	}
+	path := fmt.Sprintf("%s-%s.%s", base, m.clock.Now().Format("20060102-150405"), format)
+	return func() tea.Msg {
+		return logExportedMsg{path: path, entries: len(entries), err: writeLogExport(path, format, entries)}
+	}
+}
+
+func (m *Model) handleLogExported(msg logExportedMsg) {
+	if msg.err != nil {
+		m.logView.Notice, m.logView.NoticeErr = "export failed: "+msg.err.Error(), true
+		return
+	}
+	m.logView.Notice = fmt.Sprintf("exported %d entries to %s", msg.entries, msg.path)
+	m.logView.NoticeErr = false
+}
+
+// logRecord is an exported log entry. Bodies that are JSON are embedded as
+// such, others as strings.
+type logRecord struct {
+	Time         time.Time `json:"time"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Status       int       `json:"status,omitempty"`
+	LatencyMS    float64   `json:"latency_ms"`
+	Error        bool      `json:"error,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+	RequestBody  any       `json:"request_body,omitempty"`
+	ResponseBody any       `json:"response_body,omitempty"`
+}
+
+func writeLogExport(path, format string, entries []LogEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "method", "path", "status", "latency_ms", "error", "detail", "request_body", "response_body"})
+		for _, e := range entries {
+			cw.Write([]string{
+				e.Time.Format(time.RFC3339Nano), e.Method, e.Path, strconv.Itoa(e.StatusCode),
+				strconv.FormatFloat(latencyMS(e.Latency), 'f', 1, 64), strconv.FormatBool(e.IsError),
+				e.Detail, strings.TrimSpace(e.RequestBody), strings.TrimSpace(e.ResponseBody),
+			})
+		}
+		cw.Flush()
+		err = cw.Error()
+	default:
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err = enc.Encode(logRecord{
+				Time:         e.Time,
+				Method:       e.Method,
+				Path:         e.Path,
+				Status:       e.StatusCode,
+				LatencyMS:    latencyMS(e.Latency),
+				Error:        e.IsError,
+				Detail:       e.Detail,
+				RequestBody:  exportBody(e.RequestBody),
+				ResponseBody: exportBody(e.ResponseBody),
+			}); err != nil {
+				break
+			}
+		}
+	}
+
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func exportBody(body string) any {
+	switch {
+	case body == "":
+		return nil
+	case json.Valid([]byte(body)):
+		return json.RawMessage(body)
+	default:
+		return body
+	}
+}
+
+func latencyMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// --- View ---
+
+func (m Model) renderLogView(w, h int) string {
+	var lines []string
+	v := m.logView
+	entries := m.filteredLog()
+
+	count := fmt.Sprintf("%d entries", len(m.log))
+	if v.Filter.active() {
+		count = fmt.Sprintf("%d of %d entries", len(entries), len(m.log))
+	}
+	lines = append(lines, sectionHeader.Render("📋 Request Log ("+count+")")+
+		"  "+statusMuted.Render("[/]search [M]ethod [S]tatus [P]ath [E]rrors [⏎]detail [x/X]export [C]lear"))
+
+	switch {
+	case v.Searching:
+		lines = append(lines, "  "+keyStyle.Render("/")+v.Filter.Query+statusSecondary.Render("█")+
+			statusMuted.Render("  ⏎ apply  esc cancel"))
+	case v.Filter.active():
+		lines = append(lines, "  "+statusSecondary.Render("filter: "+v.Filter.String())+statusMuted.Render("  esc clear"))
+	default:
+		lines = append(lines, "")
+	}
+	if v.Notice != "" {
+		style := statusOK
+		if v.NoticeErr {
+			style = statusError
+		}
+		lines = append(lines, "  "+style.Render(truncate(v.Notice, max(0, w-8))))
+	}
+
+	sel := m.logSelected(entries)
+	switch {
+	case len(m.log) == 0:
+		lines = append(lines, statusMuted.Render("  No requests yet..."))
+	case len(entries) == 0:
+		lines = append(lines, statusMuted.Render("  No entries match the filter"))
+	case v.Detail:
+		lines = append(lines, renderLogDetail(entries[sel], w-6, h-len(lines)-4)...)
+	default:
+		visibleLines := h - len(lines) - 4
+		if visibleLines < 5 {
+			visibleLines = 15
+		}
+
+		// Keep the selected entry in view
+		start := 0
+		if sel >= visibleLines {
+			start = sel - visibleLines + 1
+		}
+		end := min(start+visibleLines, len(entries))
+
+		for i, entry := range entries[start:end] {
+			line := formatLogEntry(entry, w-6)
+			if start+i == sel {
+				line = statusSecondary.Render("▶ ") + strings.TrimPrefix(line, "  ")
+			}
+			lines = append(lines, line)
+		}
+
+		// Scroll indicator
+		if len(entries) > visibleLines {
+			pos := "top"
+			if start > 0 && end < len(entries) {
+				pos = fmt.Sprintf("%d/%d", sel+1, len(entries))
+			} else if end >= len(entries) {
+				pos = "end"
+			}
+			lines = append(lines, statusMuted.Render(fmt.Sprintf("  ── %s ──", pos)))
+		}
+	}
+
+	content := strings.Join(lines, "\n")
+	return activePanelStyle.Width(w - 4).Render(content)
+}
+
+// renderLogDetail renders one entry in full, with its request and response
+// bodies, in at most h lines
+func renderLogDetail(e LogEntry, w, h int) []string {
+	status := "-"
+	if e.StatusCode != 0 {
+		status = strconv.Itoa(e.StatusCode)
+	}
+	if e.IsError {
+		status += " " + statusError.Render("error")
+	}
+	lines := []string{
+		labelStyle.Render("  Time:") + " " + e.Time.Format("2006-01-02 15:04:05.000"),
+		labelStyle.Render("  Request:") + " " + logMethod.Render(e.Method) + " " + e.Path,
+		labelStyle.Render("  Status:") + " " + status,
+		labelStyle.Render("  Latency:") + " " + e.Latency.Round(100*time.Microsecond).String(),
+		labelStyle.Render("  Detail:") + " " + truncate(e.Detail, max(0, w-20)),
+	}
+	for _, body := range []struct{ title, text string }{
+		{"Request body", e.RequestBody},
+		{"Response body", e.ResponseBody},
+	} {
+		lines = append(lines, "", sectionHeader.Render("  "+body.title))
+		if body.text == "" {
+			lines = append(lines, statusMuted.Render("  (none)"))
+			continue
+		}
+		for _, l := range strings.Split(strings.TrimRight(prettyBody(body.text), "\r\n"), "\n") {
+			lines = append(lines, "  "+truncate(l, max(0, w-4)))
+		}
+	}
+
+	if h < 8 {
+		h = 8
+	}
+	if len(lines) > h {
+		more := len(lines) - h + 1
+		lines = append(lines[:h-1], statusMuted.Render(fmt.Sprintf("  … %d more lines", more)))
+	}
+	return lines
+}
+
+// prettyBody indents a JSON body and leaves anything else as it is
+func prettyBody(body string) string {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(body), "", "  "); err != nil {
+		return body
+	}
+	return b.String()
+}
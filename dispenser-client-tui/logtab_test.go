@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	getHealth = LogEntry{Method: "GET", Path: "/health", StatusCode: 200, Detail: "idle, uptime 42s"}
+	postOK    = LogEntry{Method: "POST", Path: "/dispense", StatusCode: 200, Detail: "tx a1b2c3d4 dispensing"}
+	getBusy   = LogEntry{Method: "GET", Path: "/dispense/A1B2C3D4", StatusCode: 409, Detail: "busy", IsError: true}
+	timeout   = LogEntry{Method: "GET", Path: "/health", Detail: "context deadline exceeded", IsError: true}
+)
+
+func TestLogFilterMatch(t *testing.T) {
+	for _, tc := range []struct {
+		filter LogFilter
+		match  []LogEntry
+		skip   []LogEntry
+	}{
+		{LogFilter{}, []LogEntry{getHealth, postOK, getBusy, timeout}, nil},
+		{LogFilter{Method: "POST"}, []LogEntry{postOK}, []LogEntry{getHealth, getBusy}},
+		{LogFilter{Status: "2xx"}, []LogEntry{getHealth, postOK}, []LogEntry{getBusy, timeout}},
+		{LogFilter{Status: "4xx"}, []LogEntry{getBusy}, []LogEntry{postOK, timeout}},
+		{LogFilter{Status: "-"}, []LogEntry{timeout}, []LogEntry{getHealth}},
+		{LogFilter{ErrorsOnly: true}, []LogEntry{getBusy, timeout}, []LogEntry{getHealth, postOK}},
+		{LogFilter{Endpoint: "/dispense/{tx_id}"}, []LogEntry{getBusy}, []LogEntry{postOK, getHealth}},
+		// The query is case-insensitive and searches path and detail
+		{LogFilter{Query: "a1b2"}, []LogEntry{postOK, getBusy}, []LogEntry{getHealth}},
+		{LogFilter{Query: "DEADLINE"}, []LogEntry{timeout}, []LogEntry{getBusy}},
+		// All set filters apply
+		{LogFilter{Method: "GET", ErrorsOnly: true, Query: "health"}, []LogEntry{timeout}, []LogEntry{getHealth, getBusy}},
+	} {
+		for _, e := range tc.match {
+			if !tc.filter.match(e) {
+				t.Errorf("%q does not match %s %s", tc.filter, e.Method, e.Path)
+			}
+		}
+		for _, e := range tc.skip {
+			if tc.filter.match(e) {
+				t.Errorf("%q matches %s %s %d", tc.filter, e.Method, e.Path, e.StatusCode)
+			}
+		}
+	}
+}
+
+func TestCycle(t *testing.T) {
+	values := []string{"GET", "POST"}
+	for _, tc := range []struct {
+		values    []string
+		cur, want string
+	}{
+		{values, "", "GET"},
+		{values, "GET", "POST"},
+		{values, "POST", ""},
+		{values, "PUT", ""}, // gone from the log
+		{nil, "", ""},
+	} {
+		if got := cycle(tc.values, tc.cur); got != tc.want {
+			t.Errorf("cycle(%v, %q) = %q, want %q", tc.values, tc.cur, got, tc.want)
+		}
+	}
+}
+
+// logModel returns a model whose log keeps size entries, filtered to POSTs
+func logModel(size int) *Model {
+	m := &Model{logSize: size}
+	m.logView.Filter.Method = "POST"
+	return m
+}
+
+// entry is the ith of an alternating GET, POST log
+func entry(i int) LogEntry {
+	e := LogEntry{Method: "GET", Path: "/health", Detail: fmt.Sprint(i)}
+	if i%2 == 1 {
+		e.Method, e.Path = "POST", "/dispense"
+	}
+	return e
+}
+
+func TestAppendLogKeepsSelection(t *testing.T) {
+	m := logModel(6)
+	for i := 0; i < 6; i++ {
+		m.appendLog(entry(i))
+	}
+	// POSTs 1, 3, 5; select 5
+	m.logView.Selected = 2
+
+	for i := 6; i < 10; i++ {
+		m.appendLog(entry(i))
+		entries := m.filteredLog()
+		if len(m.log) != 6 {
+			t.Fatalf("%d entries, want 6", len(m.log))
+		}
+		if sel := m.logSelected(entries); entries[sel].Detail != "5" {
+			t.Errorf("after entry %d: selected %s, want 5", i, entries[sel].Detail)
+		}
+	}
+
+	// The selected entry itself is trimmed: the oldest left is selected
+	for i := 10; i < 12; i++ {
+		m.appendLog(entry(i))
+	}
+	entries := m.filteredLog()
+	if sel := m.logSelected(entries); entries[sel].Detail != "7" {
+		t.Errorf("selected %s after it was trimmed, want 7, the oldest", entries[sel].Detail)
+	}
+}
+
+func TestAppendLogFollows(t *testing.T) {
+	m := logModel(4)
+	m.logView.Follow = true
+	for i := 0; i < 10; i++ {
+		m.appendLog(entry(i))
+		entries := m.filteredLog()
+		if i == 0 {
+			if sel := m.logSelected(entries); sel != -1 {
+				t.Errorf("selected %d without a POST", sel)
+			}
+			continue
+		}
+		newest := i - 1 + i%2
+		if sel := m.logSelected(entries); entries[sel].Detail != fmt.Sprint(newest) {
+			t.Errorf("after entry %d: selected %s, want %d, the newest POST", i, entries[sel].Detail, newest)
+		}
+	}
+}
+
+func TestWriteLogExport(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 250e6, time.UTC)
+	entries := []LogEntry{
+		{Time: at, Method: "POST", Path: "/dispense", StatusCode: 200, Latency: 12345 * time.Microsecond,
+			Detail: "tx a1b2c3d4 dispensing", RequestBody: `{"tx_id":"a1b2c3d4","quantity":2}`,
+			ResponseBody: "{\"tx_id\":\"a1b2c3d4\",\"state\":\"dispensing\"}\n"},
+		{Time: at.Add(time.Second), Method: "GET", Path: "/health", Latency: 3 * time.Second,
+			Detail: "timeout, \"retrying\"", IsError: true, ResponseBody: "<html>bad gateway</html>"},
+	}
+	dir := t.TempDir()
+
+	jsonl := filepath.Join(dir, "log.jsonl")
+	if err := writeLogExport(jsonl, "jsonl", entries); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jsonl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	want := []string{
+		`{"time":"2026-01-01T12:00:00.25Z","method":"POST","path":"/dispense","status":200,"latency_ms":12.345,` +
+			`"detail":"tx a1b2c3d4 dispensing","request_body":{"tx_id":"a1b2c3d4","quantity":2},` +
+			`"response_body":{"tx_id":"a1b2c3d4","state":"dispensing"}}`,
+		`{"time":"2026-01-01T12:00:01.25Z","method":"GET","path":"/health","latency_ms":3000,"error":true,` +
+			`"detail":"timeout, \"retrying\"","response_body":"\u003chtml\u003ebad gateway\u003c/html\u003e"}`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("JSONL has %d lines, want %d:\n%s", len(lines), len(want), data)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("JSONL line %d:\n got %s\nwant %s", i+1, lines[i], want[i])
+		}
+		if !json.Valid([]byte(lines[i])) {
+			t.Errorf("JSONL line %d is not JSON", i+1)
+		}
+	}
+
+	csvPath := filepath.Join(dir, "log.csv")
+	if err := writeLogExport(csvPath, "csv", entries); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := [][]string{
+		{"time", "method", "path", "status", "latency_ms", "error", "detail", "request_body", "response_body"},
+		{"2026-01-01T12:00:00.25Z", "POST", "/dispense", "200", "12.3", "false", "tx a1b2c3d4 dispensing",
+			`{"tx_id":"a1b2c3d4","quantity":2}`, `{"tx_id":"a1b2c3d4","state":"dispensing"}`},
+		{"2026-01-01T12:00:01.25Z", "GET", "/health", "0", "3000.0", "true", `timeout, "retrying"`,
+			"", "<html>bad gateway</html>"},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(wantCSV) {
+		t.Errorf("CSV:\n got %q\nwant %q", records, wantCSV)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/lifetime"
 )
 
 var (
@@ -14,9 +17,22 @@ var (
 )
 
 func main() {
-	endpoint := flag.String("endpoint", "http://192.168.4.20", "Dispenser base URL")
-	apiKey := flag.String("api-key", "", "API key for dispenser (or TOKEN_DISPENSER_API_KEY env)")
-	timeout := flag.Duration("timeout", 3*time.Second, "HTTP request timeout")
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
+	conn := addConnFlags(flag.CommandLine)
+	txPrefix := flag.String("tx-prefix", "", "Hex terminal prefix for generated transaction IDs")
+	soakFlags := addSoakFlags(flag.CommandLine, "soak-")
+	soakReport := flag.String("soak-report", "", "Soak report path without extension (default soak-<time>)")
+	lifetimePath := flag.String("lifetime", "", "File keeping lifetime counters across dispenser reboots (default: this session only)")
+	var dispensers dispenserFlags
+	flag.Var(&dispensers, "dispenser", "Named dispenser NAME=URL of a fleet, repeatable (shares --api-key)")
+	fleetPath := flag.String("fleet", "", "Fleet file listing [[dispenser]] entries")
+	logSize := flag.Int("log-size", maxLogEntries, "Entries kept in the request log")
+	relayURL := flag.String("relay", "", "dispenser-relay URL pushing dispense progress (default: poll the dispenser)")
 	showVersion := flag.Bool("version", false, "Show version")
 
 	flag.Usage = func() {
@@ -24,6 +40,21 @@ func main() {
 🪙 Token Dispenser TUI — k9s-style testing dashboard
 
 Usage: token-tui [flags]
+       token-tui <command> [flags] [args]
+
+Commands:
+  health                       Print dispenser health
+  dispense --qty N [--wait]    Dispense tokens (--tx-id to retry a transaction)
+  status <tx_id>               Print transaction status
+  watch [--interval 5s]        Poll health until interrupted
+  soak [--duration 1h]         Headless soak test, writes a Markdown/JSON report
+  recover --journal FILE       Resolve unfinished journaled transactions
+  reconcile --journal FILE     Compare the journal with dispenser metrics and history
+  config show [--profile P]    Print the effective settings and where they come from
+
+Commands accept --output json. Exit codes: 0 ok, 1 error, 2 usage,
+3 partial dispense, 4 jam/dispenser error, 5 busy, 6 unauthorized,
+7 unreachable, 8 tx not found / outcome unknown, 9 reconcile discrepancies.
 
 Flags:
 `)
@@ -32,45 +63,127 @@ Flags:
 Environment:
   TOKEN_DISPENSER_API_KEY   API key (alternative to --api-key)
   TOKEN_DISPENSER_ENDPOINT  Endpoint URL (alternative to --endpoint)
+  TOKEN_DISPENSER_JOURNAL   Journal file (alternative to --journal)
+  TOKEN_DISPENSER_CONFIG    Config file (alternative to --config)
+  TOKEN_DISPENSER_PROFILE   Config profile (alternative to --profile)
+
+Settings come from flags, then environment, then the config profile, then
+the defaults.
 
 Examples:
   token-tui --endpoint http://192.168.4.20 --api-key mysecret
   TOKEN_DISPENSER_API_KEY=mysecret token-tui
+  token-tui dispense --qty 3 --wait --output json
+  token-tui --lifetime ~/.token-tui-lifetime.json
+  token-tui --relay http://localhost:8082
+  token-tui --dispenser sauna-1=http://192.168.4.20 --dispenser sauna-2=http://192.168.4.21
+  token-tui --fleet fleet.toml
+  token-tui --profile sauna-1
+  token-tui --record incident.jsonl          # replay with dispenser-replay
+
+Fleet file:
+  [[dispenser]]
+  name = "sauna-1"
+  endpoint = "http://192.168.4.20"
+  api_key = "..."               # optional, default --api-key
+  journal = "sauna-1.jsonl"     # optional, also lifetime = and relay =
+
+Config file (~/.config/token-tui/config.toml):
+  default_profile = "sauna-1"
+  [profiles.sauna-1]
+  endpoint = "http://192.168.4.20"
+  api_key_command = "pass show dispenser/sauna-1"   # or api_key = "..."
+  timeout_ms = 3000
+  health_interval_s = 5
+  poll_interval_ms = 250
+  expected_firmware = "1.1.0"
 
 Keys:
-  1/2/3/4    Switch tabs (Dashboard / Dispense / Log / Burst)
+  0          Fleet overview (with --dispenser or --fleet)
+  1-5        Switch tabs (Dashboard / Dispense / Test / Log / Soak)
   r          Refresh health
   q/Ctrl+C   Quit
-  ↑/↓        Adjust quantity / scroll log
+  ↑/↓        Adjust quantity / select log entry
+  /          Search the log (Log tab; M/S/P/E filter, Enter detail, x/X export)
   Enter      Start dispense / burst
 `)
 	}
 
 	flag.Parse()
+	if *logSize < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --log-size must be at least 1\n")
+		os.Exit(2)
+	}
 
 	if *showVersion {
 		fmt.Printf("token-tui %s\n", version)
 		os.Exit(0)
 	}
 
-	// Resolve API key
-	key := *apiKey
-	if key == "" {
-		key = os.Getenv("TOKEN_DISPENSER_API_KEY")
+	devices, err := loadFleet(*fleetPath, dispensers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if len(devices) > 0 {
+		if isSet(flag.CommandLine, "journal") || *lifetimePath != "" || *relayURL != "" {
+			fmt.Fprintf(os.Stderr, "Error: --journal, --lifetime and --relay are per dispenser in a fleet; set them in the fleet file\n")
+			os.Exit(2)
+		}
+		soakCfg, err := soakFlags.config()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		soakCfg.TxPrefix = *txPrefix
+		os.Exit(runFleet(conn, devices, *txPrefix, *logSize, soakCfg, *soakReport))
+	}
+
+	c, err := conn.client()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
 	}
-	if key == "" {
-		fmt.Fprintf(os.Stderr, "⚠  No API key provided. Use --api-key or TOKEN_DISPENSER_API_KEY env.\n")
+	if c.APIKey == "" {
+		fmt.Fprintf(os.Stderr, "⚠  No API key provided. Use --api-key, TOKEN_DISPENSER_API_KEY env or a config profile.\n")
 		fmt.Fprintf(os.Stderr, "   Health checks will work, but dispense operations will fail (401).\n\n")
 	}
+	closeJournal, err := conn.openJournal(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeJournal()
 
-	// Resolve endpoint
-	ep := *endpoint
-	if envEP := os.Getenv("TOKEN_DISPENSER_ENDPOINT"); envEP != "" && ep == "http://192.168.4.20" {
-		ep = envEP
+	txIDs, err := client.NewTxIDGenerator(client.TxIDOptions{Prefix: *txPrefix, Sequence: *txPrefix != ""})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 
-	client := NewDispenserClient(ep, key, *timeout)
-	model := NewModel(client)
+	soakCfg, err := soakFlags.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	soakCfg.TxPrefix = *txPrefix
+
+	model := NewModel(c, txIDs, clock.Real)
+	if *lifetimePath != "" {
+		tracker, err := lifetime.Open(*lifetimePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		model.lifetime = tracker
+	}
+	model.relayURL = *relayURL
+	model.healthEvery = conn.healthInterval
+	model.pollEvery = conn.pollInterval
+	model.expectedFirmware = conn.expectedFirmware
+	model.logSize = *logSize
+	model.soak.Config = soakCfg
+	model.soak.ReportBase = *soakReport
 
 	p := tea.NewProgram(
 		model,
@@ -79,6 +192,7 @@ Keys:
 	)
 
 	if _, err := p.Run(); err != nil {
+		closeJournal()
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/google/uuid"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/lifetime"
+	"token-tui/dispenser/soak"
+	"token-tui/dispenser/timebase"
 )
 
 // View modes
@@ -16,13 +22,15 @@ const (
 	viewDispense
 	viewTest // Renamed from viewBurst
 	viewLog
+	viewSoak
 )
 
 const (
-	maxLogEntries     = 100
+	maxLogEntries     = 1000
 	maxLatencySamples = 60
 	healthInterval    = 5 * time.Second
 	pollInterval      = 250 * time.Millisecond
+	recoverTimeout    = 2 * time.Minute
 )
 
 // LogEntry represents one API call in the request log
@@ -34,6 +42,9 @@ type LogEntry struct {
 	Latency    time.Duration
 	Detail     string
 	IsError    bool
+	// Raw bodies of HTTP requests, for the detail pane and export
+	RequestBody  string
+	ResponseBody string
 }
 
 // DispenseState tracks an active dispense operation
@@ -41,24 +52,46 @@ type DispenseState struct {
 	TxID      string
 	Quantity  int
 	Dispensed int
-	State     string // "dispensing", "done", "error"
+	State     string // "queued", "dispensing", "done", "error"
 	Error     string
 	StartTime time.Time
+
+	QueuePosition int // position at a dispenser-gateway while queued
+}
+
+// inProgress reports whether a dispense is queued or running
+func (d *DispenseState) inProgress() bool {
+	return d != nil && (d.State == "dispensing" || d.State == client.StateQueued)
 }
 
 // TestState tracks a test cycle
 type TestState struct {
-	Preset      int           // 0=custom, 1=single, 2=typical, 3=stress
-	CustomQty   int           // Custom quantity (1-20)
-	Running     bool          // Test in progress
-	LastResult  string        // Last test result message
-	LastSuccess bool          // Last test succeeded
-	LastTime    time.Duration // Last test duration
+	Preset        int           // 1=single, 2=typical, 3=stress, 4=custom
+	CustomQty     int           // Custom quantity (1-20)
+	Runs          int           // Runs per cycle (1-999)
+	StopOnFailure bool          // Stop at the first failed run
+	Running       bool          // Test in progress
+	Aborted       bool          // Abort requested, stop after the current run
+	Qty           int           // Quantity of the running cycle
+	Results       []TestRun     // Finished runs of the current/last cycle
+	StopReason    string        // Why the last cycle ended early
+	LastResult    string        // Last test result message
+	LastSuccess   bool          // Last test succeeded
+	LastTime      time.Duration // Last test duration
+
+	runStart  time.Time // start of the current run
+	waitSince time.Time // start of the current wait for idle
 }
 
 // Model is the main Bubble Tea model
 type Model struct {
-	client *DispenserClient
+	client *client.DispenserClient
+	txIDs  *client.TxIDGenerator
+	clock  clock.Clock
+
+	// Set when the model is one dispenser of a fleet
+	name  string
+	fleet bool
 
 	// Current view
 	mode     viewMode
@@ -67,22 +100,45 @@ type Model struct {
 	quitting bool
 
 	// Health data
-	health        *HealthResponse
-	healthErr     error
-	lastHealthAt  time.Time
-	connected     bool
+	health         *client.HealthResponse
+	healthErr      error
+	lastHealthAt   time.Time
+	connected      bool
 	latencySamples []float64 // rolling latency in ms
 
+	// Refresh and poll intervals, and the firmware version to expect, from
+	// the flags or the config profile
+	healthEvery      time.Duration
+	pollEvery        time.Duration
+	expectedFirmware string
+
+	// Wall-clock mapping of firmware uptime timestamps
+	timebase *timebase.TimeBase
+
+	// Counters across dispenser reboots
+	lifetime    *lifetime.Tracker
+	lifetimeErr string // last state save error, logged once
+
 	// Dispense state
 	dispense     *DispenseState
 	dispQuantity int // quantity selector (1-20)
 
+	// dispenser-relay pushing dispense progress; polled without one, or
+	// while it is unavailable
+	relayURL    string
+	relayEvents <-chan client.Event
+	relayCancel context.CancelFunc
+
 	// Test cycle (replaces burst)
 	test TestState
 
-	// Request log
-	log       []LogEntry
-	logScroll int
+	// Soak test
+	soak SoakState
+
+	// Request log, the newest logSize entries
+	log     []LogEntry
+	logSize int
+	logView LogView
 
 	// Debug mode
 	debugMode bool
@@ -91,62 +147,107 @@ type Model struct {
 	ticker int // animation frame counter
 }
 
-func NewModel(client *DispenserClient) Model {
+func NewModel(c *client.DispenserClient, txIDs *client.TxIDGenerator, clk clock.Clock) Model {
 	return Model{
-		client:         client,
+		client:         c,
+		txIDs:          txIDs,
+		clock:          clk,
 		mode:           viewDashboard,
 		dispQuantity:   3,
 		latencySamples: make([]float64, 0, maxLatencySamples),
-		log:            make([]LogEntry, 0, maxLogEntries),
+		healthEvery:    healthInterval,
+		pollEvery:      pollInterval,
+		timebase:       &timebase.TimeBase{},
+		lifetime:       &lifetime.Tracker{Clock: clk},
+		logSize:        maxLogEntries,
+		logView:        LogView{Follow: true},
 		test: TestState{
-			Preset:    2, // Default to "typical purchase"
-			CustomQty: 5,
+			Preset:        2, // Default to "typical purchase"
+			CustomQty:     5,
+			Runs:          1,
+			StopOnFailure: true,
 		},
+		soak: SoakState{Config: soak.DefaultConfig()},
 	}
 }
 
+// firmwareMismatch reports whether the dispenser runs another firmware
+// version than the profile expects
+func (m Model) firmwareMismatch() bool {
+	return m.health != nil && m.expectedFirmware != "" && m.health.Firmware != m.expectedFirmware
+}
+
 // --- Tea messages ---
 
 type tickMsg time.Time
 type healthResultMsg struct {
-	health *HealthResponse
-	result APIResult
+	health *client.HealthResponse
+	result client.APIResult
 }
 type dispenseStartMsg struct {
-	resp   *DispenseResponse
-	result APIResult
+	resp   *client.DispenseResponse
+	result client.APIResult
 }
 type dispensePollMsg struct {
-	resp   *DispenseResponse
-	result APIResult
+	resp   *client.DispenseResponse
+	result client.APIResult
+}
+type relaySubscribedMsg struct {
+	txID   string
+	events <-chan client.Event
+	cancel context.CancelFunc
+	err    error
+}
+type relayEventMsg struct {
+	txID  string
+	event client.Event
+	ok    bool // false once the stream is closed
+}
+type journalRecoveredMsg struct {
+	recovered []client.Recovery
+	review    []client.JournalRecord
+	err       error
 }
 type testCycleMsg struct {
-	success bool
-	message string
-	elapsed time.Duration
+	health *client.HealthResponse
+	result client.APIResult
 }
 
 // --- Commands ---
 
-func tickCmd() tea.Cmd {
-	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+// after works like tea.Tick but waits on the model's clock, so tests can
+// drive the UI with virtual time
+func (m Model) after(d time.Duration, fn func(time.Time) tea.Msg) tea.Cmd {
+	clk := m.clock
+	return func() tea.Msg {
+		return fn(<-clk.After(d))
+	}
+}
+
+func (m Model) tickCmd() tea.Cmd {
+	return m.after(time.Second, func(t time.Time) tea.Msg {
 		return tickMsg(t)
 	})
 }
 
 func (m Model) fetchHealth() tea.Cmd {
 	return func() tea.Msg {
-		health, result := m.client.Health()
+		health, result := m.client.Health(context.Background())
 		return healthResultMsg{health: health, result: result}
 	}
 }
 
 func (m Model) startDispense() tea.Cmd {
-	txID := uuid.New().String()[:8]
-	qty := m.dispQuantity
+	return m.startDispenseQty(m.dispQuantity)
+}
 
+func (m Model) startDispenseQty(qty int) tea.Cmd {
 	return func() tea.Msg {
-		resp, result := m.client.Dispense(txID, qty)
+		txID, err := m.txIDs.Next()
+		if err != nil {
+			return dispenseStartMsg{result: client.APIResult{Error: err}}
+		}
+		resp, result := m.client.Dispense(context.Background(), txID.String(), qty)
 		return dispenseStartMsg{resp: resp, result: result}
 	}
 }
@@ -157,23 +258,70 @@ func (m Model) pollDispense() tea.Cmd {
 	}
 	txID := m.dispense.TxID
 
-	return tea.Tick(pollInterval, func(t time.Time) tea.Msg {
-		resp, result := m.client.Status(txID)
+	return m.after(m.pollEvery, func(t time.Time) tea.Msg {
+		resp, result := m.client.Status(context.Background(), txID)
 		return dispensePollMsg{resp: resp, result: result}
 	})
 }
 
-func (m Model) runTestCycle() tea.Cmd {
-	// TODO: Implement test cycle execution (Task 8)
-	// Will run dispense based on selected preset or custom quantity
-	// and return testCycleMsg with results
-	return nil
+// followDispense tracks the current transaction through the relay if one
+// is configured, else by polling
+func (m Model) followDispense() tea.Cmd {
+	if m.dispense == nil {
+		return nil
+	}
+	if m.relayURL == "" {
+		return m.pollDispense()
+	}
+	txID := m.dispense.TxID
+	return func() tea.Msg {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := m.client.Subscribe(ctx, m.relayURL, client.SubscribeOptions{TxID: txID})
+		if err != nil {
+			cancel()
+		}
+		return relaySubscribedMsg{txID: txID, events: events, cancel: cancel, err: err}
+	}
+}
+
+// nextRelayEvent waits for the next event of the relay subscription
+func (m Model) nextRelayEvent(txID string) tea.Cmd {
+	events := m.relayEvents
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		ev, ok := <-events
+		return relayEventMsg{txID: txID, event: ev, ok: ok}
+	}
+}
+
+// stopRelay ends the relay subscription, if any
+func (m *Model) stopRelay() {
+	if m.relayCancel != nil {
+		m.relayCancel()
+	}
+	m.relayEvents = nil
+	m.relayCancel = nil
+}
+
+// recoverJournal resolves transactions left unfinished by an earlier run
+func (m Model) recoverJournal() tea.Cmd {
+	if m.client.Journal == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), recoverTimeout)
+		defer cancel()
+		recovered, err := m.client.Recover(ctx, client.WaitOptions{PollInterval: m.pollEvery})
+		return journalRecoveredMsg{recovered: recovered, review: m.client.Journal.NeedsReview(), err: err}
+	}
 }
 
 // --- Init ---
 
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(tickCmd(), m.fetchHealth())
+	return tea.Batch(m.tickCmd(), m.fetchHealth(), m.recoverJournal())
 }
 
 // --- Update ---
@@ -192,36 +340,45 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tickMsg:
 		m.ticker++
 		var cmds []tea.Cmd
-		cmds = append(cmds, tickCmd())
+		cmds = append(cmds, m.tickCmd())
 
 		// Auto-refresh health
-		if time.Since(m.lastHealthAt) >= healthInterval {
+		if m.clock.Since(m.lastHealthAt) >= m.healthEvery {
 			cmds = append(cmds, m.fetchHealth())
 		}
 		return m, tea.Batch(cmds...)
 
 	case healthResultMsg:
-		m.lastHealthAt = time.Now()
+		m.lastHealthAt = m.clock.Now()
 		if msg.result.Error != nil {
 			m.healthErr = msg.result.Error
 			m.connected = false
-			m.addLog("GET", "/health", 0, msg.result.Latency, msg.result.Error.Error(), true)
+			m.logRequest("GET", "/health", msg.result, msg.result.Error.Error())
 		} else {
 			m.health = msg.health
 			m.healthErr = nil
 			m.connected = true
 			m.addLatency(msg.result.Latency)
-			m.addLog("GET", "/health", 200, msg.result.Latency, fmt.Sprintf("status=%s dispenser=%s", msg.health.Status, msg.health.Dispenser), false)
+			m.logRequest("GET", "/health", msg.result, fmt.Sprintf("status=%s dispenser=%s", msg.health.Status, msg.health.Dispenser))
+			m.timebase.Observe(msg.health, m.lastHealthAt, msg.result.Latency)
+			m.observeLifetime(msg.health)
 		}
 		return m, nil
 
 	case dispenseStartMsg:
 		if msg.result.Error != nil {
-			m.addLog("POST", "/dispense", msg.result.StatusCode, msg.result.Latency, msg.result.Error.Error(), true)
+			m.logRequest("POST", "/dispense", msg.result, msg.result.Error.Error())
 			m.dispense = &DispenseState{
 				State: "error",
 				Error: msg.result.Error.Error(),
 			}
+			if m.test.Running {
+				return m, m.recordTestRun(TestRun{
+					Quantity: m.test.Qty,
+					Outcome:  client.OutcomeFailed,
+					Error:    msg.result.Error.Error(),
+				})
+			}
 			return m, nil
 		}
 		m.dispense = &DispenseState{
@@ -229,66 +386,114 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			Quantity:  msg.resp.Quantity,
 			Dispensed: msg.resp.Dispensed,
 			State:     msg.resp.State,
-			StartTime: time.Now(),
+			StartTime: m.clock.Now(),
+
+			QueuePosition: msg.resp.QueuePosition,
 		}
 		m.addLatency(msg.result.Latency)
-		m.addLog("POST", "/dispense", 200, msg.result.Latency,
-			fmt.Sprintf("tx=%s qty=%d state=%s", msg.resp.TxID, msg.resp.Quantity, msg.resp.State), false)
+		m.logRequest("POST", "/dispense", msg.result,
+			fmt.Sprintf("tx=%s qty=%d state=%s", msg.resp.TxID, msg.resp.Quantity, msg.resp.State))
 
-		if msg.resp.State == "dispensing" {
-			return m, m.pollDispense()
+		if msg.resp.InProgress() {
+			return m, m.followDispense()
 		}
 		return m, nil
 
+	case relaySubscribedMsg:
+		if m.dispense == nil || m.dispense.TxID != msg.txID {
+			if msg.cancel != nil {
+				msg.cancel()
+			}
+			return m, nil
+		}
+		if msg.err != nil {
+			m.addLog("SSE", "/events", 0, 0, "relay unavailable, polling: "+msg.err.Error(), true)
+			return m, m.pollDispense()
+		}
+		m.stopRelay()
+		m.relayEvents, m.relayCancel = msg.events, msg.cancel
+		return m, m.nextRelayEvent(msg.txID)
+
+	case relayEventMsg:
+		if m.dispense == nil || m.dispense.TxID != msg.txID || m.relayEvents == nil {
+			return m, nil
+		}
+		switch {
+		case !msg.ok, msg.event.Type == client.EventDisconnected:
+			// Closed by the relay before the final state, e.g. because it
+			// lost track of the transaction
+			m.stopRelay()
+			detail := "relay stream closed, polling"
+			if msg.event.Message != "" {
+				detail = "relay disconnected, polling: " + msg.event.Message
+			}
+			m.addLog("SSE", "/events", 0, 0, detail, true)
+			if m.dispense.inProgress() {
+				return m, m.pollDispense()
+			}
+			return m, nil
+		case msg.event.Dispense == nil:
+			return m, m.nextRelayEvent(msg.txID)
+		}
+		resp := msg.event.Dispense
+		m.addLog("SSE", "/dispense/"+resp.TxID, 0, 0,
+			fmt.Sprintf("dispensed=%d/%d state=%s", resp.Dispensed, resp.Quantity, resp.State), false)
+		if cmd := m.updateDispense(resp); cmd != nil {
+			m.stopRelay()
+			return m, cmd
+		}
+		return m, m.nextRelayEvent(msg.txID)
+
 	case dispensePollMsg:
 		if msg.result.Error != nil {
-			m.addLog("GET", "/dispense/"+m.dispense.TxID, msg.result.StatusCode, msg.result.Latency, msg.result.Error.Error(), true)
+			m.logRequest("GET", "/dispense/"+m.dispense.TxID, msg.result, msg.result.Error.Error())
 			return m, m.pollDispense() // keep polling on transient errors
 		}
 
-		m.dispense.Dispensed = msg.resp.Dispensed
-		m.dispense.State = msg.resp.State
-		m.dispense.Error = msg.resp.Error
 		m.addLatency(msg.result.Latency)
-		m.addLog("GET", "/dispense/"+msg.resp.TxID, 200, msg.result.Latency,
-			fmt.Sprintf("dispensed=%d/%d state=%s", msg.resp.Dispensed, msg.resp.Quantity, msg.resp.State), false)
-
-		if msg.resp.State == "dispensing" {
-			return m, m.pollDispense()
+		m.logRequest("GET", "/dispense/"+msg.resp.TxID, msg.result,
+			fmt.Sprintf("dispensed=%d/%d state=%s", msg.resp.Dispensed, msg.resp.Quantity, msg.resp.State))
+		if cmd := m.updateDispense(msg.resp); cmd != nil {
+			return m, cmd
 		}
-
-		// Done or error - record result if test was running
-		if m.test.Running {
-			m.test.Running = false
-			elapsed := time.Since(m.dispense.StartTime)
-			m.test.LastTime = elapsed
-
-			if msg.resp.State == "done" {
-				m.test.LastSuccess = true
-				m.test.LastResult = fmt.Sprintf("✓ Success - %d/%d tokens (%s)",
-					msg.resp.Dispensed, msg.resp.Quantity, elapsed.Truncate(10*time.Millisecond))
-			} else {
-				m.test.LastSuccess = false
-				errMsg := msg.resp.Error
-				if errMsg == "" {
-					errMsg = "unknown error"
-				}
-				m.test.LastResult = fmt.Sprintf("✗ Failed - %s (%d/%d tokens, %s)",
-					errMsg, msg.resp.Dispensed, msg.resp.Quantity, elapsed.Truncate(10*time.Millisecond))
+		return m, m.pollDispense()
+
+	case journalRecoveredMsg:
+		for _, r := range msg.recovered {
+			rec := r.Record
+			switch {
+			case r.Err != nil:
+				m.addLog("JRNL", "/dispense/"+rec.TxID, 0, 0, "recovery failed: "+r.Err.Error(), true)
+			case rec.State != client.JournalUnknown: // listed with the review below
+				m.addLog("JRNL", "/dispense/"+rec.TxID, 0, 0,
+					fmt.Sprintf("recovered: state=%s dispensed=%d/%d", rec.State, rec.Dispensed, rec.Quantity), false)
 			}
 		}
-
-		// Refresh health and stop polling
-		return m, m.fetchHealth()
+		for _, rec := range msg.review {
+			m.addLog("JRNL", "/dispense/"+rec.TxID, 0, 0,
+				fmt.Sprintf("unknown outcome, review manually (qty %d, %s)", rec.Quantity, rec.Note), true)
+		}
+		if msg.err != nil {
+			m.addLog("JRNL", "recover", 0, 0, msg.err.Error(), true)
+		}
+		return m, nil
 
 	case testCycleMsg:
-		// TODO: Implement test cycle result handling (Task 10)
-		// Will update m.test.LastResult, LastSuccess, LastTime
-		m.test.Running = false
-		m.test.LastResult = msg.message
-		m.test.LastSuccess = msg.success
-		m.test.LastTime = msg.elapsed
-		return m, m.fetchHealth()
+		return m, m.handleTestIdle(msg)
+
+	case soakEventMsg:
+		return m, m.handleSoakEvent(msg)
+
+	case logExportedMsg:
+		m.handleLogExported(msg)
+		return m, nil
+
+	case soakDoneMsg:
+		cmd := m.handleSoakDone(msg)
+		if m.quitting {
+			return m, tea.Quit
+		}
+		return m, cmd
 
 	}
 
@@ -298,10 +503,21 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	key := msg.String()
 
+	// The log search takes all keys while typing
+	if m.capturingKeys() && key != "ctrl+c" {
+		return m.handleLogSearchKey(msg)
+	}
+
 	// Global keys
 	switch key {
 	case "q", "ctrl+c":
 		m.quitting = true
+		if m.soak.Running {
+			// Quit once the soak has written its report
+			m.soak.Stopping = true
+			m.soak.cancel()
+			return m, nil
+		}
 		return m, tea.Quit
 
 	case "1":
@@ -316,6 +532,9 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "4":
 		m.mode = viewLog
 		return m, nil
+	case "5":
+		m.mode = viewSoak
+		return m, nil
 
 	case "r", "R":
 		return m, m.fetchHealth()
@@ -333,6 +552,8 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m.handleTestKeys(key)
 	case viewLog:
 		return m.handleLogKeys(key)
+	case viewSoak:
+		return m.handleSoakKeys(key)
 	}
 
 	return m, nil
@@ -349,7 +570,10 @@ func (m *Model) handleDispenseKeys(key string) (tea.Model, tea.Cmd) {
 			m.dispQuantity--
 		}
 	case "enter":
-		if m.dispense == nil || m.dispense.State != "dispensing" {
+		if m.test.Running || m.soak.Running {
+			return m, nil
+		}
+		if !m.dispense.inProgress() {
 			m.dispense = nil
 			return m, m.startDispense()
 		}
@@ -358,8 +582,11 @@ func (m *Model) handleDispenseKeys(key string) (tea.Model, tea.Cmd) {
 }
 
 func (m *Model) handleTestKeys(key string) (tea.Model, tea.Cmd) {
-	if m.dispense != nil && m.dispense.State == "dispensing" {
-		// Test running, no input allowed
+	if m.test.Running {
+		// Only abort is allowed while a cycle runs
+		if key == "x" || key == "X" || key == "esc" {
+			m.test.Aborted = true
+		}
 		return m, nil
 	}
 
@@ -372,6 +599,20 @@ func (m *Model) handleTestKeys(key string) (tea.Model, tea.Cmd) {
 		m.test.Preset = 3 // Stress test
 	case "4":
 		m.test.Preset = 4 // Custom
+	case "left", "h":
+		if m.test.Preset > 1 {
+			m.test.Preset--
+		}
+	case "right", "l":
+		if m.test.Preset < len(testPresets) {
+			m.test.Preset++
+		}
+	case "+", "=":
+		m.test.Runs = min(m.test.Runs+runsStep(m.test.Runs), maxTestRuns)
+	case "-", "_":
+		m.test.Runs = max(m.test.Runs-runsStep(m.test.Runs-1), 1)
+	case "f", "F":
+		m.test.StopOnFailure = !m.test.StopOnFailure
 	case "up", "k":
 		if m.test.Preset == 4 && m.test.CustomQty < 20 {
 			m.test.CustomQty++
@@ -381,76 +622,88 @@ func (m *Model) handleTestKeys(key string) (tea.Model, tea.Cmd) {
 			m.test.CustomQty--
 		}
 	case "enter":
-		// Start test with selected quantity
-		qty := 0
-		switch m.test.Preset {
-		case 1:
-			qty = 1
-		case 2:
-			qty = 3
-		case 3:
-			qty = 10
-		case 4:
-			qty = m.test.CustomQty
-		}
-
-		if qty > 0 {
-			m.dispQuantity = qty // Set quantity for dispense
-			m.dispense = nil     // Clear previous dispense state
-			m.test.Running = true
-			return m, m.startDispense()
-		}
+		return m, m.startTestCycle()
 	case "c", "C":
 		// Clear last result
 		m.test.LastResult = ""
 		m.test.LastSuccess = false
 		m.test.LastTime = 0
-	case "h", "H":
+		m.test.Results = nil
+		m.test.StopReason = ""
+	case "H":
 		// Force health refresh (useful after errors)
 		return m, m.fetchHealth()
 	}
 	return m, nil
 }
 
-func (m *Model) handleLogKeys(key string) (tea.Model, tea.Cmd) {
-	switch key {
-	case "up", "k":
-		if m.logScroll > 0 {
-			m.logScroll--
-		}
-	case "down", "j":
-		if m.logScroll < len(m.log)-1 {
-			m.logScroll++
-		}
-	case "G":
-		m.logScroll = max(0, len(m.log)-1)
-	case "g":
-		m.logScroll = 0
-	case "c", "C":
-		m.log = m.log[:0]
-		m.logScroll = 0
-	}
-	return m, nil
-}
-
 // --- Helpers ---
 
 func (m *Model) addLog(method, path string, status int, latency time.Duration, detail string, isError bool) {
-	entry := LogEntry{
-		Time:       time.Now(),
+	m.appendLog(LogEntry{
+		Time:       m.clock.Now(),
 		Method:     method,
 		Path:       path,
 		StatusCode: status,
 		Latency:    latency,
 		Detail:     detail,
 		IsError:    isError,
+	})
+}
+
+// logRequest adds an HTTP round trip to the log, with its raw bodies
+func (m *Model) logRequest(method, path string, result client.APIResult, detail string) {
+	m.appendLog(LogEntry{
+		Time:         m.clock.Now(),
+		Method:       method,
+		Path:         path,
+		StatusCode:   result.StatusCode,
+		Latency:      result.Latency,
+		Detail:       detail,
+		IsError:      result.Error != nil,
+		RequestBody:  string(result.RequestBody),
+		ResponseBody: string(result.ResponseBody),
+	})
+}
+
+// updateDispense applies a polled or pushed transaction state. Once it is
+// final it records the test cycle run or refreshes health and returns the
+// command for that; nil means the transaction is still in progress.
+func (m *Model) updateDispense(resp *client.DispenseResponse) tea.Cmd {
+	m.dispense.Dispensed = resp.Dispensed
+	m.dispense.QueuePosition = resp.QueuePosition
+	m.dispense.State = resp.State
+	m.dispense.Error = resp.Error
+	if resp.InProgress() {
+		return nil
+	}
+
+	// Done or error - record the run if a test cycle is active
+	if m.test.Running {
+		return m.recordTestRun(TestRun{
+			TxID:      resp.TxID,
+			Quantity:  resp.Quantity,
+			Dispensed: resp.Dispensed,
+			Outcome:   client.OutcomeOf(resp),
+			Error:     resp.Error,
+		})
+	}
+	return m.fetchHealth()
+}
+
+// observeLifetime feeds the lifetime tracker and logs detected reboots
+func (m *Model) observeLifetime(h *client.HealthResponse) {
+	reboot, err := m.lifetime.Observe(h)
+	if reboot != nil {
+		m.addLog("BOOT", "/health", 0, 0, reboot.String(), reboot.LastError != nil)
 	}
-	m.log = append(m.log, entry)
-	if len(m.log) > maxLogEntries {
-		m.log = m.log[1:]
+	switch {
+	case err != nil && err.Error() != m.lifetimeErr:
+		m.lifetimeErr = err.Error()
+		m.addLog("BOOT", "lifetime", 0, 0, "saving lifetime counters: "+err.Error(), true)
+	case err == nil:
+		m.lifetimeErr = ""
 	}
-	// Auto-scroll to bottom
-	m.logScroll = max(0, len(m.log)-1)
 }
 
 func (m *Model) addLatency(d time.Duration) {
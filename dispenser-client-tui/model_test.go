@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/clock"
+	"token-tui/dispenser/relay"
+	"token-tui/dispenser/sim"
+)
+
+// newSimModel returns a model for a simulator on a fake clock
+func newSimModel(t *testing.T) (Model, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := sim.DefaultConfig()
+	cfg.Clock = clk
+	srv := sim.NewServer(cfg)
+	t.Cleanup(srv.Close)
+	txIDs, err := client.NewTxIDGenerator(client.TxIDOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewModel(srv.DispenserClient(), txIDs, clk), clk
+}
+
+// runCmd runs cmd to its message while advancing the fake clock
+func runCmd(t *testing.T, clk *clock.Fake, cmd tea.Cmd) tea.Msg {
+	t.Helper()
+	if cmd == nil {
+		t.Fatal("no command")
+	}
+	done := make(chan tea.Msg, 1)
+	go func() { done <- cmd() }()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-done:
+			return msg
+		case <-timeout:
+			t.Fatal("command did not return")
+		default:
+			clk.Advance(50 * time.Millisecond)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+}
+
+// update feeds msg to m
+func update(m Model, msg tea.Msg) (Model, tea.Cmd) {
+	next, cmd := m.Update(msg)
+	return next.(Model), cmd
+}
+
+// pollToEnd feeds poll results until the dispense finished
+func pollToEnd(t *testing.T, m Model, clk *clock.Fake, cmd tea.Cmd) Model {
+	t.Helper()
+	for m.dispense.inProgress() {
+		msg := runCmd(t, clk, cmd)
+		if _, ok := msg.(dispensePollMsg); !ok {
+			t.Fatalf("got %T while following the dispense, want polling", msg)
+		}
+		m, cmd = update(m, msg)
+	}
+	return m
+}
+
+// logged reports whether the log has an entry containing detail
+func logged(m Model, detail string) bool {
+	for _, e := range m.log {
+		if strings.Contains(e.Detail, detail) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRelayUnavailableFallsBackToPolling(t *testing.T) {
+	m, clk := newSimModel(t)
+	down := httptest.NewServer(nil)
+	m.relayURL = down.URL
+	down.Close()
+
+	m, cmd := update(m, runCmd(t, clk, m.startDispenseQty(2)))
+	msg := runCmd(t, clk, cmd)
+	if sub, ok := msg.(relaySubscribedMsg); !ok || sub.err == nil {
+		t.Fatalf("got %#v, want a failed relay subscription", msg)
+	}
+	m, cmd = update(m, msg)
+	if !logged(m, "relay unavailable, polling") {
+		t.Error("fallback not logged")
+	}
+
+	m = pollToEnd(t, m, clk, cmd)
+	if m.dispense.State != "done" || m.dispense.Dispensed != 2 {
+		t.Errorf("dispense %+v, want done with 2", m.dispense)
+	}
+}
+
+func TestRelayDisconnectFallsBackToPolling(t *testing.T) {
+	m, clk := newSimModel(t)
+	r := relay.New(m.client)
+	r.Clock = clk
+	front := httptest.NewServer(r.Handler(m.client.APIKey))
+	defer front.Close()
+	m.relayURL = front.URL
+
+	m, cmd := update(m, runCmd(t, clk, m.startDispenseQty(2)))
+	msg := runCmd(t, clk, cmd)
+	if sub, ok := msg.(relaySubscribedMsg); !ok || sub.err != nil {
+		t.Fatalf("got %#v, want a relay subscription", msg)
+	}
+	m, cmd = update(m, msg)
+	defer m.stopRelay()
+
+	// The relay goes away mid-dispense
+	front.CloseClientConnections()
+	for {
+		msg := runCmd(t, clk, cmd)
+		ev, ok := msg.(relayEventMsg)
+		if !ok {
+			t.Fatalf("got %T, want relay events until the disconnect", msg)
+		}
+		m, cmd = update(m, msg)
+		if ev.event.Type == client.EventDisconnected {
+			break
+		}
+	}
+	if !logged(m, "relay disconnected, polling") || m.relayEvents != nil {
+		t.Error("still following the relay after it disconnected")
+	}
+
+	m = pollToEnd(t, m, clk, cmd)
+	if m.dispense.State != "done" || m.dispense.Dispensed != 2 {
+		t.Errorf("dispense %+v, want done with 2", m.dispense)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"token-tui/dispenser/soak"
+)
+
+const maxSoakEvents = 8
+
+// soakDurations are the steps +/- moves through on the Soak tab
+var soakDurations = []time.Duration{
+	15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 24 * time.Hour,
+}
+
+// SoakState tracks the soak run of the Soak tab
+type SoakState struct {
+	Config     soak.Config
+	ReportBase string // report path without extension; empty means soak-<time>
+
+	Running  bool
+	Stopping bool
+	Stats    soak.Stats
+	Events   []soak.Event // most recent last
+	Latency  []float64    // ms, per event
+	RSSI     []float64    // dBm, per event
+
+	Report   *soak.Report
+	MDPath   string
+	JSONPath string
+	Err      error
+
+	cancel context.CancelFunc
+	msgs   chan tea.Msg
+}
+
+type soakEventMsg struct {
+	event soak.Event
+}
+type soakDoneMsg struct {
+	report   *soak.Report
+	mdPath   string
+	jsonPath string
+	err      error
+}
+
+// listenSoak delivers the next message of the running soak
+func listenSoak(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+// startSoak runs the configured soak in the background. Its events come
+// back one at a time through listenSoak.
+func (m *Model) startSoak() tea.Cmd {
+	s := &m.soak
+	runner, err := soak.New(m.client, s.Config)
+	if err != nil {
+		s.Err = err
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan tea.Msg, 16)
+	*s = SoakState{Config: s.Config, ReportBase: s.ReportBase, Running: true, cancel: cancel, msgs: ch}
+
+	base := s.ReportBase
+	go func() {
+		rep, err := runner.Run(ctx, func(ev soak.Event) {
+			ch <- soakEventMsg{event: ev}
+		})
+		if err != nil {
+			ch <- soakDoneMsg{err: err}
+			return
+		}
+		if base == "" {
+			base = "soak-" + rep.Started.Format("20060102-150405")
+		}
+		mdPath, jsonPath, err := rep.WriteFiles(base)
+		ch <- soakDoneMsg{report: rep, mdPath: mdPath, jsonPath: jsonPath, err: err}
+	}()
+	return listenSoak(ch)
+}
+
+func (m *Model) handleSoakEvent(msg soakEventMsg) tea.Cmd {
+	s := &m.soak
+	ev := msg.event
+	s.Stats = ev.Stats
+	s.Events = append(s.Events, ev)
+	if len(s.Events) > maxSoakEvents {
+		s.Events = s.Events[1:]
+	}
+	if ev.Stats.LastLatency > 0 {
+		s.Latency = appendSample(s.Latency, float64(ev.Stats.LastLatency.Microseconds())/1000)
+	}
+	if ev.Stats.LastRSSI != 0 {
+		s.RSSI = appendSample(s.RSSI, float64(ev.Stats.LastRSSI))
+	}
+	return listenSoak(s.msgs)
+}
+
+func (m *Model) handleSoakDone(msg soakDoneMsg) tea.Cmd {
+	s := &m.soak
+	s.Running = false
+	s.Stopping = false
+	s.cancel()
+	s.Report = msg.report
+	if msg.report != nil {
+		s.Stats = msg.report.Stats
+	}
+	s.MDPath = msg.mdPath
+	s.JSONPath = msg.jsonPath
+	s.Err = msg.err
+	return m.fetchHealth()
+}
+
+func (m *Model) handleSoakKeys(key string) (tea.Model, tea.Cmd) {
+	s := &m.soak
+	if s.Running {
+		if key == "x" || key == "X" || key == "esc" {
+			s.Stopping = true
+			s.cancel()
+		}
+		return m, nil
+	}
+
+	switch key {
+	case "+", "=":
+		s.Config.Duration = stepDuration(s.Config.Duration, 1)
+	case "-", "_":
+		s.Config.Duration = stepDuration(s.Config.Duration, -1)
+	case "a", "A":
+		if s.Config.OnHopperLow == soak.ActionPause {
+			s.Config.OnHopperLow = soak.ActionAbort
+		} else {
+			s.Config.OnHopperLow = soak.ActionPause
+		}
+	case "f", "F":
+		if s.Config.OnFault == soak.ActionPause {
+			s.Config.OnFault = soak.ActionAbort
+		} else {
+			s.Config.OnFault = soak.ActionPause
+		}
+	case "enter":
+		if m.test.Running || m.dispense.inProgress() {
+			return m, nil
+		}
+		return m, m.startSoak()
+	}
+	return m, nil
+}
+
+// stepDuration moves d to the next larger or smaller soakDurations step
+func stepDuration(d time.Duration, dir int) time.Duration {
+	if dir > 0 {
+		for _, step := range soakDurations {
+			if step > d {
+				return step
+			}
+		}
+		return soakDurations[len(soakDurations)-1]
+	}
+	for i := len(soakDurations) - 1; i >= 0; i-- {
+		if soakDurations[i] < d {
+			return soakDurations[i]
+		}
+	}
+	return soakDurations[0]
+}
+
+func appendSample(samples []float64, v float64) []float64 {
+	samples = append(samples, v)
+	if len(samples) > maxLatencySamples {
+		samples = samples[1:]
+	}
+	return samples
+}
+
+// --- Soak View ---
+
+func (m Model) renderSoakView(w, h int) string {
+	s := m.soak
+	cfg := s.Config
+	var lines []string
+
+	title := "🔥 Soak Test"
+	switch {
+	case s.Running && s.Stats.Paused:
+		title += "  " + statusWarning.Render("⏸ PAUSED: "+s.Stats.PauseReason)
+	case s.Stopping:
+		title += "  " + statusWarning.Render("stopping...")
+	case s.Running:
+		title += "  " + dispensingStyle.Render("● RUNNING")
+	}
+	lines = append(lines, sectionHeader.Render(title))
+	lines = append(lines, "")
+
+	var limits []string
+	if cfg.Duration > 0 {
+		limits = append(limits, cfg.Duration.String())
+	}
+	if cfg.MaxTokens > 0 {
+		limits = append(limits, fmt.Sprintf("%d tokens", cfg.MaxTokens))
+	}
+	limit := strings.Join(limits, " or ")
+	lines = append(lines, labelStyle.Render("Pattern:")+" "+valueBold.Render(cfg.PatternString())+
+		statusMuted.Render(fmt.Sprintf(" every %s", cfg.Interval)))
+	lines = append(lines, labelStyle.Render("Limit:")+" "+valueBold.Render(limit))
+	lines = append(lines, labelStyle.Render("On hopper low:")+" "+valueBold.Render(string(cfg.OnHopperLow))+
+		"   "+statusMuted.Render("on fault: ")+valueBold.Render(string(cfg.OnFault)))
+
+	if s.Running || s.Report != nil {
+		st := s.Stats
+		lines = append(lines, "")
+		progress := fmt.Sprintf("%s elapsed", st.Elapsed.Truncate(time.Second))
+		if cfg.Duration > 0 {
+			progress += fmt.Sprintf(" of %s", cfg.Duration)
+		}
+		lines = append(lines, labelStyle.Render("Progress:")+" "+valueBold.Render(progress))
+		lines = append(lines, labelStyle.Render("Dispenses:")+" "+valueBold.Render(fmt.Sprintf("%d", st.Dispenses))+
+			statusMuted.Render(fmt.Sprintf("  tokens %d/%d  unknown %d  rejected %d",
+				st.TokensDispensed, st.TokensRequested, st.Unknown, st.Rejected)))
+
+		jamStyle := statusOK
+		if st.JamRate() > 0 {
+			jamStyle = statusError
+		}
+		lines = append(lines, labelStyle.Render("Jam rate:")+" "+jamStyle.Render(fmt.Sprintf("%.2f%%", st.JamRate()))+
+			statusMuted.Render(fmt.Sprintf("  partial %.2f%%  reboots %d", st.PartialRate(), st.Reboots)))
+
+		codes := statusOK.Render("none")
+		if len(st.ErrorCodes) > 0 {
+			var parts []string
+			for _, ec := range st.ErrorCodes {
+				parts = append(parts, fmt.Sprintf("%d %s ×%d", ec.Code, ec.Type, ec.Count))
+			}
+			codes = statusError.Render(strings.Join(parts, ", "))
+		}
+		lines = append(lines, labelStyle.Render("Error codes:")+" "+codes)
+
+		if len(s.Latency) >= 2 {
+			lines = append(lines, labelStyle.Render("Latency (ms):")+renderSparkline(s.Latency, w-30))
+		}
+		if len(s.RSSI) >= 2 {
+			lines = append(lines, labelStyle.Render("RSSI:")+renderSparkline(s.RSSI, w-30)+" "+
+				statusMuted.Render(fmt.Sprintf("%d dBm", st.LastRSSI)))
+		}
+	}
+
+	if s.Report != nil {
+		lines = append(lines, "")
+		lines = append(lines, "  "+valueBold.Render("Finished: "+s.Report.StopReason))
+		if s.MDPath != "" {
+			lines = append(lines, statusMuted.Render(fmt.Sprintf("  Report: %s, %s", s.MDPath, s.JSONPath)))
+		}
+	}
+	if s.Err != nil {
+		lines = append(lines, "", errorStyle.Render("  ✗ "+s.Err.Error()))
+	}
+
+	lines = append(lines, "")
+	if s.Running {
+		lines = append(lines, fmt.Sprintf("  Press %s to stop and write the report", keyStyle.Render("X")))
+	} else {
+		lines = append(lines, fmt.Sprintf("  Press %s to start the soak", keyStyle.Render("ENTER")))
+	}
+
+	var b strings.Builder
+	b.WriteString(activePanelStyle.Width(w - 4).Render(strings.Join(lines, "\n")))
+	b.WriteString("\n")
+
+	// Recent soak events
+	var evLines []string
+	evLines = append(evLines, sectionHeader.Render("📋 Soak Events"))
+	if len(s.Events) == 0 {
+		evLines = append(evLines, statusMuted.Render("  no events yet..."))
+	}
+	for _, ev := range s.Events {
+		style := statusMuted
+		switch ev.Kind {
+		case soak.EventError, soak.EventReboot:
+			style = statusError
+		case soak.EventPause:
+			style = statusWarning
+		}
+		evLines = append(evLines, fmt.Sprintf("  %s %s %s", logTimestamp.Render(ev.Time.Format("15:04:05")),
+			style.Render(fmt.Sprintf("%-8s", ev.Kind)), truncate(ev.Message, max(10, w-30))))
+	}
+	b.WriteString(panelStyle.Width(w - 4).Render(strings.Join(evLines, "\n")))
+
+	return b.String()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"token-tui/dispenser/client"
+)
+
+const (
+	maxTestRuns     = 999
+	testIdleTimeout = 30 * time.Second // give up waiting for idle between runs
+)
+
+// testPresets are the quick test quantities; preset 4 is custom
+var testPresets = []struct {
+	name string
+	qty  int
+}{
+	{"Single token", 1},
+	{"Typical purchase", 3},
+	{"Stress test", 10},
+	{"Custom", 0},
+}
+
+// TestRun is the outcome of one run of a test cycle
+type TestRun struct {
+	TxID      string
+	Quantity  int
+	Dispensed int
+	Outcome   client.Outcome
+	Error     string
+	Duration  time.Duration
+}
+
+// Passed reports whether all tokens of the run were dispensed
+func (r TestRun) Passed() bool {
+	return r.Outcome == client.OutcomeDone
+}
+
+// TokensPerSecond is the dispense rate of the run
+func (r TestRun) TokensPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Dispensed) / r.Duration.Seconds()
+}
+
+// TestStats aggregates the runs of a test cycle
+type TestStats struct {
+	Runs     int
+	Passed   int
+	Failed   int
+	PassRate float64 // percent
+
+	Min, Mean, P50, P95, Max time.Duration
+	TokensPerSec             float64 // mean over runs that dispensed tokens
+}
+
+func computeTestStats(runs []TestRun) TestStats {
+	s := TestStats{Runs: len(runs)}
+	if len(runs) == 0 {
+		return s
+	}
+
+	durations := make([]time.Duration, 0, len(runs))
+	var total time.Duration
+	var rateSum float64
+	rated := 0
+	for _, r := range runs {
+		if r.Passed() {
+			s.Passed++
+		} else {
+			s.Failed++
+		}
+		durations = append(durations, r.Duration)
+		total += r.Duration
+		if r.Dispensed > 0 {
+			rateSum += r.TokensPerSecond()
+			rated++
+		}
+	}
+	s.PassRate = float64(s.Passed) / float64(len(runs)) * 100
+	if rated > 0 {
+		s.TokensPerSec = rateSum / float64(rated)
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	s.Min = durations[0]
+	s.Max = durations[len(durations)-1]
+	s.Mean = total / time.Duration(len(durations))
+	s.P50 = percentile(durations, 50)
+	s.P95 = percentile(durations, 95)
+	return s
+}
+
+// percentile uses the nearest-rank method on sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// runsStep makes +/- move in steps of 1, 10 or 100 depending on magnitude
+func runsStep(runs int) int {
+	switch {
+	case runs >= 100:
+		return 100
+	case runs >= 10:
+		return 10
+	default:
+		return 1
+	}
+}
+
+// quantity returns the token count of the selected preset
+func (t TestState) quantity() int {
+	if t.Preset == len(testPresets) {
+		return t.CustomQty
+	}
+	if t.Preset < 1 || t.Preset > len(testPresets) {
+		return 0
+	}
+	return testPresets[t.Preset-1].qty
+}
+
+// runTestCycle checks whether the dispenser is idle, after delay. The
+// answer comes back as testCycleMsg and starts the next run once idle.
+func (m Model) runTestCycle(delay time.Duration) tea.Cmd {
+	return m.after(delay, func(t time.Time) tea.Msg {
+		health, result := m.client.Health(context.Background())
+		return testCycleMsg{health: health, result: result}
+	})
+}
+
+// startTestCycle resets the results and begins the first run
+func (m *Model) startTestCycle() tea.Cmd {
+	qty := m.test.quantity()
+	if qty < 1 || m.soak.Running {
+		return nil
+	}
+	m.test.Running = true
+	m.test.Aborted = false
+	m.test.Qty = qty
+	m.test.Results = nil
+	m.test.LastResult = ""
+	m.test.waitSince = m.clock.Now()
+	m.dispense = nil
+	return m.runTestCycle(0)
+}
+
+// handleTestIdle starts the next run once the dispenser reports idle
+func (m *Model) handleTestIdle(msg testCycleMsg) tea.Cmd {
+	if msg.result.Error != nil {
+		m.logRequest("GET", "/health", msg.result, msg.result.Error.Error())
+	} else {
+		m.health = msg.health
+		m.connected = true
+		m.addLatency(msg.result.Latency)
+		m.logRequest("GET", "/health", msg.result,
+			fmt.Sprintf("status=%s dispenser=%s (test cycle)", msg.health.Status, msg.health.Dispenser))
+	}
+
+	if !m.test.Running {
+		return nil
+	}
+	if m.test.Aborted {
+		m.finishTestCycle("aborted")
+		return nil
+	}
+	if msg.health != nil && msg.health.Dispenser == "error" {
+		// Only a power cycle clears a jam, waiting would not help
+		m.finishTestCycle("dispenser in error state")
+		return nil
+	}
+	if msg.health == nil || msg.health.Dispenser != "idle" {
+		if m.clock.Since(m.test.waitSince) >= testIdleTimeout {
+			m.finishTestCycle(fmt.Sprintf("dispenser not idle after %s", testIdleTimeout))
+			return nil
+		}
+		return m.runTestCycle(m.pollEvery)
+	}
+
+	m.test.runStart = m.clock.Now()
+	m.dispense = nil
+	return m.startDispenseQty(m.test.Qty)
+}
+
+// recordTestRun stores a finished run and decides whether to continue
+func (m *Model) recordTestRun(run TestRun) tea.Cmd {
+	run.Duration = m.clock.Since(m.test.runStart)
+	m.test.Results = append(m.test.Results, run)
+
+	m.test.LastSuccess = run.Passed()
+	m.test.LastTime = run.Duration
+	if run.Passed() {
+		m.test.LastResult = fmt.Sprintf("✓ Success - %d/%d tokens (%s)",
+			run.Dispensed, run.Quantity, run.Duration.Truncate(10*time.Millisecond))
+	} else {
+		errMsg := run.Error
+		if errMsg == "" {
+			errMsg = "unknown error"
+		}
+		m.test.LastResult = fmt.Sprintf("✗ Failed - %s (%d/%d tokens, %s)",
+			errMsg, run.Dispensed, run.Quantity, run.Duration.Truncate(10*time.Millisecond))
+	}
+
+	switch {
+	case m.test.Aborted:
+		m.finishTestCycle("aborted")
+	case !run.Passed() && m.test.StopOnFailure:
+		m.finishTestCycle(fmt.Sprintf("stopped on failure in run %d", len(m.test.Results)))
+	case len(m.test.Results) >= m.test.Runs:
+		m.finishTestCycle("")
+	default:
+		m.test.waitSince = m.clock.Now()
+		return m.runTestCycle(0)
+	}
+	return m.fetchHealth()
+}
+
+func (m *Model) finishTestCycle(reason string) {
+	m.test.Running = false
+	m.test.StopReason = reason
+}
@@ -6,11 +6,14 @@ import (
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
+
+	"token-tui/dispenser/client"
+	"token-tui/dispenser/lifetime"
 )
 
 // View renders the full TUI
 func (m Model) View() string {
-	if m.quitting {
+	if m.quitting && !m.soak.Running {
 		return ""
 	}
 
@@ -43,6 +46,8 @@ func (m Model) View() string {
 		b.WriteString(m.renderLogView(w, h-5))
 	case viewTest:
 		b.WriteString(m.renderTestView(w, h-5))
+	case viewSoak:
+		b.WriteString(m.renderSoakView(w, h-5))
 	}
 
 	// Footer help
@@ -64,6 +69,9 @@ func (m Model) renderTitleBar(w int) string {
 	}
 
 	endpoint := statusMuted.Render(m.client.BaseURL)
+	if m.name != "" {
+		endpoint = valueBold.Render(m.name) + " " + endpoint
+	}
 
 	rightSide := fmt.Sprintf("%s  %s", endpoint, connStatus)
 	gap := w - lipgloss.Width(title) - lipgloss.Width(rightSide) - 1
@@ -86,9 +94,13 @@ func (m Model) renderTabBar(w int) string {
 		{"2", "Dispense", viewDispense},
 		{"3", "Test", viewTest},
 		{"4", "Log", viewLog},
+		{"5", "Soak", viewSoak},
 	}
 
 	var parts []string
+	if m.fleet {
+		parts = append(parts, lipgloss.NewStyle().Foreground(colorDim).Render(" 0:Fleet "))
+	}
 	for _, t := range tabs {
 		label := fmt.Sprintf(" %s:%s ", t.key, t.name)
 		if m.mode == t.mode {
@@ -165,11 +177,19 @@ func (m Model) renderHealthPanel(w int) string {
 		dispStr := renderDispenserState(hl.Dispenser)
 		lines = append(lines, labelStyle.Render("Dispenser:")+" "+dispStr)
 
-		// Uptime
-		lines = append(lines, labelStyle.Render("Uptime:")+" "+valueBold.Render(formatDuration(hl.Uptime)))
+		// Uptime, with the boot instant estimated from it
+		uptimeStr := valueBold.Render(formatDuration(hl.Uptime))
+		if est, ok := m.timebase.Estimate(); ok {
+			uptimeStr += statusMuted.Render(" since " + est.Boot.Local().Format("Jan 2 15:04"))
+		}
+		lines = append(lines, labelStyle.Render("Uptime:")+" "+uptimeStr)
 
 		// Firmware
-		lines = append(lines, labelStyle.Render("Firmware:")+" "+valueBold.Render(hl.Firmware))
+		firmwareStr := valueBold.Render(hl.Firmware)
+		if m.firmwareMismatch() {
+			firmwareStr = statusWarning.Render(hl.Firmware + " ⚠ expected " + m.expectedFirmware)
+		}
+		lines = append(lines, labelStyle.Render("Firmware:")+" "+firmwareStr)
 
 		// WiFi RSSI
 		if hl.WiFi != nil {
@@ -187,7 +207,8 @@ func (m Model) renderHealthPanel(w int) string {
 				errStyle = statusWarning // Sensor issues = yellow
 			}
 			lines = append(lines, labelStyle.Render("Hopper:")+
-				" "+errStyle.Render(fmt.Sprintf("⚠ %s", hl.Error.Type)))
+				" "+errStyle.Render(fmt.Sprintf("⚠ %s", hl.Error.Type))+
+				statusMuted.Render(" since "+m.timebase.Format(hl.Error.Timestamp, "15:04:05")))
 		} else if hl.GPIO != nil && hl.GPIO.HopperLow.Active {
 			lines = append(lines, labelStyle.Render("Hopper:")+" "+statusOK.Render("● OK"))
 		} else {
@@ -215,41 +236,47 @@ func (m Model) renderMetricsPanel(w int) string {
 		lines = append(lines, statusMuted.Render("  waiting for data..."))
 	} else {
 		met := m.health.Metrics
+		boot := m.lifetime.Boot()
+		life := m.lifetime.Lifetime()
+
+		// Since boot next to the lifetime total across reboots
+		lines = append(lines, labelStyle.Render("")+" "+statusMuted.Render(fmt.Sprintf("%7s %8s", "boot", "lifetime")))
+		counter := func(label string, boot, life int, style lipgloss.Style) string {
+			return labelStyle.Render(label) + " " + style.Render(fmt.Sprintf("%7d", boot)) +
+				" " + valueBold.Render(fmt.Sprintf("%8d", life))
+		}
 
 		// Total
-		lines = append(lines, labelStyle.Render("Total Dispenses:")+" "+valueBold.Render(fmt.Sprintf("%d", met.TotalDispenses)))
+		lines = append(lines, counter("Total Dispenses:", boot.TotalDispenses, life.TotalDispenses, valueBold))
 
 		// Success rate
-		rate := float64(0)
-		if met.TotalDispenses > 0 {
-			rate = float64(met.Successful) / float64(met.TotalDispenses) * 100
-		}
-		rateStyle := statusOK
-		if rate < 90 {
-			rateStyle = statusWarning
-		}
-		if rate < 80 {
-			rateStyle = statusError
-		}
-		lines = append(lines, labelStyle.Render("Success Rate:")+" "+rateStyle.Render(fmt.Sprintf("%.1f%%", rate))+
-			statusMuted.Render(fmt.Sprintf(" (%d/%d)", met.Successful, met.TotalDispenses)))
+		lines = append(lines, labelStyle.Render("Success Rate:")+" "+
+			rateStyle(boot).Render(fmt.Sprintf("%6.1f%%", boot.SuccessRate()))+" "+
+			rateStyle(life).Render(fmt.Sprintf("%7.1f%%", life.SuccessRate())))
 
 		// Jams
 		jamStyle := statusOK
-		if met.Jams > 0 {
+		if boot.Jams > 0 {
 			jamStyle = statusWarning
 		}
-		lines = append(lines, labelStyle.Render("Jams:")+" "+jamStyle.Render(fmt.Sprintf("%d", met.Jams)))
+		lines = append(lines, counter("Jams:", boot.Jams, life.Jams, jamStyle))
 
 		// Partial
-		lines = append(lines, labelStyle.Render("Partial:")+" "+valueBold.Render(fmt.Sprintf("%d", met.Partial)))
+		lines = append(lines, counter("Partial:", boot.Partial, life.Partial, valueBold))
 
 		// Failures
 		failStyle := statusOK
-		if met.Failures > 0 {
+		if boot.Failures > 0 {
 			failStyle = statusError
 		}
-		lines = append(lines, labelStyle.Render("Failures:")+" "+failStyle.Render(fmt.Sprintf("%d", met.Failures)))
+		lines = append(lines, counter("Failures:", boot.Failures, life.Failures, failStyle))
+
+		// Reboots seen by this client
+		rebootStr := valueBold.Render(fmt.Sprintf("%d", m.lifetime.State().Reboots))
+		if r, ok := m.lifetime.LastReboot(); ok {
+			rebootStr += statusMuted.Render(" (last " + formatAge(int64(m.clock.Since(r.Time).Seconds())) + ")")
+		}
+		lines = append(lines, labelStyle.Render("Reboots:")+" "+rebootStr)
 
 		// Last error
 		if met.LastError != "" {
@@ -262,6 +289,20 @@ func (m Model) renderMetricsPanel(w int) string {
 	return panelStyle.Width(w).Render(content)
 }
 
+// rateStyle colors a success rate
+func rateStyle(c lifetime.Counts) lipgloss.Style {
+	rate := c.SuccessRate()
+	switch {
+	case c.TotalDispenses == 0:
+		return statusMuted
+	case rate < 80:
+		return statusError
+	case rate < 90:
+		return statusWarning
+	}
+	return statusOK
+}
+
 func (m Model) renderLatencyPanel(w int) string {
 	var lines []string
 	lines = append(lines, sectionHeader.Render("📈 Latency")+" "+statusMuted.Render("(ms)"))
@@ -350,14 +391,17 @@ func (m Model) renderErrorHistoryPanel(w int) string {
 				}
 			}
 
-			// Format age
-			age := formatAge(time.Now().Unix() - err.Timestamp/1000)
+			// Timestamps are millis() since boot; show wall-clock time and age
+			when := m.timebase.Format(err.Timestamp, "15:04:05")
+			if t, _, ok := m.timebase.Time(err.Timestamp); ok {
+				when += "  " + formatAge(int64(m.clock.Now().Sub(t)/time.Second))
+			}
 
 			// Error type
 			typeStr := style.Render(fmt.Sprintf("%-15s", err.Type))
 
 			lines = append(lines, fmt.Sprintf("  %s %s %s",
-				status, typeStr, statusMuted.Render(age)))
+				status, typeStr, statusMuted.Render(when)))
 		}
 	}
 
@@ -432,9 +476,14 @@ func (m Model) renderDispenseProgress() []string {
 	lines = append(lines, bar)
 
 	// Coin drop animation
-	elapsed := time.Since(d.StartTime)
+	elapsed := m.clock.Since(d.StartTime)
 
 	switch d.State {
+	case client.StateQueued:
+		lines = append(lines, "")
+		lines = append(lines, dispensingStyle.Render(fmt.Sprintf("  QUEUED at the gateway, position %d", d.QueuePosition)))
+		lines = append(lines, statusMuted.Render(fmt.Sprintf("  waiting: %s", elapsed.Truncate(time.Millisecond))))
+
 	case "dispensing":
 		frames := []string{"🪙  ↓", " 🪙 ↓", "  🪙↓", "   🪙"}
 		frame := frames[m.ticker%len(frames)]
@@ -471,18 +520,31 @@ func (m Model) renderDispenseProgress() []string {
 func (m Model) renderTestView(w, h int) string {
 	var b strings.Builder
 
-	if m.dispense != nil && m.dispense.State == "dispensing" {
-		// Show running test with full progress (like dispense tab)
+	if m.test.Running {
+		// Show running cycle with full progress (like dispense tab)
 		var lines []string
-		lines = append(lines, sectionHeader.Render("🧪 Test Running"))
+		lines = append(lines, sectionHeader.Render(fmt.Sprintf("🧪 Test Running — run %d/%d",
+			min(len(m.test.Results)+1, m.test.Runs), m.test.Runs))+
+			"  "+statusMuted.Render(m.testModeLabel()))
 		lines = append(lines, "")
-		lines = append(lines, m.renderDispenseProgress()...)
+		if m.dispense.inProgress() {
+			lines = append(lines, m.renderDispenseProgress()...)
+		} else {
+			lines = append(lines, statusMuted.Render("  waiting for dispenser to become idle..."))
+		}
+		if m.test.Aborted {
+			lines = append(lines, "", statusWarning.Render("  stopping after the current run..."))
+		}
+		lines = append(lines, "")
+		lines = append(lines, m.renderTestStats(w-8)...)
+		lines = append(lines, "")
+		lines = append(lines, fmt.Sprintf("  Press %s to abort", keyStyle.Render("X")))
 
 		content := strings.Join(lines, "\n")
 		panel := activePanelStyle.Width(w - 4).Render(content)
 		b.WriteString(panel)
 		b.WriteString("\n\n")
-		b.WriteString(m.renderRecentLog(w-4, h-18))
+		b.WriteString(m.renderRecentLog(w-4, max(3, h-26)))
 		return b.String()
 	}
 
@@ -493,33 +555,25 @@ func (m Model) renderTestView(w, h int) string {
 	lines = append(lines, statusMuted.Render("  Quick Tests:"))
 	lines = append(lines, "")
 
-	// Preset options
-	presets := []struct {
-		key  string
-		name string
-		qty  int
-	}{
-		{"1", "Single token", 1},
-		{"2", "Typical purchase", 3},
-		{"3", "Stress test", 10},
-		{"4", "Custom", m.test.CustomQty},
-	}
-
-	for i, preset := range presets {
+	for i, preset := range testPresets {
 		selected := (m.test.Preset == i+1)
 		bullet := "  "
 		if selected {
 			bullet = "▶ "
 		}
 
-		line := fmt.Sprintf("%s[%s] %-17s (%d token", bullet, preset.key, preset.name, preset.qty)
-		if preset.qty != 1 {
+		qty := preset.qty
+		if i == len(testPresets)-1 {
+			qty = m.test.CustomQty
+		}
+		line := fmt.Sprintf("%s[%d] %-17s (%d token", bullet, i+1, preset.name, qty)
+		if qty != 1 {
 			line += "s"
 		}
 		line += ")"
 
 		if selected {
-			if i == 3 { // Custom
+			if i == len(testPresets)-1 { // Custom
 				line += "  " + statusMuted.Render("↑↓ to adjust")
 			}
 			lines = append(lines, valueBold.Render(line))
@@ -528,74 +582,97 @@ func (m Model) renderTestView(w, h int) string {
 		}
 	}
 
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("  Runs: %s  %s", valueBold.Render(fmt.Sprintf("%d", m.test.Runs)),
+		statusMuted.Render("(+/- to adjust)")))
+	lines = append(lines, fmt.Sprintf("  Mode: %s  %s", valueBold.Render(m.testModeLabel()),
+		statusMuted.Render("(F to toggle)")))
 	lines = append(lines, "")
 	lines = append(lines, fmt.Sprintf("  Press %s to run selected test", keyStyle.Render("ENTER")))
 	lines = append(lines, "")
 
-	// Show last test result if available
-	if m.test.LastResult != "" {
+	// Show results of the last cycle if available
+	if len(m.test.Results) > 0 {
 		lines = append(lines, statusMuted.Render("  ─────────────────────────────────────"))
-		lines = append(lines, "  Last Test Result:")
-		resultStyle := statusOK
-		if !m.test.LastSuccess {
-			resultStyle = statusError
+		lines = append(lines, m.renderTestStats(w-8)...)
+		if m.test.StopReason != "" {
+			lines = append(lines, statusWarning.Render("  Cycle ended early: "+m.test.StopReason))
 		}
-		lines = append(lines, "  "+resultStyle.Render(m.test.LastResult))
+	} else if m.test.StopReason != "" {
+		lines = append(lines, statusMuted.Render("  ─────────────────────────────────────"))
+		lines = append(lines, statusError.Render("  ✗ "+m.test.StopReason))
 	}
 
 	content := strings.Join(lines, "\n")
 	panel := activePanelStyle.Width(w - 4).Render(content)
 	b.WriteString(panel)
 	b.WriteString("\n\n")
-	b.WriteString(m.renderRecentLog(w-4, h-18))
+	b.WriteString(m.renderRecentLog(w-4, max(3, h-26)))
 
 	return b.String()
 }
 
-// --- Log View ---
-
-func (m Model) renderLogView(w, h int) string {
-	var lines []string
-
-	lines = append(lines, sectionHeader.Render(fmt.Sprintf("📋 Request Log (%d entries)", len(m.log)))+
-		"  "+statusMuted.Render("[C]lear  [g]top  [G]bottom"))
-	lines = append(lines, "")
-
-	if len(m.log) == 0 {
-		lines = append(lines, statusMuted.Render("  No requests yet..."))
-	} else {
-		visibleLines := h - 6
-		if visibleLines < 5 {
-			visibleLines = 15
-		}
+func (m Model) testModeLabel() string {
+	if m.test.StopOnFailure {
+		return "stop on first failure"
+	}
+	return "continue on failure"
+}
 
-		start := m.logScroll
-		end := start + visibleLines
-		if end > len(m.log) {
-			end = len(m.log)
-			start = max(0, end-visibleLines)
-		}
+// renderTestStats shows the aggregated results and the most recent runs
+func (m Model) renderTestStats(w int) []string {
+	runs := m.test.Results
+	if len(runs) == 0 {
+		return nil
+	}
+	st := computeTestStats(runs)
 
-		for _, entry := range m.log[start:end] {
-			lines = append(lines, formatLogEntry(entry, w-6))
-		}
+	var lines []string
+	rateStyle := statusOK
+	if st.Failed > 0 {
+		rateStyle = statusError
+	}
+	lines = append(lines, fmt.Sprintf("  Pass rate: %s %s   Tokens/s: %s",
+		rateStyle.Render(fmt.Sprintf("%.1f%%", st.PassRate)),
+		statusMuted.Render(fmt.Sprintf("(%d/%d)", st.Passed, st.Runs)),
+		valueBold.Render(fmt.Sprintf("%.2f", st.TokensPerSec))))
+	lines = append(lines, fmt.Sprintf("  Duration:  min:%s  avg:%s  p50:%s  p95:%s  max:%s",
+		statusOK.Render(formatRunDuration(st.Min)),
+		valueBold.Render(formatRunDuration(st.Mean)),
+		valueBold.Render(formatRunDuration(st.P50)),
+		statusWarning.Render(formatRunDuration(st.P95)),
+		statusWarning.Render(formatRunDuration(st.Max))))
 
-		// Scroll indicator
-		if len(m.log) > visibleLines {
-			pos := "top"
-			if start > 0 && end < len(m.log) {
-				pos = fmt.Sprintf("%d/%d", start+1, len(m.log))
-			} else if end >= len(m.log) {
-				pos = "end"
-			}
-			lines = append(lines, statusMuted.Render(fmt.Sprintf("  ── %s ──", pos)))
+	if len(runs) >= 2 {
+		samples := make([]float64, len(runs))
+		for i, r := range runs {
+			samples[i] = r.Duration.Seconds()
 		}
+		lines = append(lines, renderSparkline(samples, w-4))
 	}
 
-	content := strings.Join(lines, "\n")
-	return activePanelStyle.Width(w - 4).Render(content)
+	lines = append(lines, "")
+	start := max(0, len(runs)-5)
+	for i, r := range runs[start:] {
+		style := statusOK
+		mark := "✓"
+		if !r.Passed() {
+			style = statusError
+			mark = "✗"
+		}
+		line := fmt.Sprintf("  %s #%-3d %-16s %2d/%-2d %-8s %7s %5.2f tok/s",
+			mark, start+i+1, r.TxID, r.Dispensed, r.Quantity, r.Outcome,
+			formatRunDuration(r.Duration), r.TokensPerSecond())
+		if r.Error != "" {
+			line += " " + truncate(r.Error, max(0, w-70))
+		}
+		lines = append(lines, style.Render(line))
+	}
+	return lines
 }
 
+// --- Log View ---
+
 func (m Model) renderRecentLog(w, maxLines int) string {
 	var lines []string
 	lines = append(lines, sectionHeader.Render("📋 Recent Requests"))
@@ -617,10 +694,13 @@ func (m Model) renderRecentLog(w, maxLines int) string {
 
 func (m Model) renderFooter(w int) string {
 	pairs := []struct{ key, desc string }{
-		{"1-4", "tabs"},
+		{"1-5", "tabs"},
 		{"r", "refresh"},
 		{"q", "quit"},
 	}
+	if m.fleet {
+		pairs[0] = struct{ key, desc string }{"0-5", "tabs"}
+	}
 
 	switch m.mode {
 	case viewDispense:
@@ -630,17 +710,30 @@ func (m Model) renderFooter(w int) string {
 		}, pairs...)
 	case viewTest:
 		pairs = append([]struct{ key, desc string }{
-			{"1-4", "select"},
+			{"←→", "preset"},
 			{"↑↓", "qty"},
+			{"+/-", "runs"},
+			{"F", "mode"},
 			{"⏎", "run"},
+			{"X", "abort"},
 			{"C", "clear"},
 			{"H", "health"},
 		}, pairs...)
+	case viewSoak:
+		pairs = append([]struct{ key, desc string }{
+			{"⏎", "start"},
+			{"X", "stop"},
+			{"+/-", "duration"},
+			{"A", "hopper low"},
+			{"F", "fault"},
+		}, pairs...)
 	case viewLog:
 		pairs = append([]struct{ key, desc string }{
-			{"↑↓", "scroll"},
-			{"g/G", "top/bottom"},
-			{"C", "clear"},
+			{"↑↓", "select"},
+			{"⏎", "detail"},
+			{"/", "search"},
+			{"M/S/P/E", "filter"},
+			{"x/X", "export"},
 		}, pairs...)
 	}
 
@@ -787,8 +880,10 @@ func formatLogEntry(entry LogEntry, w int) string {
 
 	var statusStr string
 	switch {
-	case entry.StatusCode == 0:
+	case entry.StatusCode == 0 && entry.IsError:
 		statusStr = statusError.Render("ERR")
+	case entry.StatusCode == 0:
+		statusStr = statusMuted.Render("  -")
 	case entry.StatusCode < 300:
 		statusStr = logStatus200.Render(fmt.Sprintf("%d", entry.StatusCode))
 	case entry.StatusCode < 500:
@@ -821,7 +916,14 @@ func formatDuration(seconds int) string {
 	return fmt.Sprintf("%dh %dm", h, m)
 }
 
+func formatRunDuration(d time.Duration) string {
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
 func formatAge(seconds int64) string {
+	if seconds < 0 {
+		seconds = 0 // estimated times may land a little in the future
+	}
 	if seconds < 60 {
 		return fmt.Sprintf("%ds ago", seconds)
 	}
@@ -835,6 +937,9 @@ func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
 	}
+	if max <= 0 {
+		return ""
+	}
 	if max < 4 {
 		return s[:max]
 	}